@@ -0,0 +1,169 @@
+// Command mpibench measures ping-pong latency and bandwidth between two
+// ranks for each transport.
+//
+// By default both ranks run in this process over loopback, which compares
+// the overhead of the transports themselves:
+//
+//	go run ./cmd/mpibench -transports grpc,tcp,tcp+shm,memory -sizes 8,64K,4M
+//
+// A "+shm" suffix lets the two ranks talk through shared memory; without it
+// they stay on the named transport.
+//
+// Started as a job with MPI_RANK, MPI_SIZE and MPI_ADDRESS_<n> set, it
+// measures between ranks 0 and 1 over the transport chosen by MPI_TRANSPORT.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mpi "github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi/mpitest"
+)
+
+const tagPingPong = 7
+
+func main() {
+	transports := flag.String("transports", "grpc,tcp,tcp+shm,memory", "comma-separated transports to compare in-process")
+	sizes := flag.String("sizes", "8,1K,64K,1M,16M", "comma-separated message sizes, with optional K or M suffix")
+	iters := flag.Int("iters", 200, "round trips per message size")
+	flag.Parse()
+
+	msgSizes, err := parseSizes(*sizes)
+	if err != nil {
+		log.Fatalf("Invalid -sizes: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "transport\tsize\tlatency\tbandwidth\t")
+
+	if os.Getenv("MPI_RANK") != "" {
+		if err := mpi.MPI_Init(); err != nil {
+			log.Fatalf("Error initializing MPI: %v", err)
+		}
+		defer mpi.MPI_Finalize()
+		comm := mpi.World()
+		if comm.Size() < 2 {
+			log.Fatalf("mpibench needs at least 2 ranks")
+		}
+		results, err := pingPong(comm, msgSizes, *iters)
+		if err != nil {
+			log.Fatalf("Rank %d: %v", comm.Rank(), err)
+		}
+		if comm.Rank() == 0 {
+			report(w, comm.Transport(), results)
+			w.Flush()
+		}
+		return
+	}
+
+	for _, name := range strings.Split(*transports, ",") {
+		cfg := mpi.Config{Transport: strings.TrimSuffix(name, "+shm")}
+		cfg.DisableSharedMemory = cfg.Transport == name
+		var results []result
+		err := mpitest.RunConfig(2, cfg, func(comm *mpi.Comm) error {
+			r, err := pingPong(comm, msgSizes, *iters)
+			if comm.Rank() == 0 {
+				results = r
+			}
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Transport %s: %v", name, err)
+		}
+		report(w, name, results)
+	}
+	w.Flush()
+}
+
+type result struct {
+	size    int
+	latency time.Duration // Half the average round trip
+}
+
+// pingPong bounces a message of each size between ranks 0 and 1. Other ranks
+// take no part.
+func pingPong(comm *mpi.Comm, sizes []int, iters int) ([]result, error) {
+	rank := comm.Rank()
+	if rank > 1 {
+		return nil, nil
+	}
+	peer := 1 - rank
+	var results []result
+	for _, size := range sizes {
+		buf := make([]byte, size)
+		roundTrip := func() error {
+			if rank == 0 {
+				if err := comm.Send(buf, peer, tagPingPong); err != nil {
+					return err
+				}
+				_, err := comm.Recv(peer, tagPingPong)
+				return err
+			}
+			data, err := comm.Recv(peer, tagPingPong)
+			if err != nil {
+				return err
+			}
+			return comm.Send(data, peer, tagPingPong)
+		}
+
+		// Warm up connections and buffers before timing
+		for i := 0; i < 3; i++ {
+			if err := roundTrip(); err != nil {
+				return nil, err
+			}
+		}
+		start := time.Now()
+		for i := 0; i < iters; i++ {
+			if err := roundTrip(); err != nil {
+				return nil, err
+			}
+		}
+		elapsed := time.Since(start)
+		results = append(results, result{size: size, latency: elapsed / time.Duration(2*iters)})
+	}
+	return results, nil
+}
+
+func report(w *tabwriter.Writer, transport string, results []result) {
+	for _, r := range results {
+		mbps := float64(r.size) / r.latency.Seconds() / (1 << 20)
+		fmt.Fprintf(w, "%s\t%s\t%v\t%.1f MiB/s\t\n", transport, formatSize(r.size), r.latency.Round(100*time.Nanosecond), mbps)
+	}
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.ToUpper(strings.TrimSpace(field))
+		mult := 1
+		switch {
+		case strings.HasSuffix(field, "K"):
+			mult, field = 1<<10, strings.TrimSuffix(field, "K")
+		case strings.HasSuffix(field, "M"):
+			mult, field = 1<<20, strings.TrimSuffix(field, "M")
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad size %q", field)
+		}
+		sizes = append(sizes, n*mult)
+	}
+	return sizes, nil
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dK", n>>10)
+	}
+	return strconv.Itoa(n)
+}
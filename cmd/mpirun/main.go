@@ -0,0 +1,276 @@
+// Command mpirun starts an MPI job with all of its ranks on this host:
+//
+//	go run ./cmd/mpirun -np 4 ./myprogram args...
+//
+// It picks a free port for each rank and starts the program once per rank
+// with MPI_RANK, MPI_SIZE and MPI_ADDRESS_<n> set, and with a fresh
+// MPI_JOB_TOKEN unless one is set. Every line a rank writes to stdout or
+// stderr is prefixed with its rank, and rank 0 reads mpirun's stdin. Signals
+// sent to mpirun are forwarded to all ranks. If any rank fails, the others
+// are sent SIGTERM and killed after -grace, and mpirun exits with the failed
+// rank's status.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("mpirun: ")
+	opts, err := parseFlags(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	addresses, err := freeAddresses(opts.host, opts.np)
+	if err != nil {
+		log.Fatalf("Error picking ports: %v", err)
+	}
+	env, err := jobEnv(os.Environ(), addresses)
+	if err != nil {
+		log.Fatalf("Error setting up the job: %v", err)
+	}
+	os.Exit(run(opts.args, env, opts.np, opts.grace))
+}
+
+// options are the settings mpirun is started with
+type options struct {
+	np    int
+	host  string
+	grace time.Duration
+	args  []string // The program and its arguments
+}
+
+// parseFlags parses the command line args of the command called name. Flags
+// end at the program, so the program's own flags are passed on to it. On
+// failure the problem and the usage are written to output.
+func parseFlags(name string, args []string, output io.Writer) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.IntVar(&opts.np, "np", 1, "number of ranks to start")
+	fs.StringVar(&opts.host, "host", "127.0.0.1", "address the ranks listen on")
+	fs.DurationVar(&opts.grace, "grace", 5*time.Second, "time the other ranks get to exit after one fails before they are killed")
+	fs.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s [flags] program [args...]\n", name)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+	opts.args = fs.Args()
+	var err error
+	switch {
+	case len(opts.args) == 0:
+		err = errors.New("no program given")
+	case opts.np < 1:
+		err = fmt.Errorf("invalid number of ranks %d", opts.np)
+	}
+	if err != nil {
+		fmt.Fprintln(output, err)
+		fs.Usage()
+		return options{}, err
+	}
+	return opts, nil
+}
+
+// freeAddresses returns n addresses on host with ports that are free right
+// now. All listeners stay open until the last is chosen, so the ports differ.
+func freeAddresses(host string, n int) ([]string, error) {
+	addresses := make([]string, n)
+	for i := range addresses {
+		lis, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return nil, err
+		}
+		defer lis.Close()
+		addresses[i] = lis.Addr().String()
+	}
+	return addresses, nil
+}
+
+// jobEnv is the environment shared by all ranks: base, normally mpirun's
+// own, without the layout of any enclosing job, the layout of this one, and
+// a fresh job token unless base has one
+func jobEnv(base []string, addresses []string) ([]string, error) {
+	var env []string
+	hasToken := false
+	for _, kv := range base {
+		if strings.HasPrefix(kv, "MPI_RANK=") || strings.HasPrefix(kv, "MPI_SIZE=") || strings.HasPrefix(kv, "MPI_ADDRESS_") {
+			continue
+		}
+		if k, v, _ := strings.Cut(kv, "="); (k == "MPI_JOB_TOKEN" || k == "MPI_JOB_TOKEN_FILE") && v != "" {
+			hasToken = true
+		}
+		env = append(env, kv)
+	}
+	env = append(env, "MPI_SIZE="+strconv.Itoa(len(addresses)))
+	for i, addr := range addresses {
+		env = append(env, fmt.Sprintf("MPI_ADDRESS_%d=%s", i, addr))
+	}
+	if !hasToken {
+		token := make([]byte, 32)
+		if _, err := rand.Read(token); err != nil {
+			return nil, err
+		}
+		env = append(env, "MPI_JOB_TOKEN="+hex.EncodeToString(token))
+	}
+	return env, nil
+}
+
+// rankEnv is env, as built by jobEnv, for rank. env itself is left as it is.
+func rankEnv(env []string, rank int) []string {
+	return append(env[:len(env):len(env)], "MPI_RANK="+strconv.Itoa(rank))
+}
+
+type exit struct {
+	rank int
+	err  error
+}
+
+// run starts np ranks of the program in args and waits for all of them,
+// returning the exit code for mpirun
+func run(args []string, env []string, np int, grace time.Duration) int {
+	var stdoutMu, stderrMu sync.Mutex
+	cmds := make([]*exec.Cmd, 0, np)
+	exits := make(chan exit, np)
+
+	// Catch signals before starting anything, so none is missed
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigs)
+
+	for r := 0; r < np; r++ {
+		prefix := fmt.Sprintf("[%d] ", r)
+		stdout := &prefixWriter{prefix: prefix, w: os.Stdout, mu: &stdoutMu}
+		stderr := &prefixWriter{prefix: prefix, w: os.Stderr, mu: &stderrMu}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = rankEnv(env, r)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		// Children the rank leaves behind may hold on to its output; stop
+		// copying it soon after the rank itself exits
+		cmd.WaitDelay = time.Second
+		if r == 0 {
+			cmd.Stdin = os.Stdin
+		}
+		if err := cmd.Start(); err != nil {
+			log.Printf("Error starting rank %d: %v", r, err)
+			for _, started := range cmds {
+				started.Process.Kill()
+				started.Wait()
+			}
+			return 1
+		}
+		cmds = append(cmds, cmd)
+		go func(r int) {
+			err := cmd.Wait()
+			if errors.Is(err, exec.ErrWaitDelay) {
+				err = nil // The rank itself succeeded
+			}
+			stdout.Flush()
+			stderr.Flush()
+			exits <- exit{rank: r, err: err}
+		}(r)
+	}
+
+	exited := make([]bool, np)
+	signalAll := func(sig os.Signal) {
+		for r, cmd := range cmds {
+			if !exited[r] {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}
+	code := 0
+	stopping := false
+	var kill <-chan time.Time
+	for running := np; running > 0; {
+		select {
+		case e := <-exits:
+			running--
+			exited[e.rank] = true
+			if e.err == nil {
+				continue
+			}
+			if code == 0 {
+				code = exitCode(e.err)
+			}
+			if !stopping {
+				log.Printf("Rank %d failed: %v; stopping the other ranks", e.rank, e.err)
+				stopping = true
+				signalAll(syscall.SIGTERM)
+				kill = time.After(grace)
+			}
+		case sig := <-sigs:
+			stopping = true
+			signalAll(sig)
+		case <-kill:
+			log.Printf("Killing the ranks still running after %v", grace)
+			signalAll(os.Kill)
+		}
+	}
+	return code
+}
+
+func exitCode(err error) int {
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode() > 0 {
+		return ee.ExitCode()
+	}
+	return 1
+}
+
+// prefixWriter writes each complete line it is given to w behind prefix
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex // Shared by all writers to w, so their lines do not interleave
+	buf    []byte      // Start of a line not yet complete
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[:copy(p.buf, p.buf[i+1:])]
+	}
+	return len(b), nil
+}
+
+// Flush writes out a final line that has no newline
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(append([]byte(p.prefix), line...))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want options
+	}{
+		{
+			name: "defaults",
+			args: []string{"./prog"},
+			want: options{np: 1, host: "127.0.0.1", grace: 5 * time.Second, args: []string{"./prog"}},
+		},
+		{
+			name: "flags",
+			args: []string{"-np", "4", "-host", "10.0.0.1", "-grace", "2s", "./prog", "a"},
+			want: options{np: 4, host: "10.0.0.1", grace: 2 * time.Second, args: []string{"./prog", "a"}},
+		},
+		{
+			name: "program flags are passed on",
+			args: []string{"-np=2", "./prog", "-np", "8", "-v"},
+			want: options{np: 2, host: "127.0.0.1", grace: 5 * time.Second, args: []string{"./prog", "-np", "8", "-v"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlags("mpirun", tt.args, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no program", []string{"-np", "2"}, "no program given"},
+		{"no ranks", []string{"-np", "0", "./prog"}, "invalid number of ranks 0"},
+		{"negative ranks", []string{"-np", "-3", "./prog"}, "invalid number of ranks -3"},
+		{"bad number", []string{"-np", "many", "./prog"}, "invalid value"},
+		{"bad duration", []string{"-grace", "soon", "./prog"}, "invalid value"},
+		{"unknown flag", []string{"-n", "2", "./prog"}, "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if _, err := parseFlags("mpirun", tt.args, &out); err == nil {
+				t.Fatal("no error")
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("output %q does not say %q", out.String(), tt.want)
+			}
+			if !strings.Contains(out.String(), "Usage: mpirun [flags] program [args...]") {
+				t.Errorf("output %q has no usage", out.String())
+			}
+		})
+	}
+
+	if _, err := parseFlags("mpirun", []string{"-h"}, io.Discard); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: got %v, want flag.ErrHelp", err)
+	}
+}
+
+// lookup returns the value of key in env, the last one if it is set twice
+func lookup(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func TestJobEnv(t *testing.T) {
+	base := []string{
+		"HOME=/home/user",
+		"MPI_RANK=3",
+		"MPI_SIZE=8",
+		"MPI_ADDRESS_7=10.0.0.7:5000",
+		"MPI_TRANSPORT=tcp",
+	}
+	env, err := jobEnv(base, []string{"127.0.0.1:4000", "127.0.0.1:4001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"HOME":          "/home/user",
+		"MPI_TRANSPORT": "tcp",
+		"MPI_SIZE":      "2",
+		"MPI_ADDRESS_0": "127.0.0.1:4000",
+		"MPI_ADDRESS_1": "127.0.0.1:4001",
+	} {
+		if got, _ := lookup(env, key); got != want {
+			t.Errorf("%s=%q, want %q", key, got, want)
+		}
+	}
+	for _, key := range []string{"MPI_RANK", "MPI_ADDRESS_7"} {
+		if v, ok := lookup(env, key); ok {
+			t.Errorf("%s=%q is left over from the enclosing job", key, v)
+		}
+	}
+	if n := strings.Count(strings.Join(env, "\n"), "MPI_SIZE="); n != 1 {
+		t.Errorf("MPI_SIZE is set %d times", n)
+	}
+
+	token, ok := lookup(env, "MPI_JOB_TOKEN")
+	if !ok || len(token) != 64 {
+		t.Fatalf("MPI_JOB_TOKEN=%q, want 32 random bytes in hex", token)
+	}
+	other, err := jobEnv(base, []string{"127.0.0.1:4000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := lookup(other, "MPI_JOB_TOKEN"); again == token {
+		t.Error("two jobs got the same token")
+	}
+}
+
+func TestJobEnvKeepsGivenToken(t *testing.T) {
+	for _, kv := range []string{"MPI_JOB_TOKEN=secret", "MPI_JOB_TOKEN_FILE=/run/token"} {
+		env, err := jobEnv([]string{kv}, []string{"127.0.0.1:4000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{kv, "MPI_SIZE=1", "MPI_ADDRESS_0=127.0.0.1:4000"}; !slices.Equal(env, want) {
+			t.Errorf("with %s: got %q, want %q", kv, env, want)
+		}
+	}
+	// An empty token does not count
+	env, err := jobEnv([]string{"MPI_JOB_TOKEN="}, []string{"127.0.0.1:4000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := lookup(env, "MPI_JOB_TOKEN"); token == "" {
+		t.Error("no token generated in place of an empty one")
+	}
+}
+
+func TestRankEnv(t *testing.T) {
+	env := make([]string, 2, 8) // Room to append in place
+	env[0], env[1] = "MPI_SIZE=2", "MPI_JOB_TOKEN=secret"
+	rank0 := rankEnv(env, 0)
+	rank1 := rankEnv(env, 1)
+	if want := []string{"MPI_SIZE=2", "MPI_JOB_TOKEN=secret", "MPI_RANK=0"}; !slices.Equal(rank0, want) {
+		t.Errorf("rank 0: got %q, want %q", rank0, want)
+	}
+	if want := []string{"MPI_SIZE=2", "MPI_JOB_TOKEN=secret", "MPI_RANK=1"}; !slices.Equal(rank1, want) {
+		t.Errorf("rank 1: got %q, want %q", rank1, want)
+	}
+	if len(env) != 2 {
+		t.Errorf("env changed to %q", env)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	p := &prefixWriter{prefix: "[1] ", w: &out, mu: &mu}
+	for _, s := range []string{"first li", "ne\nsecond line\nthi", "rd"} {
+		if n, err := p.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	p.Flush()
+	if want := "[1] first line\n[1] second line\n[1] third\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell")
+	}
+	env, err := jobEnv(nil, []string{"127.0.0.1:4000", "127.0.0.1:4001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{sh, "-c", "exit 0"}, env, 2, time.Second); code != 0 {
+		t.Errorf("exit code %d for ranks that succeed", code)
+	}
+	// Rank 1 fails and rank 0, which would otherwise run for a minute, is
+	// stopped
+	start := time.Now()
+	script := `if [ "$MPI_RANK" = 1 ]; then exit 3; fi; sleep 60`
+	if code := run([]string{sh, "-c", script}, env, 2, time.Second); code != 3 {
+		t.Errorf("exit code %d, want the failed rank's 3", code)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Errorf("took %v to stop the other rank", d)
+	}
+}
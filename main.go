@@ -11,7 +11,9 @@ import (
 )
 
 func main() {
-	mpi.MPI_Init()
+	if err := mpi.MPI_Init(); err != nil {
+		log.Fatalf("Error initializing MPI: %v", err)
+	}
 	defer mpi.MPI_Finalize()
 
 	rank := mpi.MPI_Comm_rank()
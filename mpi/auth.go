@@ -0,0 +1,173 @@
+package mpi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys carried by every RPC when a job token is set
+const (
+	metadataRank = "mpi-rank"
+	metadataAuth = "mpi-auth"
+)
+
+// loadJobToken reads the shared secret from MPI_JOB_TOKEN, or from the file
+// named by MPI_JOB_TOKEN_FILE
+func loadJobToken() ([]byte, error) {
+	if token := os.Getenv("MPI_JOB_TOKEN"); token != "" {
+		return []byte(token), nil
+	}
+	if path := os.Getenv("MPI_JOB_TOKEN_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading MPI_JOB_TOKEN_FILE: %v", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return nil, fmt.Errorf("job token file %s is empty", path)
+		}
+		return []byte(token), nil
+	}
+	return nil, nil
+}
+
+// rankProof proves knowledge of the job token on behalf of rank r. It is a
+// bearer credential, so use TLS as well if the network can be observed.
+func rankProof(token []byte, r int) string {
+	mac := hmac.New(sha256.New, token)
+	fmt.Fprintf(mac, "mpi-rank:%d", r)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// jobCredentials attaches this rank's identity and proof to outgoing RPCs
+type jobCredentials struct {
+	token []byte
+	rank  int
+}
+
+func (j jobCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		metadataRank: strconv.Itoa(j.rank),
+		metadataAuth: rankProof(j.token, j.rank),
+	}, nil
+}
+
+func (jobCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// authenticatePeer works out which rank sent an RPC. It returns -1 if
+// neither a job token nor mutual TLS is configured, since then there is
+// nothing to check against.
+func (c *Comm) authenticatePeer(ctx context.Context) (int, error) {
+	certRank := -1
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			// The chain was verified during the handshake
+			r, err := certificateRank(info.State.PeerCertificates[0])
+			if err != nil {
+				return -1, status.Error(codes.Unauthenticated, err.Error())
+			}
+			certRank = r
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return c.identifyPeer(certRank, md.Get(metadataRank), md.Get(metadataAuth))
+}
+
+// identifyPeer checks the job credentials a peer presented, if a job token is
+// set, against each other and against the rank of its TLS certificate, or -1
+// if it did not present one. It returns the peer's rank, or -1 if there is
+// nothing to check against.
+func (c *Comm) identifyPeer(certRank int, ranks, proofs []string) (int, error) {
+	if c.jobToken == nil {
+		return certRank, nil
+	}
+	if len(ranks) != 1 || len(proofs) != 1 {
+		return -1, status.Error(codes.Unauthenticated, "missing job credentials")
+	}
+	claimed, err := strconv.Atoi(ranks[0])
+	if err != nil || claimed < 0 || claimed >= c.size {
+		return -1, status.Errorf(codes.Unauthenticated, "invalid rank %q in job credentials", ranks[0])
+	}
+	if !hmac.Equal([]byte(proofs[0]), []byte(rankProof(c.jobToken, claimed))) {
+		return -1, status.Error(codes.Unauthenticated, "invalid job token")
+	}
+	if certRank != -1 && certRank != claimed {
+		return -1, status.Errorf(codes.Unauthenticated,
+			"job credentials claim rank %d but the TLS certificate is for rank %d", claimed, certRank)
+	}
+	return claimed, nil
+}
+
+// checkSource rejects messages whose claimed source is not the authenticated peer
+func checkSource(msg *Message, peerRank int) error {
+	if peerRank != -1 && int(msg.Source) != peerRank {
+		return status.Errorf(codes.PermissionDenied,
+			"message claims source rank %d but was sent by rank %d", msg.Source, peerRank)
+	}
+	return nil
+}
+
+type peerRankKey struct{}
+
+// peerRank returns the rank the auth interceptors established for an RPC,
+// or -1 if it is unknown
+func peerRank(ctx context.Context) int {
+	if r, ok := ctx.Value(peerRankKey{}).(int); ok {
+		return r
+	}
+	return -1
+}
+
+// isHealthMethod reports whether method belongs to the gRPC health service,
+// which is open to probes that hold no job credentials
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+func (c *Comm) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if isHealthMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	r, err := c.authenticatePeer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, peerRankKey{}, r), req)
+}
+
+func (c *Comm) streamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if isHealthMethod(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	r, err := c.authenticatePeer(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), peerRankKey{}, r)})
+}
+
+// authStream carries the authenticated peer rank into a stream handler
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
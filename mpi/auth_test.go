@@ -0,0 +1,102 @@
+package mpi
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newNetworkComms starts one communicator per entry of cfgs over transport
+// on loopback, without shared memory
+func newNetworkComms(t *testing.T, transport string, cfgs []Config) []*Comm {
+	t.Helper()
+	for r := range cfgs {
+		cfgs[r].Transport = transport
+		cfgs[r].DisableSharedMemory = true
+	}
+	return startComms(t, cfgs)
+}
+
+// startComms starts one communicator per entry of cfgs on loopback, with
+// rank, size, addresses and listener filled in
+func startComms(t *testing.T, cfgs []Config) []*Comm {
+	t.Helper()
+	n := len(cfgs)
+	listeners := make([]net.Listener, n)
+	addresses := make(map[int]string, n)
+	for r := range cfgs {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[r] = lis
+		addresses[r] = lis.Addr().String()
+	}
+	comms := make([]*Comm, n)
+	for r, cfg := range cfgs {
+		cfg.Rank, cfg.Size, cfg.Addresses, cfg.Listener = r, n, addresses, listeners[r]
+		cfg.Retry.MaxAttempts = 1
+		c, err := NewComm(cfg)
+		if err != nil {
+			t.Fatalf("rank %d: %v", r, err)
+		}
+		t.Cleanup(c.Finalize)
+		comms[r] = c
+	}
+	return comms
+}
+
+// forEachNetworkTransport runs fn as a subtest for each network transport
+func forEachNetworkTransport(t *testing.T, fn func(t *testing.T, transport string)) {
+	for _, transport := range []string{TransportGRPC, TransportTCP} {
+		t.Run(transport, func(t *testing.T) { fn(t, transport) })
+	}
+}
+
+func TestWrongJobTokenIsRejected(t *testing.T) {
+	forEachNetworkTransport(t, testWrongJobTokenIsRejected)
+}
+
+func testWrongJobTokenIsRejected(t *testing.T, transport string) {
+	comms := newNetworkComms(t, transport, []Config{
+		{JobToken: []byte("job-a")},
+		{JobToken: []byte("job-b")},
+		{},
+	})
+	err := comms[0].Send([]byte("x"), 1, 0)
+	if err == nil || !strings.Contains(err.Error(), "invalid job token") {
+		t.Errorf("send with another job's token: got %v", err)
+	}
+	err = comms[2].Send([]byte("x"), 1, 0)
+	if err == nil || !strings.Contains(err.Error(), "missing job credentials") {
+		t.Errorf("send without a token: got %v", err)
+	}
+}
+
+func TestSpoofedSourceIsRejected(t *testing.T) {
+	forEachNetworkTransport(t, testSpoofedSourceIsRejected)
+}
+
+func testSpoofedSourceIsRejected(t *testing.T, transport string) {
+	token := []byte("job")
+	comms := newNetworkComms(t, transport, []Config{{JobToken: token}, {JobToken: token}, {JobToken: token}})
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Source: 2, Dest: 1, Tag: 0, Data: []byte("forged")}
+	err = conn.Send(context.Background(), msg)
+	if err == nil || !strings.Contains(err.Error(), "claims source rank 2 but was sent by rank 0") {
+		t.Fatalf("message with a spoofed source: got %v", err)
+	}
+
+	// The genuine article still gets through
+	msg.Source = 0
+	if err := conn.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comms[1].Recv(0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,234 @@
+package mpi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bootstrap is how the ranks of a job find each other before they can
+// communicate, in the manner of PMI: each rank learns its rank and the job
+// size, publishes values with Put, and reads the values of other ranks with
+// Get once all ranks have passed a Fence. The address of every rank is
+// available under BootstrapAddressKey as soon as the bootstrap is created.
+//
+// The ssm, s3 and http bootstraps exchange other values too. The env, file
+// and ec2 bootstraps only know the layout, so their Fence fails after a Put
+// in a job of several ranks.
+type Bootstrap interface {
+	Rank() int
+	Size() int
+	// Put publishes value under key for the local rank. Other ranks can see
+	// it after the next Fence. Keys consist of letters, digits, '_', '-' and '.'.
+	Put(ctx context.Context, key string, value []byte) error
+	// Get returns the value rank published under key. The error wraps
+	// ErrBootstrapKeyNotFound if there is none.
+	Get(ctx context.Context, rank int, key string) ([]byte, error)
+	// Fence returns once every rank has called it as many times as the local
+	// rank, so that all values put before it are visible
+	Fence(ctx context.Context) error
+	Close() error
+}
+
+// BootstrapAddressKey is the key under which each rank's host:port is found
+const BootstrapAddressKey = "address"
+
+// ErrBootstrapKeyNotFound is returned by Bootstrap.Get for missing values
+var ErrBootstrapKeyNotFound = errors.New("bootstrap key not found")
+
+// BootstrapConfig holds the settings shared by all bootstraps
+type BootstrapConfig struct {
+	// PollInterval is the time between checks while waiting for the other
+	// ranks, DefaultPollInterval if zero
+	PollInterval time.Duration
+}
+
+// BootstrapFactory creates a bootstrap from cfg and its own environment
+// variables. ctx bounds the time spent waiting for the other ranks to join.
+type BootstrapFactory func(ctx context.Context, cfg BootstrapConfig) (Bootstrap, error)
+
+var (
+	bootstrapsMu sync.RWMutex
+	bootstraps   = map[string]BootstrapFactory{
+		DiscoveryEnv:  newEnvBootstrap,
+		DiscoveryFile: newFileBootstrap,
+		DiscoveryHTTP: newHTTPBootstrap,
+		DiscoveryEC2:  newEC2Bootstrap,
+		DiscoverySSM:  newSSMBootstrap,
+		DiscoveryS3:   newS3Bootstrap,
+	}
+)
+
+// RegisterBootstrap makes a bootstrap available under name, for selection
+// with MPI_DISCOVERY
+func RegisterBootstrap(name string, factory BootstrapFactory) {
+	bootstrapsMu.Lock()
+	defer bootstrapsMu.Unlock()
+	bootstraps[name] = factory
+}
+
+// newBootstrap creates the bootstrap registered under name
+func newBootstrap(ctx context.Context, name string, cfg BootstrapConfig) (Bootstrap, error) {
+	bootstrapsMu.RLock()
+	factory, ok := bootstraps[name]
+	bootstrapsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("MPI_DISCOVERY has unknown method %q (available: %v)", name, bootstrapNames())
+	}
+	return factory(ctx, cfg)
+}
+
+func bootstrapNames() []string {
+	bootstrapsMu.RLock()
+	defer bootstrapsMu.RUnlock()
+	names := make([]string, 0, len(bootstraps))
+	for name := range bootstraps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// manifestBootstrap is implemented by bootstraps that read a JobManifest,
+// whose shared settings then apply to the job
+type manifestBootstrap interface {
+	manifest() *JobManifest
+}
+
+// layoutFromBootstrap fills in cfg's rank, size and addresses from b
+func layoutFromBootstrap(ctx context.Context, b Bootstrap, cfg *Config) error {
+	cfg.Rank, cfg.Size = b.Rank(), b.Size()
+	cfg.Addresses = make(map[int]string, cfg.Size)
+	for r := 0; r < cfg.Size; r++ {
+		addr, err := b.Get(ctx, r, BootstrapAddressKey)
+		if err != nil {
+			return fmt.Errorf("no address for rank %d: %v", r, err)
+		}
+		cfg.Addresses[r] = string(addr)
+	}
+	return nil
+}
+
+func checkBootstrapKey(key string) error {
+	if key == "" {
+		return errors.New("empty bootstrap key")
+	}
+	for _, ch := range key {
+		ok := ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+			ch == '_' || ch == '-' || ch == '.'
+		if !ok {
+			return fmt.Errorf("invalid bootstrap key %q", key)
+		}
+	}
+	return nil
+}
+
+// staticBootstrap serves a layout that was fixed before the job started. It
+// has nowhere to publish values, so other ranks see only their addresses, and
+// Fence fails once the local rank has put anything in a job of several ranks.
+type staticBootstrap struct {
+	name      string
+	rank      int
+	addresses map[int]string
+	mu        sync.Mutex
+	values    map[string][]byte
+	m         *JobManifest
+}
+
+func newStaticBootstrap(name string, rank int, addresses map[int]string) *staticBootstrap {
+	return &staticBootstrap{name: name, rank: rank, addresses: addresses, values: make(map[string][]byte)}
+}
+
+func (b *staticBootstrap) Rank() int { return b.rank }
+func (b *staticBootstrap) Size() int { return len(b.addresses) }
+
+func (b *staticBootstrap) Put(ctx context.Context, key string, value []byte) error {
+	if err := checkBootstrapKey(key); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (b *staticBootstrap) Get(ctx context.Context, rank int, key string) ([]byte, error) {
+	if rank < 0 || rank >= len(b.addresses) {
+		return nil, fmt.Errorf("rank %d out of range for a job of size %d", rank, len(b.addresses))
+	}
+	if rank == b.rank {
+		b.mu.Lock()
+		value, ok := b.values[key]
+		b.mu.Unlock()
+		if ok {
+			return value, nil
+		}
+	}
+	if key == BootstrapAddressKey {
+		return []byte(b.addresses[rank]), nil
+	}
+	return nil, fmt.Errorf("%w: %s of rank %d", ErrBootstrapKeyNotFound, key, rank)
+}
+
+func (b *staticBootstrap) Fence(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.values) > 0 && len(b.addresses) > 1 {
+		return fmt.Errorf("the %s bootstrap cannot exchange values between ranks", b.name)
+	}
+	return nil
+}
+
+func (b *staticBootstrap) Close() error { return nil }
+
+func (b *staticBootstrap) manifest() *JobManifest { return b.m }
+
+// newEnvBootstrap reads MPI_RANK, MPI_SIZE and MPI_ADDRESS_<n>
+func newEnvBootstrap(ctx context.Context, _ BootstrapConfig) (Bootstrap, error) {
+	var cfg Config
+	if err := layoutFromVars(&cfg); err != nil {
+		return nil, err
+	}
+	return newStaticBootstrap(DiscoveryEnv, cfg.Rank, cfg.Addresses), nil
+}
+
+// newFileBootstrap reads the JobManifest in the JSON file named by
+// MPI_BOOTSTRAP_FILE. The rank is MPI_RANK, or else the one whose address is
+// on this host.
+func newFileBootstrap(ctx context.Context, _ BootstrapConfig) (Bootstrap, error) {
+	path := os.Getenv("MPI_BOOTSTRAP_FILE")
+	if path == "" {
+		return nil, errors.New("MPI_BOOTSTRAP_FILE not set")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %s: %v", path, err)
+	}
+	m := &JobManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest %s: %v", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	addresses := m.addressMap()
+	rank, err := rankFromEnv(addresses)
+	if err != nil {
+		return nil, err
+	}
+	b := newStaticBootstrap(DiscoveryFile, rank, addresses)
+	b.m = m
+	return b, nil
+}
+
+// fenceKey names the n-th fence in bootstraps that keep one marker per rank
+// and fence
+func fenceKey(n int) string {
+	return "fence/" + strconv.Itoa(n)
+}
@@ -0,0 +1,186 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// BSEND_OVERHEAD is the attached buffer space used by each buffered send on
+// top of the size of its payload
+const BSEND_OVERHEAD = 64
+
+type bsendMessage struct {
+	data []byte
+	dest int
+	tag  int
+}
+
+// bsendState is the attached buffer of a communicator and the messages
+// queued in it
+type bsendState struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	size   int                    // Size of the attached buffer, 0 if none
+	used   int                    // Space held by messages not yet delivered
+	queues map[int][]bsendMessage // Undelivered messages per destination, in order
+	active map[int]bool           // Destinations with a delivery goroutine running
+	err    error                  // First delivery failure since attach
+}
+
+func (b *bsendState) init() {
+	b.cond = sync.NewCond(&b.mu)
+	b.queues = make(map[int][]bsendMessage)
+	b.active = make(map[int]bool)
+}
+
+// MPI_Buffer_attach provides size bytes of buffer space for MPI_Bsend
+func MPI_Buffer_attach(size int) error {
+	return world.BufferAttach(size)
+}
+
+// MPI_Buffer_detach waits until every buffered message has been delivered,
+// then releases the attached buffer and returns its size
+func MPI_Buffer_detach() (int, error) {
+	return world.BufferDetach()
+}
+
+// MPI_Bsend copies data into the attached buffer and returns without waiting
+// for the destination. It fails if the buffer does not have enough free space.
+// Later sends to the same destination, buffered or not, arrive after it.
+func MPI_Bsend(data []byte, dest int, tag int) error {
+	return world.Bsend(data, dest, tag)
+}
+
+// BufferAttach is MPI_Buffer_attach on c
+func (c *Comm) BufferAttach(size int) error {
+	return c.handleError(c.bufferAttach(size))
+}
+
+func (c *Comm) bufferAttach(size int) error {
+	b := &c.bsend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size != 0 {
+		return errorf(MPI_ERR_BUFFER, "a send buffer is already attached")
+	}
+	if size <= 0 {
+		return errorf(MPI_ERR_BUFFER, "invalid send buffer size %d", size)
+	}
+	b.size = size
+	b.err = nil
+	return nil
+}
+
+// BufferDetach is MPI_Buffer_detach on c
+func (c *Comm) BufferDetach() (int, error) {
+	size, err := c.bufferDetach()
+	return size, c.handleError(err)
+}
+
+func (c *Comm) bufferDetach() (int, error) {
+	b := &c.bsend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size == 0 {
+		return 0, errorf(MPI_ERR_BUFFER, "no send buffer is attached")
+	}
+	for b.used > 0 {
+		b.cond.Wait()
+	}
+	size, err := b.size, b.err
+	b.size = 0
+	b.err = nil
+	return size, err
+}
+
+// Bsend is MPI_Bsend on c
+func (c *Comm) Bsend(data []byte, dest int, tag int) error {
+	return c.handleError(c.bsendCopy(data, dest, tag))
+}
+
+// bsendCopy queues a copy of data for delivery to dest
+func (c *Comm) bsendCopy(data []byte, dest int, tag int) error {
+	if err := c.checkDest(dest, tag); err != nil {
+		return err
+	}
+	b := &c.bsend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size == 0 {
+		return errorf(MPI_ERR_BUFFER, "MPI_Bsend called without an attached buffer")
+	}
+	if err := b.err; err != nil {
+		return fmt.Errorf("earlier buffered send failed: %w", err)
+	}
+	need := len(data) + BSEND_OVERHEAD
+	if b.used+need > b.size {
+		return errorf(MPI_ERR_BUFFER, "insufficient buffer space: need %d bytes, %d of %d free",
+			need, b.size-b.used, b.size)
+	}
+	b.used += need
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	b.queues[dest] = append(b.queues[dest], bsendMessage{data: buf, dest: dest, tag: tag})
+	if !b.active[dest] {
+		b.active[dest] = true
+		go c.deliverBuffered(dest)
+	}
+	return nil
+}
+
+// deliverBuffered sends the queued messages for one destination in order and
+// returns their space to the attached buffer. It is the only sender to dest
+// while the queue is not empty; other sends wait in drainBuffered.
+func (c *Comm) deliverBuffered(dest int) {
+	b := &c.bsend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.queues[dest]) > 0 {
+		msg := b.queues[dest][0]
+		b.queues[dest] = b.queues[dest][1:]
+
+		b.mu.Unlock()
+		err := c.deliverNow(context.Background(), "MPI_Bsend", dest, &Message{
+			Source: int32(c.rank),
+			Dest:   int32(msg.dest),
+			Tag:    int32(msg.tag),
+			Data:   msg.data,
+			Mode:   SendMode_STANDARD,
+		})
+		b.mu.Lock()
+
+		if err != nil && b.err == nil {
+			b.err = fmt.Errorf("buffered send to rank %d failed: %w", msg.dest, err)
+		}
+		b.used -= len(msg.data) + BSEND_OVERHEAD
+		b.cond.Broadcast()
+	}
+	b.active[dest] = false
+	b.cond.Broadcast()
+}
+
+// drainBuffered waits until the buffered messages queued for dest have been
+// delivered, so that a later send to dest cannot overtake them
+func (c *Comm) drainBuffered(ctx context.Context, dest int) error {
+	b := &c.bsend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.active[dest] {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+	for b.active[dest] {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		b.cond.Wait()
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// heldConn is a connection whose sends wait until release is closed
+type heldConn struct {
+	Conn
+	release chan struct{}
+}
+
+func (hc *heldConn) Send(ctx context.Context, msg *Message) error {
+	<-hc.release
+	return hc.Conn.Send(ctx, msg)
+}
+
+// holdConn makes sends from comms[0] to rank 1 wait until the returned
+// function is called
+func holdConn(t *testing.T, comms []*Comm) func() {
+	t.Helper()
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &heldConn{Conn: conn, release: make(chan struct{})}
+	comms[0].conns[1] = hc
+	return func() { close(hc.release) }
+}
+
+func TestBsendIsNotOvertaken(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	if err := comms[0].BufferAttach(1 << 10); err != nil {
+		t.Fatal(err)
+	}
+	release := holdConn(t, comms)
+	for i := 0; i < 3; i++ {
+		if err := comms[0].Bsend([]byte(fmt.Sprint("buffered ", i)), 1, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- comms[0].Send([]byte("standard"), 1, 0) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("standard send finished before the buffered ones were delivered: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"buffered 0", "buffered 1", "buffered 2", "standard"} {
+		got, err := comms[1].Recv(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Fatalf("received %q, want %q", got, want)
+		}
+	}
+	if _, err := comms[0].BufferDetach(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBsendBufferFull(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	if err := comms[0].Bsend([]byte("x"), 1, 0); !errors.Is(err, MPI_ERR_BUFFER) {
+		t.Errorf("without a buffer: got %v, want MPI_ERR_BUFFER", err)
+	}
+	if err := comms[0].BufferAttach(100 + BSEND_OVERHEAD); err != nil {
+		t.Fatal(err)
+	}
+	release := holdConn(t, comms)
+	if err := comms[0].Bsend(make([]byte, 60), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := comms[0].Bsend(make([]byte, 60), 1, 0); !errors.Is(err, MPI_ERR_BUFFER) {
+		t.Errorf("over the free space: got %v, want MPI_ERR_BUFFER", err)
+	}
+	release()
+	if size, err := comms[0].BufferDetach(); err != nil || size != 100+BSEND_OVERHEAD {
+		t.Fatalf("detach: size %d, error %v", size, err)
+	}
+	if _, err := comms[1].Recv(0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBufferDetachWaitsForDelivery(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	if err := comms[0].BufferAttach(1 << 10); err != nil {
+		t.Fatal(err)
+	}
+	release := holdConn(t, comms)
+	if err := comms[0].Bsend([]byte("pending"), 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	detached := make(chan error, 1)
+	go func() {
+		_, err := comms[0].BufferDetach()
+		detached <- err
+	}()
+	select {
+	case err := <-detached:
+		t.Fatalf("detach returned while a message was pending: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	if err := <-detached; err != nil {
+		t.Fatal(err)
+	}
+	// Delivered by the time detach returns
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if got, err := comms[1].RecvCtx(ctx, 0, 4); err != nil || string(got) != "pending" {
+		t.Fatalf("received %q, %v", got, err)
+	}
+}
@@ -0,0 +1,191 @@
+package mpi
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec converts Go values to and from message payloads. Sender and receiver
+// must use the same codec for a message.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// GobCodec encodes values with encoding/gob. Numeric slices use the raw
+	// numeric encoding instead, which needs no reflection. This is the default.
+	GobCodec Codec = gobCodec{}
+
+	// ProtoCodec encodes values that implement proto.Message
+	ProtoCodec Codec = protoCodec{}
+
+	// MsgpackCodec encodes values as MessagePack, which non-Go tools can read
+	MsgpackCodec Codec = msgpackCodec{}
+
+	// RawCodec passes []byte through unchanged and sends numeric slices as
+	// plain little-endian elements with no header
+	RawCodec Codec = rawCodec{}
+)
+
+// codecByName returns the built-in codec called name
+func codecByName(name string) (Codec, error) {
+	for _, c := range []Codec{GobCodec, ProtoCodec, MsgpackCodec, RawCodec} {
+		if strings.EqualFold(name, c.Name()) {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(data interface{}) ([]byte, error) {
+	if data == nil {
+		return nil, fmt.Errorf("cannot serialize nil")
+	}
+	// Numeric slices use the raw encoding, which needs no reflection
+	if buf, ok := encodeNumeric(data); ok {
+		return buf, nil
+	}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+
+	// Handle different types for registration
+	switch reflect.TypeOf(data).Kind() {
+	case reflect.Slice, reflect.Array:
+		v := reflect.ValueOf(data)
+		if v.Len() > 0 {
+			// Register the element type
+			gob.Register(v.Index(0).Interface())
+		}
+	}
+
+	if err := enc.Encode(data); err != nil {
+		return nil, fmt.Errorf("serialization error for type %T: %v", data, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("cannot deserialize empty byte slice")
+	}
+
+	// Raw numeric data is decoded straight into the caller's buffer
+	if isNumeric(data) {
+		return decodeNumeric(data, v)
+	}
+
+	buf := bytes.NewBuffer(data)
+	dec := gob.NewDecoder(buf)
+
+	// Check if the target is a pointer
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return fmt.Errorf("destination must be a pointer, got %T", v)
+	}
+
+	// Actual decoding with error return
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("deserialization error for type %T: %v", v, err)
+	}
+	return nil
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string { return "protobuf" }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("value must be a proto.Message, got %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("value must be a proto.Message, got %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "raw" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	if buf, ok := encodeNumeric(v); ok {
+		return buf[numericHeader:], nil
+	}
+	return nil, fmt.Errorf("cannot encode %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v.(type) {
+	case []int8, *[]int8:
+		return decodeSlice[int8](data, v, 1)
+	case []int16, *[]int16:
+		return decodeSlice[int16](data, v, 2)
+	case []int32, *[]int32:
+		return decodeSlice[int32](data, v, 4)
+	case []int64, *[]int64:
+		return decodeSlice[int64](data, v, 8)
+	case []int, *[]int:
+		if strconv.IntSize == 64 {
+			return decodeSlice[int](data, v, 8)
+		}
+		return decodeNarrowed[int64, int](data, v)
+	case []uint8, *[]uint8:
+		return decodeSlice[uint8](data, v, 1)
+	case []uint16, *[]uint16:
+		return decodeSlice[uint16](data, v, 2)
+	case []uint32, *[]uint32:
+		return decodeSlice[uint32](data, v, 4)
+	case []uint64, *[]uint64:
+		return decodeSlice[uint64](data, v, 8)
+	case []uint, *[]uint:
+		if strconv.IntSize == 64 {
+			return decodeSlice[uint](data, v, 8)
+		}
+		return decodeNarrowed[uint64, uint](data, v)
+	case []float32, *[]float32:
+		return decodeSlice[float32](data, v, 4)
+	case []float64, *[]float64:
+		return decodeSlice[float64](data, v, 8)
+	case []complex64, *[]complex64:
+		return decodeSlice[complex64](data, v, 4)
+	case []complex128, *[]complex128:
+		return decodeSlice[complex128](data, v, 8)
+	}
+	return fmt.Errorf("cannot decode into %T", v)
+}
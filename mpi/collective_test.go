@@ -0,0 +1,195 @@
+package mpi_test
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi/mpitest"
+)
+
+// testTransports are the transports the suites run over. Shared memory is
+// off, so that traffic between the ranks really takes each of them.
+var testTransports = []string{mpi.TransportGRPC, mpi.TransportTCP, mpi.TransportMemory}
+
+// runOnEachTransport runs fn on a job of n ranks over each of testTransports
+// as a subtest named after the transport, with base applied to every rank
+func runOnEachTransport(t *testing.T, n int, base mpi.Config, fn func(comm *mpi.Comm) error) {
+	for _, transport := range testTransports {
+		cfg := base
+		cfg.Transport = transport
+		cfg.DisableSharedMemory = true
+		t.Run(transport, func(t *testing.T) {
+			if err := mpitest.RunConfig(n, cfg, fn); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// forEachJob runs fn on jobs of 1 to 4 ranks over each of testTransports
+func forEachJob(t *testing.T, fn func(comm *mpi.Comm) error) {
+	for n := 1; n <= 4; n++ {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			runOnEachTransport(t, n, mpi.Config{}, fn)
+		})
+	}
+}
+
+func TestBcast(t *testing.T) {
+	forEachJob(t, func(comm *mpi.Comm) error {
+		for root := 0; root < comm.Size(); root++ {
+			data := make([]float64, 3)
+			if comm.Rank() == root {
+				data = []float64{1, 2, float64(root)}
+			}
+			if err := comm.Bcast(data, len(data), root); err != nil {
+				return err
+			}
+			if data[0] != 1 || data[1] != 2 || data[2] != float64(root) {
+				return fmt.Errorf("root %d: got %v", root, data)
+			}
+		}
+		return nil
+	})
+}
+
+func TestReduce(t *testing.T) {
+	forEachJob(t, func(comm *mpi.Comm) error {
+		root := comm.Size() - 1
+		var sum float64
+		if err := comm.Reduce(float64(comm.Rank()+1), &sum, mpi.Sum, root); err != nil {
+			return err
+		}
+		n := comm.Size()
+		if want := float64(n * (n + 1) / 2); comm.Rank() == root && sum != want {
+			return fmt.Errorf("sum is %v, want %v", sum, want)
+		}
+		return nil
+	})
+}
+
+func TestAllreduce(t *testing.T) {
+	forEachJob(t, func(comm *mpi.Comm) error {
+		var sum []float64
+		if err := comm.Allreduce([]float64{1, float64(comm.Rank())}, &sum, mpi.Sum); err != nil {
+			return err
+		}
+		n := comm.Size()
+		if len(sum) != 2 || sum[0] != float64(n) || sum[1] != float64(n*(n-1)/2) {
+			return fmt.Errorf("got %v", sum)
+		}
+		return nil
+	})
+}
+
+func TestScatter(t *testing.T) {
+	const count = 2
+	forEachJob(t, func(comm *mpi.Comm) error {
+		var send []float64
+		if comm.Rank() == 0 {
+			for i := 0; i < comm.Size()*count; i++ {
+				send = append(send, float64(i))
+			}
+		}
+		recv := make([]float64, count)
+		if err := comm.Scatter(send, recv, count, 0); err != nil {
+			return err
+		}
+		for i, x := range recv {
+			if want := float64(comm.Rank()*count + i); x != want {
+				return fmt.Errorf("got %v", recv)
+			}
+		}
+		return nil
+	})
+}
+
+func TestGather(t *testing.T) {
+	const count = 2
+	forEachJob(t, func(comm *mpi.Comm) error {
+		send := []float64{float64(comm.Rank()), float64(comm.Rank()) + 0.5}
+		var recv []float64
+		if comm.Rank() == 0 {
+			recv = make([]float64, comm.Size()*count)
+		}
+		if err := comm.Gather(send, recv, count, 0); err != nil {
+			return err
+		}
+		if comm.Rank() == 0 {
+			for r := 0; r < comm.Size(); r++ {
+				if recv[r*count] != float64(r) || recv[r*count+1] != float64(r)+0.5 {
+					return fmt.Errorf("got %v", recv)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func TestBarrier(t *testing.T) {
+	for n := 1; n <= 4; n++ {
+		for _, transport := range testTransports {
+			// No rank may leave a barrier before every rank has entered it
+			var arrived atomic.Int32
+			check := func(comm *mpi.Comm) error {
+				for i := 1; i <= 3; i++ {
+					arrived.Add(1)
+					if err := comm.Barrier(); err != nil {
+						return err
+					}
+					if got := arrived.Load(); got < int32(i*comm.Size()) {
+						return fmt.Errorf("left barrier %d after %d arrivals", i, got)
+					}
+				}
+				return nil
+			}
+			cfg := mpi.Config{Transport: transport, DisableSharedMemory: true}
+			if err := mpitest.RunConfig(n, cfg, check); err != nil {
+				t.Fatalf("%s/%d: %v", transport, n, err)
+			}
+		}
+	}
+}
+
+func TestCollectivesLeaveUserTagsAlone(t *testing.T) {
+	forEachJob(t, func(comm *mpi.Comm) error {
+		n, rank := comm.Size(), comm.Rank()
+		next, prev := (rank+1)%n, (rank+n-1)%n
+		// Pending user messages on the tags the collectives once used
+		for tag := 0; tag <= 5; tag++ {
+			if err := comm.Send([]byte(fmt.Sprint("user ", rank, " ", tag)), next, tag); err != nil {
+				return err
+			}
+		}
+		if err := comm.Barrier(); err != nil {
+			return err
+		}
+		var sum []float64
+		if err := comm.Allreduce([]float64{1}, &sum, mpi.Sum); err != nil {
+			return err
+		}
+		if sum[0] != float64(n) {
+			return fmt.Errorf("allreduce got %v", sum)
+		}
+		data := []float64{float64(rank)}
+		if err := comm.Bcast(data, 1, 0); err != nil {
+			return err
+		}
+		if data[0] != 0 {
+			return fmt.Errorf("bcast got %v", data)
+		}
+		for tag := 0; tag <= 5; tag++ {
+			got, err := comm.Recv(prev, tag)
+			if err != nil {
+				return err
+			}
+			if want := fmt.Sprint("user ", prev, " ", tag); string(got) != want {
+				return fmt.Errorf("tag %d: received %q, want %q", tag, got, want)
+			}
+		}
+		return nil
+	})
+}
@@ -1,11 +1,65 @@
 package mpi
 
-// MPI_Comm_rank returns the rank of the calling process
+// MPI_Comm_rank returns the rank of the calling process, or 0 before
+// MPI_Init
 func MPI_Comm_rank() int {
-	return rank
+	if world == nil {
+		return 0
+	}
+	return world.Rank()
 }
 
-// MPI_Comm_size returns the total number of processes
+// MPI_Comm_size returns the total number of processes, or 0 before MPI_Init
 func MPI_Comm_size() int {
-	return size
+	if world == nil {
+		return 0
+	}
+	return world.Size()
+}
+
+// Rank returns the rank c represents
+func (c *Comm) Rank() int {
+	return c.rank
+}
+
+// Size returns the number of ranks in c
+func (c *Comm) Size() int {
+	return c.size
+}
+
+// World returns the communicator set up by MPI_Init, or nil before it is called
+func World() *Comm {
+	return world
+}
+
+// checkDest reports an MPI_ERR_RANK or MPI_ERR_TAG error if a message cannot
+// be sent to dest with tag
+func (c *Comm) checkDest(dest int, tag int) error {
+	if dest < 0 || dest >= c.size {
+		return errorf(MPI_ERR_RANK, "invalid destination rank %d, expected 0 to %d", dest, c.size-1)
+	}
+	if tag < 0 {
+		return errorf(MPI_ERR_TAG, "invalid tag %d, tags must not be negative", tag)
+	}
+	return nil
+}
+
+// checkSource reports an MPI_ERR_RANK or MPI_ERR_TAG error if no message can
+// be received from source with tag. Either may be -1 to match any.
+func (c *Comm) checkSource(source int, tag int) error {
+	if source < -1 || source >= c.size {
+		return errorf(MPI_ERR_RANK, "invalid source rank %d, expected 0 to %d or -1 for any", source, c.size-1)
+	}
+	if tag < -1 {
+		return errorf(MPI_ERR_TAG, "invalid tag %d, expected a tag of at least 0 or -1 for any", tag)
+	}
+	return nil
+}
+
+// checkRoot reports an MPI_ERR_ROOT error if root is not a rank of c
+func (c *Comm) checkRoot(root int) error {
+	if root < 0 || root >= c.size {
+		return errorf(MPI_ERR_ROOT, "invalid root rank %d, expected 0 to %d", root, c.size-1)
+	}
+	return nil
 }
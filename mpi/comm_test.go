@@ -0,0 +1,48 @@
+package mpi
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newTestComms returns n communicators of one job connected in memory, with
+// base applied to each. They are finalized when the test ends.
+func newTestComms(t *testing.T, n int, base Config) []*Comm {
+	t.Helper()
+	base.Transport = TransportMemory
+	base.Network = NewMemoryNetwork()
+	base.Size = n
+	base.Addresses = make(map[int]string, n)
+	for r := 0; r < n; r++ {
+		base.Addresses[r] = fmt.Sprintf("memory:%d", r)
+	}
+	comms := make([]*Comm, n)
+	for r := range comms {
+		cfg := base
+		cfg.Rank = r
+		c, err := NewComm(cfg)
+		if err != nil {
+			t.Fatalf("rank %d: %v", r, err)
+		}
+		t.Cleanup(c.Finalize)
+		comms[r] = c
+	}
+	return comms
+}
+
+func TestCommRankSizeBeforeInit(t *testing.T) {
+	if r, n := MPI_Comm_rank(), MPI_Comm_size(); r != 0 || n != 0 {
+		t.Errorf("before MPI_Init: rank %d, size %d, want 0 and 0", r, n)
+	}
+	worldMu.Lock()
+	world = newTestComms(t, 3, Config{})[2]
+	worldMu.Unlock()
+	defer func() {
+		worldMu.Lock()
+		world = nil
+		worldMu.Unlock()
+	}()
+	if r, n := MPI_Comm_rank(), MPI_Comm_size(); r != 2 || n != 3 {
+		t.Errorf("rank %d, size %d, want 2 and 3", r, n)
+	}
+}
@@ -0,0 +1,236 @@
+package mpi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionThreshold is the smallest payload compressed when
+// compression is enabled without an explicit threshold
+const DefaultCompressionThreshold = 64 * 1024 // 64 KiB
+
+// DefaultMaxDecompressedSize is the largest payload a compressed message may
+// expand to unless Config.MaxDecompressedSize says otherwise. Streamed
+// messages are not bound by the message size limit, so neither is this.
+const DefaultMaxDecompressedSize = 1 << 30 // 1 GiB
+
+var (
+	zstdOnce     sync.Once
+	zstdEncoder  *zstd.Encoder
+	zstdDecoders sync.Map // Largest output -> *zstd.Decoder
+)
+
+// SetCompression enables compression of outgoing payloads of at least
+// threshold bytes with alg on the world communicator, or disables it with
+// Compression_NONE. Receivers decompress whatever algorithm each message says
+// it was sent with.
+func SetCompression(alg Compression, threshold int) error {
+	if world == nil {
+		return errorf(MPI_ERR_OTHER, "MPI_Init has not been called")
+	}
+	return world.SetCompression(alg, threshold)
+}
+
+// SetCompression is the per-communicator form of the package SetCompression
+func (c *Comm) SetCompression(alg Compression, threshold int) error {
+	if _, ok := Compression_name[int32(alg)]; !ok {
+		return errorf(MPI_ERR_ARG, "unknown compression algorithm %d", alg)
+	}
+	if threshold < 0 {
+		return errorf(MPI_ERR_ARG, "invalid compression threshold %d", threshold)
+	}
+	c.compressionMu.Lock()
+	defer c.compressionMu.Unlock()
+	c.compression = alg
+	c.compressionThreshold = threshold
+	return nil
+}
+
+// applyCompressionEnv sets cfg's compression from MPI_COMPRESSION (gzip,
+// snappy, zstd or none) and MPI_COMPRESSION_THRESHOLD in bytes, if set
+func applyCompressionEnv(cfg *Config) error {
+	if name := os.Getenv("MPI_COMPRESSION"); name != "" {
+		alg, ok := Compression_value[strings.ToUpper(name)]
+		if !ok {
+			return fmt.Errorf("MPI_COMPRESSION has unknown algorithm %q", name)
+		}
+		cfg.Compression = Compression(alg)
+		if cfg.CompressionThreshold == 0 {
+			cfg.CompressionThreshold = DefaultCompressionThreshold
+		}
+	}
+	if s := os.Getenv("MPI_COMPRESSION_THRESHOLD"); s != "" {
+		threshold, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("MPI_COMPRESSION_THRESHOLD invalid: %v", err)
+		}
+		cfg.CompressionThreshold = threshold
+	}
+	return nil
+}
+
+// compressMessage returns msg with its payload compressed if compression is
+// enabled and worthwhile. msg itself is never modified.
+func (c *Comm) compressMessage(msg *Message) (*Message, error) {
+	c.compressionMu.RLock()
+	alg, threshold := c.compression, c.compressionThreshold
+	c.compressionMu.RUnlock()
+	if alg == Compression_NONE || len(msg.Data) < threshold || msg.Compression != Compression_NONE {
+		return msg, nil
+	}
+	if len(msg.Data) > c.maxDecompressedSize {
+		// Receivers with the same settings would refuse to decompress it
+		return msg, nil
+	}
+
+	start := time.Now()
+	data, err := compress(alg, msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("error compressing message: %v", err)
+	}
+	c.stats.compressionTime.Add(int64(time.Since(start)))
+	if len(data) >= len(msg.Data) {
+		// Incompressible; send it as it is
+		return msg, nil
+	}
+	c.stats.compressedMessages.Add(1)
+	c.stats.compressionIn.Add(int64(len(msg.Data)))
+	c.stats.compressionOut.Add(int64(len(data)))
+
+	return &Message{
+		Source:           msg.Source,
+		Dest:             msg.Dest,
+		Tag:              msg.Tag,
+		Data:             data,
+		Mode:             msg.Mode,
+		Compression:      alg,
+		UncompressedSize: int64(len(msg.Data)),
+	}, nil
+}
+
+// decompressMessage replaces a compressed payload with the original. The
+// size the sender declared must be within c's decompressed size limit, and
+// the payload must decompress to exactly that size.
+func (c *Comm) decompressMessage(msg *Message) error {
+	if msg.Compression == Compression_NONE {
+		return nil
+	}
+	start := time.Now()
+	data, err := decompress(msg.Compression, msg.Data, msg.UncompressedSize, c.maxDecompressedSize)
+	if err != nil {
+		return fmt.Errorf("error decompressing message from rank %d: %w", msg.Source, err)
+	}
+	c.stats.decompressionTime.Add(int64(time.Since(start)))
+	msg.Data = data
+	msg.Compression = Compression_NONE
+	msg.UncompressedSize = 0
+	return nil
+}
+
+func compress(alg Compression, data []byte) ([]byte, error) {
+	switch alg {
+	case Compression_GZIP:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Compression_SNAPPY:
+		return snappy.Encode(nil, data), nil
+	case Compression_ZSTD:
+		initZstd()
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm %v", alg)
+}
+
+// decompress returns data decompressed with alg, which must come to size
+// bytes, at most limit. Output beyond size is never produced in full.
+func decompress(alg Compression, data []byte, size int64, limit int) ([]byte, error) {
+	if size < 0 || size > int64(limit) {
+		return nil, errorf(MPI_ERR_OTHER, "declared uncompressed size %d is outside 0 to %d", size, limit)
+	}
+	var out []byte
+	var err error
+	switch alg {
+	case Compression_GZIP:
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errorf(MPI_ERR_OTHER, "%w", err)
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, size))
+		// One byte more than declared is enough to tell that there is more
+		_, err = io.Copy(buf, io.LimitReader(r, size+1))
+		out = buf.Bytes()
+	case Compression_SNAPPY:
+		var n int
+		n, err = snappy.DecodedLen(data)
+		if err != nil {
+			return nil, errorf(MPI_ERR_OTHER, "%w", err)
+		}
+		if int64(n) != size {
+			return nil, sizeMismatch(int64(n), size)
+		}
+		out, err = snappy.Decode(nil, data)
+	case Compression_ZSTD:
+		out, err = zstdDecoder(limit).DecodeAll(data, make([]byte, 0, size))
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, errorf(MPI_ERR_TRUNCATE, "decompressed size exceeds %d bytes", limit)
+		}
+	default:
+		return nil, errorf(MPI_ERR_OTHER, "unsupported compression algorithm %v", alg)
+	}
+	if err != nil {
+		return nil, errorf(MPI_ERR_OTHER, "%w", err)
+	}
+	if int64(len(out)) != size {
+		return nil, sizeMismatch(int64(len(out)), size)
+	}
+	return out, nil
+}
+
+// sizeMismatch reports a payload that decompressed to got bytes instead of
+// the declared size: MPI_ERR_TRUNCATE if it is larger, MPI_ERR_OTHER if smaller
+func sizeMismatch(got int64, size int64) error {
+	if got > size {
+		return errorf(MPI_ERR_TRUNCATE, "payload decompresses to more than the declared %d bytes", size)
+	}
+	return errorf(MPI_ERR_OTHER, "decompressed %d bytes, expected %d", got, size)
+}
+
+// zstdDecoder returns a shared decoder that produces at most limit bytes
+func zstdDecoder(limit int) *zstd.Decoder {
+	if d, ok := zstdDecoders.Load(limit); ok {
+		return d.(*zstd.Decoder)
+	}
+	// Safe for concurrent DecodeAll calls
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(limit)))
+	if actual, loaded := zstdDecoders.LoadOrStore(limit, d); loaded {
+		d.Close()
+		return actual.(*zstd.Decoder)
+	}
+	return d
+}
+
+func initZstd() {
+	zstdOnce.Do(func() {
+		// Safe for concurrent EncodeAll calls
+		zstdEncoder, _ = zstd.NewWriter(nil)
+	})
+}
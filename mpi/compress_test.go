@@ -0,0 +1,129 @@
+package mpi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+var algorithms = []Compression{Compression_GZIP, Compression_SNAPPY, Compression_ZSTD}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("cloud-native mpi "), 1<<18)
+	for _, alg := range algorithms {
+		packed, err := compress(alg, data)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		out, err := decompress(alg, packed, int64(len(data)), len(data))
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Errorf("%v: round trip changed the payload", alg)
+		}
+	}
+}
+
+func TestDecompressRejectsForgedSize(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 4096)
+	for _, alg := range algorithms {
+		packed, _ := compress(alg, data)
+		for _, size := range []int64{-1, 1 << 40} {
+			if _, err := decompress(alg, packed, size, 1<<20); !errors.Is(err, MPI_ERR_OTHER) {
+				t.Errorf("%v with declared size %d: got %v, want MPI_ERR_OTHER", alg, size, err)
+			}
+		}
+		if _, err := decompress(alg, packed, 100, 1<<20); !errors.Is(err, MPI_ERR_TRUNCATE) {
+			t.Errorf("%v declared smaller than the payload: got %v, want MPI_ERR_TRUNCATE", alg, err)
+		}
+		if _, err := decompress(alg, packed, 8192, 1<<20); !errors.Is(err, MPI_ERR_OTHER) {
+			t.Errorf("%v declared larger than the payload: got %v, want MPI_ERR_OTHER", alg, err)
+		}
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	// 16 MiB of zeros compresses to a few KiB
+	bomb := make([]byte, 16<<20)
+	const limit = 1 << 20
+	for _, alg := range algorithms {
+		packed, err := compress(alg, bomb)
+		if err != nil {
+			t.Fatalf("%v: %v", alg, err)
+		}
+		_, err = decompress(alg, packed, limit, limit)
+		if !errors.Is(err, MPI_ERR_TRUNCATE) {
+			t.Errorf("%v bomb: got %v, want MPI_ERR_TRUNCATE", alg, err)
+		}
+	}
+}
+
+func TestRecvRejectsForgedCompressedMessage(t *testing.T) {
+	comms := newTestComms(t, 2, Config{MaxDecompressedSize: 1 << 20})
+	packed, _ := compress(Compression_GZIP, make([]byte, 16<<20))
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, size := range []int64{-1, 1 << 40, 1 << 10} {
+		msg := &Message{Source: 0, Dest: 1, Tag: int32(i), Data: packed, Compression: Compression_GZIP, UncompressedSize: size}
+		if err := conn.Send(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := comms[1].Recv(0, i); err == nil {
+			t.Errorf("declared size %d: message was accepted", size)
+		}
+	}
+}
+
+func TestPayloadIsNotCompressedPastLimit(t *testing.T) {
+	comms := newTestComms(t, 2, Config{MaxDecompressedSize: 1 << 16, Compression: Compression_GZIP})
+	data := make([]byte, 1<<17)
+	go comms[0].Send(data, 1, 0)
+	got, err := comms[1].Recv(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(data) {
+		t.Errorf("received %d bytes, want %d", len(got), len(data))
+	}
+	if n := comms[0].Stats().CompressedMessages; n != 0 {
+		t.Errorf("%d messages compressed, want 0", n)
+	}
+}
+
+func TestStreamedPayloadIsCompressed(t *testing.T) {
+	// Larger than the message size limit, and still larger than a stream
+	// chunk once compressed
+	data := make([]byte, 3*minMaxMessageSize)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < len(data); i += 2 {
+		data[i] = byte(rng.Uint32())
+	}
+	comms := newNetworkComms(t, TransportGRPC, []Config{
+		{MaxMessageSize: minMaxMessageSize, Compression: Compression_ZSTD},
+		{MaxMessageSize: minMaxMessageSize},
+	})
+	done := make(chan error, 1)
+	go func() { done <- comms[0].Send(data, 1, 0) }()
+	got, err := comms[1].Recv(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("received payload differs from the one sent")
+	}
+	stats := comms[0].Stats()
+	if stats.CompressedMessages != 1 {
+		t.Fatalf("%d messages compressed, want 1", stats.CompressedMessages)
+	}
+	if stats.CompressionOut <= streamChunkSize || stats.CompressionOut >= int64(len(data)) {
+		t.Errorf("compressed to %d bytes, want between a stream chunk and %d", stats.CompressionOut, len(data))
+	}
+}
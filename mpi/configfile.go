@@ -0,0 +1,138 @@
+package mpi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the content of a config file for MPI_InitWithOptions, named
+// by MPI_CONFIG or WithConfigFile. Files ending in .toml are read as TOML,
+// anything else as YAML. Durations are strings such as "30s". Settings left
+// out keep their defaults, and unknown settings are an error. For example:
+//
+//	transport: tcp
+//	max_message_size: 104857600
+//	recv_timeout: 2m
+//	keepalive: {time: 30s, timeout: 10s}
+//	retry: {max_attempts: 8, initial_backoff: 200ms, max_backoff: 10s}
+//	codec: msgpack
+//	log: {level: debug, format: json}
+//	discovery: {method: ssm, timeout: 10m, poll_interval: 5s}
+type FileConfig struct {
+	Transport            string          `yaml:"transport" toml:"transport"`
+	SharedMemory         *bool           `yaml:"shared_memory" toml:"shared_memory"`
+	SharedMemoryDir      string          `yaml:"shared_memory_dir" toml:"shared_memory_dir"`
+	MaxMessageSize       int             `yaml:"max_message_size" toml:"max_message_size"`
+	RecvTimeout          time.Duration   `yaml:"recv_timeout" toml:"recv_timeout"`
+	ReadyTimeout         time.Duration   `yaml:"ready_timeout" toml:"ready_timeout"`
+	Keepalive            KeepaliveConfig `yaml:"keepalive" toml:"keepalive"`
+	Retry                RetryConfig     `yaml:"retry" toml:"retry"`
+	Codec                string          `yaml:"codec" toml:"codec"`             // gob, protobuf, msgpack or raw
+	Compression          string          `yaml:"compression" toml:"compression"` // gzip, snappy or zstd
+	CompressionThreshold int             `yaml:"compression_threshold" toml:"compression_threshold"`
+	MaxDecompressedSize  int             `yaml:"max_decompressed_size" toml:"max_decompressed_size"`
+	Log                  struct {
+		Level  string `yaml:"level" toml:"level"`   // debug, info, warn or error
+		Format string `yaml:"format" toml:"format"` // text or json
+	} `yaml:"log" toml:"log"`
+	Discovery struct {
+		Method       string        `yaml:"method" toml:"method"`
+		Timeout      time.Duration `yaml:"timeout" toml:"timeout"`
+		PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	} `yaml:"discovery" toml:"discovery"`
+}
+
+// LoadConfigFile reads and parses a config file
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	fc := &FileConfig{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(data), fc)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("error parsing %s: unknown setting %s", path, undecoded[0])
+		}
+		return fc, nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(fc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return fc, nil
+}
+
+// apply copies the settings present in fc into ic
+func (fc *FileConfig) apply(ic *initConfig) error {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&ic.Transport, fc.Transport)
+	set(&ic.SharedMemoryDir, fc.SharedMemoryDir)
+	set(&ic.discovery, fc.Discovery.Method)
+	if fc.SharedMemory != nil {
+		ic.DisableSharedMemory = !*fc.SharedMemory
+	}
+	if fc.MaxMessageSize != 0 {
+		ic.MaxMessageSize = fc.MaxMessageSize
+	}
+	if fc.RecvTimeout != 0 {
+		ic.RecvTimeout = fc.RecvTimeout
+	}
+	if fc.ReadyTimeout != 0 {
+		ic.readyTimeout = fc.ReadyTimeout
+	}
+	if fc.Keepalive != (KeepaliveConfig{}) {
+		ic.Keepalive = fc.Keepalive
+	}
+	if fc.Retry != (RetryConfig{}) {
+		ic.Retry = fc.Retry
+	}
+	if fc.Discovery.Timeout != 0 {
+		ic.discoveryTimeout = fc.Discovery.Timeout
+	}
+	if fc.Discovery.PollInterval != 0 {
+		ic.pollInterval = fc.Discovery.PollInterval
+	}
+	if fc.Codec != "" {
+		codec, err := codecByName(fc.Codec)
+		if err != nil {
+			return err
+		}
+		ic.Codec = codec
+	}
+	if fc.Compression != "" {
+		alg, ok := Compression_value[strings.ToUpper(fc.Compression)]
+		if !ok {
+			return fmt.Errorf("unknown compression %q", fc.Compression)
+		}
+		ic.Compression = Compression(alg)
+		ic.CompressionThreshold = DefaultCompressionThreshold
+	}
+	if fc.CompressionThreshold != 0 {
+		ic.CompressionThreshold = fc.CompressionThreshold
+	}
+	if fc.MaxDecompressedSize != 0 {
+		ic.MaxDecompressedSize = fc.MaxDecompressedSize
+	}
+	if fc.Log.Level != "" || fc.Log.Format != "" {
+		ic.logLevel, ic.logFormat = fc.Log.Level, fc.Log.Format
+	}
+	return nil
+}
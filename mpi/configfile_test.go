@@ -0,0 +1,161 @@
+package mpi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a file called name in a new directory and
+// returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	want := &FileConfig{
+		Transport:      TransportTCP,
+		MaxMessageSize: 104857600,
+		RecvTimeout:    2 * time.Minute,
+		Keepalive:      KeepaliveConfig{Time: 30 * time.Second, Timeout: 10 * time.Second},
+		Retry:          RetryConfig{MaxAttempts: 8, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 10 * time.Second},
+		Codec:          "msgpack",
+	}
+	want.Log.Level = "debug"
+	want.Discovery.Method = DiscoverySSM
+	want.Discovery.Timeout = 10 * time.Minute
+
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"config.yaml", `
+transport: tcp
+max_message_size: 104857600
+recv_timeout: 2m
+keepalive: {time: 30s, timeout: 10s}
+retry: {max_attempts: 8, initial_backoff: 200ms, max_backoff: 10s}
+codec: msgpack
+log: {level: debug}
+discovery: {method: ssm, timeout: 10m}
+`},
+		{"config.toml", `
+transport = "tcp"
+max_message_size = 104857600
+recv_timeout = "2m"
+codec = "msgpack"
+
+[keepalive]
+time = "30s"
+timeout = "10s"
+
+[retry]
+max_attempts = 8
+initial_backoff = "200ms"
+max_backoff = "10s"
+
+[log]
+level = "debug"
+
+[discovery]
+method = "ssm"
+timeout = "10m"
+`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, err := LoadConfigFile(writeConfig(t, tc.name, tc.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fc, want) {
+				t.Errorf("got %+v, want %+v", fc, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, err string
+	}{
+		{"unknown.yaml", "transport: tcp\nrecieve_timeout: 2m\n", "recieve_timeout"},
+		{"nested.yaml", "retry: {attempts: 3}\n", "attempts"},
+		{"unknown.toml", "transport = \"tcp\"\nrecieve_timeout = \"2m\"\n", "unknown setting recieve_timeout"},
+		{"nested.toml", "[retry]\nattempts = 3\n", "unknown setting retry.attempts"},
+		{"duration.yaml", "recv_timeout: soon\n", "soon"},
+		{"duration.toml", "recv_timeout = \"soon\"\n", "soon"},
+		{"syntax.toml", "transport = tcp\n", "config.toml"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := "config" + filepath.Ext(tc.name)
+			_, err := LoadConfigFile(writeConfig(t, name, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got %v, want an error mentioning %q", err, tc.err)
+			}
+		})
+	}
+	if _, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file loaded")
+	}
+	if fc, err := LoadConfigFile(writeConfig(t, "empty.yaml", "")); err != nil || !reflect.DeepEqual(fc, &FileConfig{}) {
+		t.Errorf("empty file: got %+v, %v", fc, err)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	t.Setenv("MPI_RANK", "0")
+	t.Setenv("MPI_SIZE", "1")
+	t.Setenv("MPI_ADDRESS_0", "127.0.0.1:5000")
+	path := writeConfig(t, "config.yaml", "recv_timeout: 1m\ncodec: msgpack\nretry: {max_attempts: 8}\n")
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		env  string
+		want time.Duration
+	}{
+		{"file", nil, "", time.Minute},
+		{"option over file", []Option{WithRecvTimeout(2 * time.Minute)}, "", 2 * time.Minute},
+		{"environment over option", []Option{WithRecvTimeout(2 * time.Minute)}, "3m", 3 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("MPI_RECV_TIMEOUT", tc.env)
+			ic, err := configFromOptions(append([]Option{WithConfigFile(path)}, tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ic.Bootstrap.Close()
+			if ic.RecvTimeout != tc.want {
+				t.Errorf("receive timeout %v, want %v", ic.RecvTimeout, tc.want)
+			}
+			// Settings nothing overrides come from the file
+			if ic.Codec != MsgpackCodec || ic.Retry.MaxAttempts != 8 {
+				t.Errorf("codec %v and %d attempts, want the file's msgpack and 8", ic.Codec, ic.Retry.MaxAttempts)
+			}
+		})
+	}
+
+	// MPI_CONFIG names the file in place of WithConfigFile
+	t.Setenv("MPI_CONFIG", writeConfig(t, "other.toml", `recv_timeout = "4m"`))
+	ic, err := configFromOptions([]Option{WithConfigFile(path)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic.Bootstrap.Close()
+	if ic.RecvTimeout != 4*time.Minute {
+		t.Errorf("receive timeout %v, want MPI_CONFIG's 4m", ic.RecvTimeout)
+	}
+
+	t.Setenv("MPI_CONFIG", writeConfig(t, "bad.yaml", "transport: [tcp]\n"))
+	if _, err := configFromOptions(nil); !errors.Is(err, MPI_ERR_ARG) {
+		t.Errorf("unparsable file: got %v, want MPI_ERR_ARG", err)
+	}
+}
@@ -0,0 +1,87 @@
+package mpi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi/mpitest"
+)
+
+// checkTimeout reports whether err is the *TimeoutError of op for a deadline
+// that passed while waiting for peer
+func checkTimeout(err error, op string, peer int) error {
+	var te *mpi.TimeoutError
+	switch {
+	case !errors.As(err, &te):
+		return fmt.Errorf("%s: got %v, want a *TimeoutError", op, err)
+	case te.Op != op || te.Peer != peer || !te.Timeout():
+		return fmt.Errorf("%s: got %+v, want op %s waiting for rank %d until a deadline", op, te, op, peer)
+	case !errors.Is(err, mpi.MPI_ERR_TIMEOUT) || !errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%s: %v is not MPI_ERR_TIMEOUT and context.DeadlineExceeded", op, err)
+	}
+	return nil
+}
+
+func TestCtxDeadlines(t *testing.T) {
+	// Rank 1 takes no part, so every call on rank 0 waits out its deadline
+	err := mpitest.Run(2, func(comm *mpi.Comm) error {
+		if comm.Rank() != 0 {
+			return nil
+		}
+		for _, tc := range []struct {
+			op   string
+			peer int
+			call func(ctx context.Context) error
+		}{
+			{"MPI_Recv", 1, func(ctx context.Context) error {
+				_, err := comm.RecvCtx(ctx, 1, 0)
+				return err
+			}},
+			{"MPI_Ssend", 1, func(ctx context.Context) error {
+				return comm.SsendCtx(ctx, []byte("x"), 1, 0)
+			}},
+			{"MPI_Bcast", 1, func(ctx context.Context) error {
+				data := []float64{0}
+				return comm.BcastCtx(ctx, data, 1, 1)
+			}},
+		} {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			start := time.Now()
+			err := tc.call(ctx)
+			cancel()
+			if err := checkTimeout(err, tc.op, tc.peer); err != nil {
+				return err
+			}
+			if waited := time.Since(start); waited > 5*time.Second {
+				return fmt.Errorf("%s: returned after %v, long past its deadline", tc.op, waited)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCtxCancel(t *testing.T) {
+	err := mpitest.Run(2, func(comm *mpi.Comm) error {
+		if comm.Rank() != 0 {
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		_, err := comm.RecvCtx(ctx, 1, 0)
+		var te *mpi.TimeoutError
+		if !errors.As(err, &te) || te.Timeout() || !errors.Is(err, context.Canceled) {
+			return fmt.Errorf("canceled receive: got %v, want a *TimeoutError for a cancellation", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,529 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// Datatype describes how the elements of a message are laid out in memory.
+// Derived datatypes are built from the predefined ones and must be committed
+// with MPI_Type_commit before they are used in communication.
+type Datatype struct {
+	name      string
+	blocks    []typeBlock // Flattened typemap in message order
+	size      int         // Bytes of data in one item
+	lb        int         // Lower bound of one item, in bytes
+	extent    int         // Distance between consecutive items, in bytes
+	committed bool
+	freed     bool
+	basic     bool
+}
+
+// typeBlock is a run of contiguous scalars of the same width
+type typeBlock struct {
+	disp  int // Byte offset from the start of the item
+	unit  int // Width of one scalar in bytes
+	count int // Number of scalars
+}
+
+// Array orders for MPI_Type_create_subarray
+const (
+	MPI_ORDER_C       = 0 // Row-major, last dimension varies fastest
+	MPI_ORDER_FORTRAN = 1 // Column-major, first dimension varies fastest
+)
+
+// Predefined datatypes
+var (
+	MPI_BYTE       = newBasicType("MPI_BYTE", 1, 1)
+	MPI_INT8       = newBasicType("MPI_INT8", 1, 1)
+	MPI_INT16      = newBasicType("MPI_INT16", 2, 1)
+	MPI_INT32      = newBasicType("MPI_INT32", 4, 1)
+	MPI_INT64      = newBasicType("MPI_INT64", 8, 1)
+	MPI_INT        = newBasicType("MPI_INT", int(unsafe.Sizeof(int(0))), 1)
+	MPI_UINT8      = newBasicType("MPI_UINT8", 1, 1)
+	MPI_UINT16     = newBasicType("MPI_UINT16", 2, 1)
+	MPI_UINT32     = newBasicType("MPI_UINT32", 4, 1)
+	MPI_UINT64     = newBasicType("MPI_UINT64", 8, 1)
+	MPI_FLOAT32    = newBasicType("MPI_FLOAT32", 4, 1)
+	MPI_FLOAT64    = newBasicType("MPI_FLOAT64", 8, 1)
+	MPI_COMPLEX64  = newBasicType("MPI_COMPLEX64", 4, 2)
+	MPI_COMPLEX128 = newBasicType("MPI_COMPLEX128", 8, 2)
+)
+
+func newBasicType(name string, unit int, count int) *Datatype {
+	return &Datatype{
+		name:      name,
+		blocks:    []typeBlock{{disp: 0, unit: unit, count: count}},
+		size:      unit * count,
+		extent:    unit * count,
+		committed: true,
+		basic:     true,
+	}
+}
+
+func (dt *Datatype) String() string {
+	return dt.name
+}
+
+// check reports an error if dt cannot be used to build new datatypes
+func (dt *Datatype) check() error {
+	if dt == nil {
+		return errorf(MPI_ERR_TYPE, "datatype is nil")
+	}
+	if dt.freed {
+		return errorf(MPI_ERR_TYPE, "datatype %s has been freed", dt.name)
+	}
+	return nil
+}
+
+// appendBlock adds a block to the typemap, merging it into the previous one
+// when it continues it directly
+func appendBlock(blocks []typeBlock, b typeBlock) []typeBlock {
+	if n := len(blocks); n > 0 {
+		last := &blocks[n-1]
+		if last.unit == b.unit && last.disp+last.count*last.unit == b.disp {
+			last.count += b.count
+			return blocks
+		}
+	}
+	return append(blocks, b)
+}
+
+// builder accumulates the typemap of a derived datatype
+type builder struct {
+	blocks []typeBlock
+	size   int
+	lb, ub int
+	empty  bool
+}
+
+func newBuilder() *builder {
+	return &builder{empty: true}
+}
+
+// add appends count consecutive items of old starting at byte offset disp
+func (b *builder) add(old *Datatype, disp int, count int) {
+	if count <= 0 {
+		return
+	}
+	for i := 0; i < count; i++ {
+		base := disp + i*old.extent
+		for _, blk := range old.blocks {
+			blk.disp += base
+			b.blocks = appendBlock(b.blocks, blk)
+		}
+	}
+	b.size += count * old.size
+	lb := disp + old.lb
+	ub := disp + (count-1)*old.extent + old.lb + old.extent
+	if b.empty || lb < b.lb {
+		b.lb = lb
+	}
+	if b.empty || ub > b.ub {
+		b.ub = ub
+	}
+	b.empty = false
+}
+
+func (b *builder) build(name string) *Datatype {
+	return &Datatype{
+		name:   name,
+		blocks: b.blocks,
+		size:   b.size,
+		lb:     b.lb,
+		extent: b.ub - b.lb,
+	}
+}
+
+// MPI_Type_contiguous creates a datatype of count consecutive items of old
+func MPI_Type_contiguous(count int, old *Datatype) (*Datatype, error) {
+	if err := old.check(); err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	b := newBuilder()
+	b.add(old, 0, count)
+	return b.build(fmt.Sprintf("contiguous(%d, %s)", count, old)), nil
+}
+
+// MPI_Type_vector creates a datatype of count blocks of blocklength items of
+// old, with the starts of consecutive blocks stride items apart. A column of
+// an n by m row-major matrix is MPI_Type_vector(n, 1, m, MPI_FLOAT64).
+func MPI_Type_vector(count int, blocklength int, stride int, old *Datatype) (*Datatype, error) {
+	if err := old.check(); err != nil {
+		return nil, err
+	}
+	if count < 0 || blocklength < 0 {
+		return nil, errorf(MPI_ERR_COUNT, "invalid count %d or block length %d", count, blocklength)
+	}
+	b := newBuilder()
+	for i := 0; i < count; i++ {
+		b.add(old, i*stride*old.extent, blocklength)
+	}
+	return b.build(fmt.Sprintf("vector(%d, %d, %d, %s)", count, blocklength, stride, old)), nil
+}
+
+// MPI_Type_indexed creates a datatype of blocks of old with the given
+// lengths, each starting at a displacement counted in items of old
+func MPI_Type_indexed(blocklengths []int, displacements []int, old *Datatype) (*Datatype, error) {
+	if err := old.check(); err != nil {
+		return nil, err
+	}
+	if len(blocklengths) != len(displacements) {
+		return nil, errorf(MPI_ERR_ARG, "got %d block lengths but %d displacements", len(blocklengths), len(displacements))
+	}
+	b := newBuilder()
+	for i, n := range blocklengths {
+		if n < 0 {
+			return nil, errorf(MPI_ERR_COUNT, "invalid block length %d", n)
+		}
+		b.add(old, displacements[i]*old.extent, n)
+	}
+	return b.build(fmt.Sprintf("indexed(%d blocks, %s)", len(blocklengths), old)), nil
+}
+
+// MPI_Type_create_struct creates a datatype of blocks of different types,
+// each starting at a displacement in bytes. For a Go struct the
+// displacements come from unsafe.Offsetof; use MPI_Type_create_resized to
+// set the extent to unsafe.Sizeof the struct when sending arrays of them.
+func MPI_Type_create_struct(blocklengths []int, displacements []int, types []*Datatype) (*Datatype, error) {
+	if len(blocklengths) != len(displacements) || len(blocklengths) != len(types) {
+		return nil, errorf(MPI_ERR_ARG, "got %d block lengths, %d displacements and %d types",
+			len(blocklengths), len(displacements), len(types))
+	}
+	b := newBuilder()
+	for i, n := range blocklengths {
+		if err := types[i].check(); err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errorf(MPI_ERR_COUNT, "invalid block length %d", n)
+		}
+		b.add(types[i], displacements[i], n)
+	}
+	return b.build(fmt.Sprintf("struct(%d blocks)", len(blocklengths))), nil
+}
+
+// MPI_Type_create_subarray creates a datatype for the block of subsizes
+// elements starting at starts within an array of the given sizes
+func MPI_Type_create_subarray(sizes []int, subsizes []int, starts []int, order int, old *Datatype) (*Datatype, error) {
+	if err := old.check(); err != nil {
+		return nil, err
+	}
+	ndims := len(sizes)
+	if ndims == 0 || len(subsizes) != ndims || len(starts) != ndims {
+		return nil, errorf(MPI_ERR_ARG, "sizes, subsizes and starts must have the same non-zero length")
+	}
+	if order != MPI_ORDER_C && order != MPI_ORDER_FORTRAN {
+		return nil, errorf(MPI_ERR_ARG, "invalid array order %d", order)
+	}
+	for d := 0; d < ndims; d++ {
+		if subsizes[d] < 1 || starts[d] < 0 || starts[d]+subsizes[d] > sizes[d] {
+			return nil, errorf(MPI_ERR_ARG, "subarray dimension %d out of range: start %d, size %d of %d",
+				d, starts[d], subsizes[d], sizes[d])
+		}
+	}
+
+	// Work in C order; Fortran order is the same with the dimensions reversed
+	dims := make([]int, ndims)
+	for d := range dims {
+		dims[d] = d
+		if order == MPI_ORDER_FORTRAN {
+			dims[d] = ndims - 1 - d
+		}
+	}
+	strides := make([]int, ndims) // In elements
+	stride := 1
+	for i := ndims - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= sizes[dims[i]]
+	}
+
+	// Every row along the fastest dimension is one contiguous run
+	b := newBuilder()
+	last := dims[ndims-1]
+	index := make([]int, ndims-1)
+	for {
+		offset := starts[last]
+		for i, d := range dims[:ndims-1] {
+			offset += (starts[d] + index[i]) * strides[i]
+		}
+		b.add(old, offset*old.extent, subsizes[last])
+
+		// Advance the index of the outer dimensions like an odometer
+		i := len(index) - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < subsizes[dims[i]] {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			break
+		}
+	}
+	// The extent of a subarray is the whole array
+	b.lb, b.ub = 0, stride*old.extent
+	return b.build(fmt.Sprintf("subarray(%v of %v, %s)", subsizes, sizes, old)), nil
+}
+
+// MPI_Type_create_resized returns a copy of old with a new lower bound and
+// extent, which changes the stride between consecutive items
+func MPI_Type_create_resized(old *Datatype, lb int, extent int) (*Datatype, error) {
+	if err := old.check(); err != nil {
+		return nil, err
+	}
+	if extent < 0 {
+		return nil, errorf(MPI_ERR_ARG, "invalid extent %d", extent)
+	}
+	return &Datatype{
+		name:   fmt.Sprintf("resized(%s, %d, %d)", old, lb, extent),
+		blocks: append([]typeBlock(nil), old.blocks...),
+		size:   old.size,
+		lb:     lb,
+		extent: extent,
+	}, nil
+}
+
+// MPI_Type_commit makes a datatype usable in communication
+func MPI_Type_commit(dt *Datatype) error {
+	if err := dt.check(); err != nil {
+		return err
+	}
+	dt.committed = true
+	return nil
+}
+
+// MPI_Type_free releases a derived datatype. Datatypes built from it are
+// not affected.
+func MPI_Type_free(dt *Datatype) error {
+	if err := dt.check(); err != nil {
+		return err
+	}
+	if dt.basic {
+		return errorf(MPI_ERR_TYPE, "cannot free predefined datatype %s", dt.name)
+	}
+	dt.freed = true
+	dt.blocks = nil
+	return nil
+}
+
+// MPI_Type_size returns the number of bytes of data in one item of dt
+func MPI_Type_size(dt *Datatype) (int, error) {
+	if err := dt.check(); err != nil {
+		return 0, err
+	}
+	return dt.size, nil
+}
+
+// MPI_Type_get_extent returns the lower bound and extent of dt in bytes
+func MPI_Type_get_extent(dt *Datatype) (int, int, error) {
+	if err := dt.check(); err != nil {
+		return 0, 0, err
+	}
+	return dt.lb, dt.extent, nil
+}
+
+// MPI_Send_datatype sends count items of dt read from buf, which must be a
+// slice or a pointer to elements that hold no pointers, such as numbers or
+// structs of numbers. Non-contiguous layouts are gathered directly from buf.
+func MPI_Send_datatype(buf interface{}, count int, dt *Datatype, dest int, tag int) error {
+	return world.SendDatatype(buf, count, dt, dest, tag)
+}
+
+// MPI_Send_datatypeCtx is MPI_Send_datatype that gives up when ctx ends, with
+// a *TimeoutError
+func MPI_Send_datatypeCtx(ctx context.Context, buf interface{}, count int, dt *Datatype, dest int, tag int) error {
+	return world.SendDatatypeCtx(ctx, buf, count, dt, dest, tag)
+}
+
+// MPI_Recv_datatype receives up to count items of dt and scatters them
+// directly into buf, which must be a slice or a pointer to elements that
+// hold no pointers
+func MPI_Recv_datatype(buf interface{}, count int, dt *Datatype, source int, tag int) error {
+	return world.RecvDatatype(buf, count, dt, source, tag)
+}
+
+// MPI_Recv_datatypeCtx is MPI_Recv_datatype that gives up when ctx ends, with
+// a *TimeoutError
+func MPI_Recv_datatypeCtx(ctx context.Context, buf interface{}, count int, dt *Datatype, source int, tag int) error {
+	return world.RecvDatatypeCtx(ctx, buf, count, dt, source, tag)
+}
+
+// SendDatatype is MPI_Send_datatype on c
+func (c *Comm) SendDatatype(buf interface{}, count int, dt *Datatype, dest int, tag int) error {
+	return c.SendDatatypeCtx(context.Background(), buf, count, dt, dest, tag)
+}
+
+// SendDatatypeCtx is MPI_Send_datatypeCtx on c
+func (c *Comm) SendDatatypeCtx(ctx context.Context, buf interface{}, count int, dt *Datatype, dest int, tag int) error {
+	if err := c.checkDest(dest, tag); err != nil {
+		return c.handleError(err)
+	}
+	data, err := packDatatype(buf, count, dt)
+	if err != nil {
+		return c.handleError(err)
+	}
+	return c.handleError(c.send(ctx, "MPI_Send_datatype", data, dest, tag, SendMode_STANDARD))
+}
+
+// RecvDatatype is MPI_Recv_datatype on c
+func (c *Comm) RecvDatatype(buf interface{}, count int, dt *Datatype, source int, tag int) error {
+	return c.RecvDatatypeCtx(context.Background(), buf, count, dt, source, tag)
+}
+
+// RecvDatatypeCtx is MPI_Recv_datatypeCtx on c
+func (c *Comm) RecvDatatypeCtx(ctx context.Context, buf interface{}, count int, dt *Datatype, source int, tag int) error {
+	if err := c.checkSource(source, tag); err != nil {
+		return c.handleError(err)
+	}
+	data, err := c.recv(ctx, "MPI_Recv_datatype", source, tag)
+	if err != nil {
+		return c.handleError(err)
+	}
+	return c.handleError(unpackDatatype(data, buf, count, dt))
+}
+
+// usable reports an error if dt cannot be used in communication
+func (dt *Datatype) usable() error {
+	if err := dt.check(); err != nil {
+		return err
+	}
+	if !dt.committed {
+		return errorf(MPI_ERR_TYPE, "datatype %s has not been committed", dt.name)
+	}
+	return nil
+}
+
+// packDatatype gathers count items of dt from buf into a contiguous,
+// little-endian message
+func packDatatype(buf interface{}, count int, dt *Datatype) ([]byte, error) {
+	if err := dt.usable(); err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	return appendPacked(make([]byte, 0, count*dt.size), buf, count, dt)
+}
+
+// appendPacked appends count items of dt gathered from buf to out
+func appendPacked(out []byte, buf interface{}, count int, dt *Datatype) ([]byte, error) {
+	mem, err := bufferBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(buf)
+
+	for i := 0; i < count; i++ {
+		base := i * dt.extent
+		for _, blk := range dt.blocks {
+			start, end := base+blk.disp, base+blk.disp+blk.count*blk.unit
+			if start < 0 || end > len(mem) {
+				return nil, errorf(MPI_ERR_BUFFER, "item %d of %s reaches bytes %d to %d of a %d byte buffer",
+					i, dt.name, start, end, len(mem))
+			}
+			out = append(out, mem[start:end]...)
+			if !hostLittleEndian {
+				swapBytes(out[len(out)-(end-start):], blk.unit)
+			}
+		}
+	}
+	return out, nil
+}
+
+// unpackDatatype scatters a message built by packDatatype into buf. The
+// message may hold fewer than count items but not more, and no partial item.
+func unpackDatatype(data []byte, buf interface{}, count int, dt *Datatype) error {
+	if err := dt.usable(); err != nil {
+		return err
+	}
+	if count < 0 {
+		return errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	if len(data) > count*dt.size {
+		return errorf(MPI_ERR_TRUNCATE, "message of %d bytes is larger than %d items of %s", len(data), count, dt.name)
+	}
+	if dt.size > 0 && len(data)%dt.size != 0 {
+		return errorf(MPI_ERR_TYPE, "message of %d bytes is not a whole number of %d byte items of %s", len(data), dt.size, dt.name)
+	}
+	mem, err := bufferBytes(buf)
+	if err != nil {
+		return err
+	}
+	defer runtime.KeepAlive(buf)
+
+	for i := 0; i < count && len(data) > 0; i++ {
+		base := i * dt.extent
+		for _, blk := range dt.blocks {
+			if len(data) == 0 {
+				break
+			}
+			n := min(blk.count*blk.unit, len(data))
+			start := base + blk.disp
+			if start < 0 || start+n > len(mem) {
+				return errorf(MPI_ERR_BUFFER, "item %d of %s reaches bytes %d to %d of a %d byte buffer",
+					i, dt.name, start, start+n, len(mem))
+			}
+			copy(mem[start:start+n], data[:n])
+			if !hostLittleEndian {
+				swapBytes(mem[start:start+n], blk.unit)
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
+
+// bufferBytes returns the memory behind a slice or the value a pointer
+// points to. The caller must keep buf alive while using the result. The
+// elements must hold no pointers, since the memory is overwritten with bytes
+// from the network.
+func bufferBytes(buf interface{}) ([]byte, error) {
+	v := reflect.ValueOf(buf)
+	if k := v.Kind(); (k == reflect.Slice || k == reflect.Pointer) && !pointerFree(v.Type().Elem()) {
+		return nil, errorf(MPI_ERR_TYPE, "buffer elements of type %s hold pointers", v.Type().Elem())
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		n := v.Len() * int(v.Type().Elem().Size())
+		if n == 0 {
+			return nil, nil
+		}
+		return unsafe.Slice((*byte)(v.UnsafePointer()), n), nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, errorf(MPI_ERR_BUFFER, "buffer pointer is nil")
+		}
+		return unsafe.Slice((*byte)(v.UnsafePointer()), int(v.Type().Elem().Size())), nil
+	}
+	return nil, errorf(MPI_ERR_BUFFER, "buffer must be a slice or a pointer, got %T", buf)
+}
+
+// pointerFree reports whether values of t consist only of booleans and
+// numbers, possibly in arrays and structs
+func pointerFree(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return pointerFree(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !pointerFree(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
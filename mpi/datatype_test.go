@@ -0,0 +1,64 @@
+package mpi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDatatypeRejectsPointerBuffers(t *testing.T) {
+	type point struct{ X, Y float64 }
+	type named struct {
+		Name string
+		X    float64
+	}
+	ok := []interface{}{[]float64{1}, []point{{}}, &[4]int32{}, []struct{ A [2]uint8 }{{}}, []bool{true}}
+	for _, buf := range ok {
+		if _, err := bufferBytes(buf); err != nil {
+			t.Errorf("%T: %v", buf, err)
+		}
+	}
+	bad := []interface{}{[]*int{nil}, []string{""}, []named{{}}, [][]byte{nil}, []map[int]int{nil}, []interface{}{0}, &[1]*int{}}
+	for _, buf := range bad {
+		if _, err := bufferBytes(buf); !errors.Is(err, MPI_ERR_TYPE) {
+			t.Errorf("%T: got %v, want MPI_ERR_TYPE", buf, err)
+		}
+	}
+}
+
+func TestUnpackDatatypeRejectsPartialItem(t *testing.T) {
+	buf := make([]float64, 4)
+	if err := unpackDatatype(make([]byte, 12), buf, 4, MPI_FLOAT64); !errors.Is(err, MPI_ERR_TYPE) {
+		t.Errorf("12 bytes of float64: got %v, want MPI_ERR_TYPE", err)
+	}
+	if err := unpackDatatype(make([]byte, 16), buf, 4, MPI_FLOAT64); err != nil {
+		t.Errorf("two whole items: %v", err)
+	}
+	if err := unpackDatatype(make([]byte, 40), buf, 4, MPI_FLOAT64); !errors.Is(err, MPI_ERR_TRUNCATE) {
+		t.Errorf("five items into four: got %v, want MPI_ERR_TRUNCATE", err)
+	}
+}
+
+func TestSendRecvDatatypeColumn(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	// Column 1 of a 3x3 row-major matrix
+	column, err := MPI_Type_vector(3, 1, 3, MPI_FLOAT64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MPI_Type_commit(column); err != nil {
+		t.Fatal(err)
+	}
+	matrix := []float64{0, 1, 2, 3, 4, 5, 6, 7, 8}
+	done := make(chan error, 1)
+	go func() { done <- comms[0].SendDatatype(matrix[1:], 1, column, 1, 0) }()
+	got := make([]float64, 3)
+	if err := comms[1].RecvDatatype(got, 3, MPI_FLOAT64, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 1 || got[1] != 4 || got[2] != 7 {
+		t.Errorf("got %v, want [1 4 7]", got)
+	}
+}
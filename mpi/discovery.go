@@ -0,0 +1,166 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Discovery methods for MPI_DISCOVERY. Each names a Bootstrap; more can be
+// added with RegisterBootstrap.
+const (
+	DiscoveryEnv  = "env"  // MPI_RANK, MPI_SIZE and MPI_ADDRESS_<n>; the default
+	DiscoveryEC2  = "ec2"  // EC2 instance tags, see EC2Discovery
+	DiscoverySSM  = "ssm"  // SSM Parameter Store, see SSMRendezvous
+	DiscoveryS3   = "s3"   // A JobManifest in S3, see S3Rendezvous
+	DiscoveryFile = "file" // A JobManifest in the file named by MPI_BOOTSTRAP_FILE
+	DiscoveryHTTP = "http" // A Coordinator at MPI_BOOTSTRAP_URL
+)
+
+// DefaultPort is the port ranks listen on when discovery finds their hosts
+// and MPI_PORT is not set
+const DefaultPort = 5000
+
+// DefaultDiscoveryTimeout bounds how long discovery waits for the rest of the
+// job unless configured otherwise
+const DefaultDiscoveryTimeout = 5 * time.Minute
+
+// layout fills in cfg's rank, size, addresses and bootstrap using the
+// discovery method, DiscoveryEnv if empty. It waits at most timeout for the
+// rest of the job, DefaultDiscoveryTimeout if zero. If the method also
+// provides a job manifest, it is returned for its shared settings.
+func layout(cfg *Config, method string, timeout, pollInterval time.Duration) (*JobManifest, error) {
+	if method == "" {
+		method = DiscoveryEnv
+	}
+	if timeout == 0 {
+		timeout = DefaultDiscoveryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	b, err := newBootstrap(ctx, method, BootstrapConfig{PollInterval: pollInterval})
+	if err != nil {
+		return nil, err
+	}
+	if err := layoutFromBootstrap(ctx, b, cfg); err != nil {
+		b.Close()
+		return nil, err
+	}
+	cfg.Bootstrap = b
+	if mb, ok := b.(manifestBootstrap); ok {
+		return mb.manifest(), nil
+	}
+	return nil, nil
+}
+
+// rankFromEnv returns MPI_RANK if set, or else the rank whose address is on
+// this host
+func rankFromEnv(addresses map[int]string) (int, error) {
+	s := os.Getenv("MPI_RANK")
+	if s == "" {
+		return localRank(addresses)
+	}
+	rank, err := strconv.Atoi(s)
+	if err != nil || rank < 0 || rank >= len(addresses) {
+		return 0, fmt.Errorf("MPI_RANK invalid for a job of %d ranks: %q", len(addresses), s)
+	}
+	return rank, nil
+}
+
+// layoutFromVars reads MPI_RANK, MPI_SIZE and MPI_ADDRESS_<n>
+func layoutFromVars(cfg *Config) error {
+	var err error
+	cfg.Rank, err = strconv.Atoi(os.Getenv("MPI_RANK"))
+	if err != nil {
+		return fmt.Errorf("MPI_RANK not set or invalid: %v", err)
+	}
+	cfg.Size, err = strconv.Atoi(os.Getenv("MPI_SIZE"))
+	if err != nil {
+		return fmt.Errorf("MPI_SIZE not set or invalid: %v", err)
+	}
+
+	cfg.Addresses = make(map[int]string)
+	for i := 0; i < cfg.Size; i++ {
+		addr := os.Getenv("MPI_ADDRESS_" + strconv.Itoa(i))
+		if addr == "" {
+			return fmt.Errorf("MPI_ADDRESS_%d not set", i)
+		}
+		cfg.Addresses[i] = addr
+	}
+	return nil
+}
+
+// portFromEnv returns MPI_PORT, or DefaultPort if it is not set
+func portFromEnv() (int, error) {
+	s := os.Getenv("MPI_PORT")
+	if s == "" {
+		return DefaultPort, nil
+	}
+	port, err := strconv.Atoi(s)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("MPI_PORT invalid: %q", s)
+	}
+	return port, nil
+}
+
+// sizeFromEnv returns MPI_SIZE, or 0 if it is not set
+func sizeFromEnv() (int, error) {
+	s := os.Getenv("MPI_SIZE")
+	if s == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("MPI_SIZE invalid: %q", s)
+	}
+	return size, nil
+}
+
+// advertisedHost returns MPI_HOST, or else the IP address this host uses to
+// reach other networks
+func advertisedHost() (string, error) {
+	if host := os.Getenv("MPI_HOST"); host != "" {
+		return host, nil
+	}
+	// Connecting a UDP socket sends nothing; it only picks the source
+	// address for the route
+	conn, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		return "", fmt.Errorf("cannot determine this host's address, set MPI_HOST: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// localRank finds the rank whose address is on one of this host's network
+// interfaces
+func localRank(addresses map[int]string) (int, error) {
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return 0, fmt.Errorf("error listing network interfaces: %v", err)
+	}
+	rank := -1
+	for r, addr := range addresses {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		for _, ifaddr := range ifaddrs {
+			if ipnet, ok := ifaddr.(*net.IPNet); ok && ip != nil && ipnet.IP.Equal(ip) {
+				if rank >= 0 {
+					return 0, fmt.Errorf("ranks %d and %d are both on this host; set MPI_RANK", rank, r)
+				}
+				rank = r
+			}
+		}
+	}
+	if rank < 0 {
+		return 0, fmt.Errorf("none of the %d discovered addresses is on this host; set MPI_RANK", len(addresses))
+	}
+	return rank, nil
+}
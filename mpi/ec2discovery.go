@@ -0,0 +1,192 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultEC2JobTag is the tag key that marks the instances of a job
+const DefaultEC2JobTag = "mpi-job"
+
+// EC2Discovery finds the ranks of a job among the pending and running EC2
+// instances whose JobTag is Job. Each rank listens on Port at its instance's
+// private IP. Ranks are numbered by the integer RankTag of each instance if
+// set, otherwise in order of launch index and then instance ID.
+//
+// MPI_DISCOVERY=ec2 configures it from MPI_EC2_JOB, MPI_EC2_JOB_TAG,
+// MPI_EC2_RANK_TAG, MPI_PORT (default 5000) and MPI_SIZE, waits for up to
+// MPI_DISCOVERY_TIMEOUT, and finds the calling rank by its IP address unless
+// MPI_RANK is set. The instance role needs ec2:DescribeInstances, and
+// MPI_EC2_ENDPOINT overrides the endpoint.
+type EC2Discovery struct {
+	// Client is usually an *ec2.Client; tests can substitute a fake
+	Client  ec2.DescribeInstancesAPIClient
+	JobTag  string // DefaultEC2JobTag if empty
+	Job     string
+	RankTag string
+	Port    int
+	// Size is the number of instances to wait for. If zero, the instances
+	// found by the first lookup make up the job.
+	Size int
+	// PollInterval is the time between lookups while waiting,
+	// DefaultPollInterval if zero
+	PollInterval time.Duration
+}
+
+// Discover returns the address of every rank, waiting until Size instances
+// are found and, if RankTag is set, all of them are tagged
+func (d *EC2Discovery) Discover(ctx context.Context) (map[int]string, error) {
+	interval := d.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	for {
+		addresses, err := d.lookup(ctx)
+		if err == nil && d.Size != 0 && len(addresses) != d.Size {
+			err = fmt.Errorf("found %d instances, waiting for %d", len(addresses), d.Size)
+		}
+		if err == nil || d.Size == 0 {
+			return addresses, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("EC2 discovery of job %q timed out: %v", d.Job, err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (d *EC2Discovery) lookup(ctx context.Context) (map[int]string, error) {
+	jobTag := d.JobTag
+	if jobTag == "" {
+		jobTag = DefaultEC2JobTag
+	}
+	input := &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + jobTag), Values: []string{d.Job}},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	}
+	var instances []types.Instance
+	pages := ec2.NewDescribeInstancesPaginator(d.Client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing EC2 instances: %v", err)
+		}
+		for _, res := range page.Reservations {
+			instances = append(instances, res.Instances...)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("no EC2 instances have tag %s=%s", jobTag, d.Job)
+	}
+	for _, inst := range instances {
+		if aws.ToString(inst.PrivateIpAddress) == "" {
+			return nil, fmt.Errorf("instance %s has no private IP yet", aws.ToString(inst.InstanceId))
+		}
+	}
+
+	addresses := make(map[int]string, len(instances))
+	port := strconv.Itoa(d.Port)
+	if d.RankTag == "" {
+		sort.Slice(instances, func(i, j int) bool {
+			a, b := instances[i], instances[j]
+			if li, lj := aws.ToInt32(a.AmiLaunchIndex), aws.ToInt32(b.AmiLaunchIndex); li != lj {
+				return li < lj
+			}
+			return aws.ToString(a.InstanceId) < aws.ToString(b.InstanceId)
+		})
+		for r, inst := range instances {
+			addresses[r] = net.JoinHostPort(aws.ToString(inst.PrivateIpAddress), port)
+		}
+		return addresses, nil
+	}
+	for _, inst := range instances {
+		id := aws.ToString(inst.InstanceId)
+		value, ok := instanceTag(inst, d.RankTag)
+		if !ok {
+			return nil, fmt.Errorf("instance %s has no %s tag", id, d.RankTag)
+		}
+		r, err := strconv.Atoi(value)
+		if err != nil || r < 0 || r >= len(instances) {
+			return nil, fmt.Errorf("instance %s has %s=%q, expected a rank below %d", id, d.RankTag, value, len(instances))
+		}
+		if _, dup := addresses[r]; dup {
+			return nil, fmt.Errorf("more than one instance has %s=%d", d.RankTag, r)
+		}
+		addresses[r] = net.JoinHostPort(aws.ToString(inst.PrivateIpAddress), port)
+	}
+	return addresses, nil
+}
+
+func instanceTag(inst types.Instance, key string) (string, bool) {
+	for _, tag := range inst.Tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value), true
+		}
+	}
+	return "", false
+}
+
+// discoverEC2FromEnv runs EC2Discovery configured by MPI_EC2_JOB,
+// MPI_EC2_JOB_TAG, MPI_EC2_RANK_TAG, MPI_PORT and MPI_SIZE. The client uses
+// the usual AWS configuration, and MPI_EC2_ENDPOINT if set.
+func discoverEC2FromEnv(ctx context.Context, pollInterval time.Duration) (map[int]string, error) {
+	job := os.Getenv("MPI_EC2_JOB")
+	if job == "" {
+		return nil, fmt.Errorf("MPI_EC2_JOB not set")
+	}
+	port, err := portFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	size, err := sizeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	client := ec2.NewFromConfig(awsCfg, func(o *ec2.Options) {
+		if endpoint := os.Getenv("MPI_EC2_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	d := &EC2Discovery{
+		Client:  client,
+		JobTag:  os.Getenv("MPI_EC2_JOB_TAG"),
+		Job:     job,
+		RankTag: os.Getenv("MPI_EC2_RANK_TAG"),
+		Port:    port,
+		Size:    size,
+
+		PollInterval: pollInterval,
+	}
+	return d.Discover(ctx)
+}
+
+// newEC2Bootstrap discovers the job with discoverEC2FromEnv. The rank is
+// MPI_RANK, or else the one whose address is on this host.
+func newEC2Bootstrap(ctx context.Context, cfg BootstrapConfig) (Bootstrap, error) {
+	addresses, err := discoverEC2FromEnv(ctx, cfg.PollInterval)
+	if err != nil {
+		return nil, err
+	}
+	rank, err := rankFromEnv(addresses)
+	if err != nil {
+		return nil, err
+	}
+	return newStaticBootstrap(DiscoveryEC2, rank, addresses), nil
+}
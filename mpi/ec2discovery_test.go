@@ -0,0 +1,172 @@
+package mpi
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fakeEC2 answers each DescribeInstances call with the next of its
+// snapshots, repeating the last one
+type fakeEC2 struct {
+	snapshots [][]types.Instance
+	calls     int
+	input     *ec2.DescribeInstancesInput
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, in *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.input = in
+	snapshot := f.snapshots[min(f.calls, len(f.snapshots)-1)]
+	f.calls++
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{{Instances: snapshot}},
+	}, nil
+}
+
+// instance describes an EC2 instance with the given ID, private IP, launch
+// index and tags as key-value pairs
+func instance(id, ip string, launchIndex int32, tags ...string) types.Instance {
+	inst := types.Instance{InstanceId: aws.String(id), AmiLaunchIndex: aws.Int32(launchIndex)}
+	if ip != "" {
+		inst.PrivateIpAddress = aws.String(ip)
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		inst.Tags = append(inst.Tags, types.Tag{Key: aws.String(tags[i]), Value: aws.String(tags[i+1])})
+	}
+	return inst
+}
+
+func TestEC2DiscoveryOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rankTag   string
+		instances []types.Instance
+		want      map[int]string
+	}{
+		{
+			name: "launch index then instance ID",
+			instances: []types.Instance{
+				instance("i-c", "10.0.0.3", 1),
+				instance("i-b", "10.0.0.2", 0),
+				instance("i-a", "10.0.0.1", 1),
+			},
+			want: map[int]string{0: "10.0.0.2:5000", 1: "10.0.0.1:5000", 2: "10.0.0.3:5000"},
+		},
+		{
+			name:    "rank tag",
+			rankTag: "rank",
+			instances: []types.Instance{
+				instance("i-a", "10.0.0.1", 0, "rank", "2"),
+				instance("i-b", "10.0.0.2", 1, "rank", "0"),
+				instance("i-c", "10.0.0.3", 2, "rank", "1"),
+			},
+			want: map[int]string{0: "10.0.0.2:5000", 1: "10.0.0.3:5000", 2: "10.0.0.1:5000"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeEC2{snapshots: [][]types.Instance{tc.instances}}
+			d := &EC2Discovery{Client: client, Job: "job-1", RankTag: tc.rankTag, Port: 5000}
+			got, err := d.Discover(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			filter := client.input.Filters[0]
+			if aws.ToString(filter.Name) != "tag:"+DefaultEC2JobTag || !reflect.DeepEqual(filter.Values, []string{"job-1"}) {
+				t.Errorf("filtered on %s=%v", aws.ToString(filter.Name), filter.Values)
+			}
+		})
+	}
+}
+
+func TestEC2DiscoveryRejectsBadRankTags(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		instances []types.Instance
+		want      string
+	}{
+		{
+			name: "duplicate",
+			instances: []types.Instance{
+				instance("i-a", "10.0.0.1", 0, "rank", "1"),
+				instance("i-b", "10.0.0.2", 1, "rank", "1"),
+			},
+			want: "more than one instance",
+		},
+		{
+			name: "out of range",
+			instances: []types.Instance{
+				instance("i-a", "10.0.0.1", 0, "rank", "0"),
+				instance("i-b", "10.0.0.2", 1, "rank", "2"),
+			},
+			want: "expected a rank below 2",
+		},
+		{
+			name: "not a number",
+			instances: []types.Instance{
+				instance("i-a", "10.0.0.1", 0, "rank", "first"),
+			},
+			want: "expected a rank below 1",
+		},
+		{
+			name: "missing",
+			instances: []types.Instance{
+				instance("i-a", "10.0.0.1", 0, "rank", "0"),
+				instance("i-b", "10.0.0.2", 1),
+			},
+			want: "has no rank tag",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &EC2Discovery{Client: &fakeEC2{snapshots: [][]types.Instance{tc.instances}}, Job: "job-1", RankTag: "rank"}
+			_, err := d.Discover(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEC2DiscoveryWaitsForInstances(t *testing.T) {
+	client := &fakeEC2{snapshots: [][]types.Instance{
+		{instance("i-a", "10.0.0.1", 0)},
+		// The second instance is pending and has no address yet
+		{instance("i-a", "10.0.0.1", 0), instance("i-b", "", 1)},
+		{instance("i-a", "10.0.0.1", 0), instance("i-b", "10.0.0.2", 1)},
+	}}
+	d := &EC2Discovery{Client: client, Job: "job-1", Port: 5000, Size: 2, PollInterval: time.Millisecond}
+	got, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]string{0: "10.0.0.1:5000", 1: "10.0.0.2:5000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if client.calls != 3 {
+		t.Errorf("%d lookups, want 3", client.calls)
+	}
+}
+
+func TestEC2DiscoveryWithoutPrivateIP(t *testing.T) {
+	client := &fakeEC2{snapshots: [][]types.Instance{{instance("i-a", "", 0)}}}
+	d := &EC2Discovery{Client: client, Job: "job-1"}
+	if _, err := d.Discover(context.Background()); err == nil || !strings.Contains(err.Error(), "no private IP") {
+		t.Errorf("got %v, want an error for the missing private IP", err)
+	}
+
+	d.Size = 1
+	d.PollInterval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := d.Discover(ctx); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout waiting for the private IP", err)
+	}
+}
@@ -0,0 +1,239 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorClass is an MPI error class. Errors returned by this package carry
+// one, so errors.Is(err, MPI_ERR_RANK) tells what went wrong, and
+// MPI_Error_class returns it. Ranks and tags are checked before anything is
+// sent, and a panic in a reduction operation is returned as MPI_ERR_OP.
+type ErrorClass int
+
+const (
+	MPI_SUCCESS         ErrorClass = iota
+	MPI_ERR_BUFFER                 // Invalid, too small or missing buffer
+	MPI_ERR_COUNT                  // Invalid count
+	MPI_ERR_TYPE                   // Invalid datatype, or a value the codec cannot handle
+	MPI_ERR_TAG                    // Invalid tag
+	MPI_ERR_RANK                   // Invalid source or destination rank
+	MPI_ERR_ROOT                   // Invalid root rank
+	MPI_ERR_OP                     // Invalid or failing reduction operation
+	MPI_ERR_REQUEST                // Invalid request
+	MPI_ERR_ARG                    // Invalid argument of another kind
+	MPI_ERR_TRUNCATE               // Message larger than the receive buffer
+	MPI_ERR_TIMEOUT                // Deadline or receive timeout passed, see TimeoutError
+	MPI_ERR_PROC_FAILED            // Peer unreachable, even after retries
+	MPI_ERR_OTHER                  // Any other error
+)
+
+var errorClassNames = [...]string{
+	MPI_SUCCESS:         "MPI_SUCCESS: no error",
+	MPI_ERR_BUFFER:      "MPI_ERR_BUFFER: invalid buffer",
+	MPI_ERR_COUNT:       "MPI_ERR_COUNT: invalid count",
+	MPI_ERR_TYPE:        "MPI_ERR_TYPE: invalid datatype",
+	MPI_ERR_TAG:         "MPI_ERR_TAG: invalid tag",
+	MPI_ERR_RANK:        "MPI_ERR_RANK: invalid rank",
+	MPI_ERR_ROOT:        "MPI_ERR_ROOT: invalid root",
+	MPI_ERR_OP:          "MPI_ERR_OP: invalid reduction operation",
+	MPI_ERR_REQUEST:     "MPI_ERR_REQUEST: invalid request",
+	MPI_ERR_ARG:         "MPI_ERR_ARG: invalid argument",
+	MPI_ERR_TRUNCATE:    "MPI_ERR_TRUNCATE: message truncated",
+	MPI_ERR_TIMEOUT:     "MPI_ERR_TIMEOUT: operation timed out",
+	MPI_ERR_PROC_FAILED: "MPI_ERR_PROC_FAILED: process failed",
+	MPI_ERR_OTHER:       "MPI_ERR_OTHER: other error",
+}
+
+func (ec ErrorClass) Error() string {
+	if ec >= 0 && int(ec) < len(errorClassNames) {
+		return errorClassNames[ec]
+	}
+	return fmt.Sprintf("unknown MPI error class %d", int(ec))
+}
+
+// Error is an error returned by an MPI call, together with its class
+type Error struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the class of e
+func (e *Error) Is(target error) bool {
+	class, ok := target.(ErrorClass)
+	return ok && class == e.Class
+}
+
+// errorf returns an error of class with a formatted message
+func errorf(class ErrorClass, format string, args ...interface{}) error {
+	return &Error{Class: class, Err: fmt.Errorf(format, args...)}
+}
+
+// withClass gives err class, unless it already carries one
+func withClass(class ErrorClass, err error) error {
+	var e *Error
+	var te *TimeoutError
+	if err == nil || errors.As(err, &e) || errors.As(err, &te) {
+		return err
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// MPI_Error_class returns the class of err: MPI_SUCCESS if it is nil, and
+// MPI_ERR_OTHER if it carries no class
+func MPI_Error_class(err error) ErrorClass {
+	if err == nil {
+		return MPI_SUCCESS
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	var te *TimeoutError
+	if errors.As(err, &te) {
+		return MPI_ERR_TIMEOUT
+	}
+	return MPI_ERR_OTHER
+}
+
+// transportError gives an error from sending to a peer its class, going by
+// the gRPC status code that transports report failures with
+func transportError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return &Error{Class: MPI_ERR_PROC_FAILED, Err: err}
+	case codes.DeadlineExceeded:
+		return &Error{Class: MPI_ERR_TIMEOUT, Err: err}
+	}
+	return err
+}
+
+// TimeoutError is returned when a call stops waiting for another rank,
+// because the receive timeout or the caller's deadline passed, or because the
+// caller's context was canceled. It unwraps to context.DeadlineExceeded or
+// context.Canceled accordingly, and its class is MPI_ERR_TIMEOUT.
+type TimeoutError struct {
+	Op     string        // Call that was waiting, e.g. "MPI_Recv"
+	Rank   int           // Rank that was waiting
+	Peer   int           // Rank it was waiting for, -1 for any
+	Tag    int           // Tag it was waiting for, -1 for any
+	Waited time.Duration // How long it waited
+	Err    error         // context.DeadlineExceeded or context.Canceled
+}
+
+func (e *TimeoutError) Error() string {
+	peer, tag := "any rank", "any tag"
+	if e.Peer != -1 {
+		peer = fmt.Sprintf("rank %d", e.Peer)
+	}
+	if e.Tag != -1 {
+		tag = fmt.Sprintf("tag %d", e.Tag)
+	}
+	outcome := "timed out"
+	if errors.Is(e.Err, context.Canceled) {
+		outcome = "canceled"
+	}
+	return fmt.Sprintf("rank %d: %s waiting for %s with %s %s after %v",
+		e.Rank, e.Op, peer, tag, outcome, e.Waited.Round(time.Millisecond))
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is MPI_ERR_TIMEOUT
+func (e *TimeoutError) Is(target error) bool {
+	return target == MPI_ERR_TIMEOUT
+}
+
+// Timeout reports whether a deadline passed, as opposed to a cancellation
+func (e *TimeoutError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// waitError returns the TimeoutError for op on c, waiting since start for peer
+// and tag, that ended with cause
+func (c *Comm) waitError(op string, peer int, tag int, start time.Time, cause error) *TimeoutError {
+	return &TimeoutError{Op: op, Rank: c.rank, Peer: peer, Tag: tag, Waited: time.Since(start), Err: cause}
+}
+
+// Errhandler decides what happens when a call on a communicator fails. Every
+// communicator starts out with MPI_ERRORS_RETURN. Nonblocking and persistent
+// requests pass their errors to the handler from MPI_Wait and MPI_Test.
+type Errhandler struct {
+	name string
+	fn   func(c *Comm, err error) // nil to just return the error
+}
+
+var (
+	// MPI_ERRORS_RETURN returns errors to the caller
+	MPI_ERRORS_RETURN = &Errhandler{name: "MPI_ERRORS_RETURN"}
+	// MPI_ERRORS_ARE_FATAL logs the error and exits the process with status 1
+	MPI_ERRORS_ARE_FATAL = &Errhandler{name: "MPI_ERRORS_ARE_FATAL", fn: func(c *Comm, err error) {
+		c.logger.Error("fatal MPI error", "rank", c.rank, "class", MPI_Error_class(err).Error(), "err", err)
+		os.Exit(1)
+	}}
+)
+
+func (eh *Errhandler) String() string {
+	return eh.name
+}
+
+// MPI_Comm_create_errhandler returns an error handler that calls fn with the
+// communicator and the error of a failing call, after which the call returns
+// the error
+func MPI_Comm_create_errhandler(fn func(c *Comm, err error)) *Errhandler {
+	return &Errhandler{name: "user-defined", fn: fn}
+}
+
+// MPI_Comm_set_errhandler sets the error handler of the world communicator
+func MPI_Comm_set_errhandler(eh *Errhandler) error {
+	return world.SetErrhandler(eh)
+}
+
+// MPI_Comm_get_errhandler returns the error handler of the world communicator
+func MPI_Comm_get_errhandler() *Errhandler {
+	return world.Errhandler()
+}
+
+// SetErrhandler is MPI_Comm_set_errhandler on c
+func (c *Comm) SetErrhandler(eh *Errhandler) error {
+	if eh == nil {
+		return errorf(MPI_ERR_ARG, "error handler is nil")
+	}
+	c.errhandler.Store(eh)
+	return nil
+}
+
+// Errhandler is MPI_Comm_get_errhandler on c
+func (c *Comm) Errhandler() *Errhandler {
+	if eh := c.errhandler.Load(); eh != nil {
+		return eh
+	}
+	return MPI_ERRORS_RETURN
+}
+
+// handleError passes the error of a failing call on c, if any, to c's error
+// handler and returns it
+func (c *Comm) handleError(err error) error {
+	if err != nil {
+		if fn := c.Errhandler().fn; fn != nil {
+			fn(c, err)
+		}
+	}
+	return err
+}
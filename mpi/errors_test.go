@@ -0,0 +1,107 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestErrorClasses(t *testing.T) {
+	comms := newTestComms(t, 2, Config{RecvTimeout: 10 * time.Millisecond})
+	c := comms[0]
+	for _, tc := range []struct {
+		name  string
+		err   error
+		class ErrorClass
+	}{
+		{"send to missing rank", c.Send([]byte{1}, 5, 0), MPI_ERR_RANK},
+		{"send with reserved tag", c.Send([]byte{1}, 1, -2), MPI_ERR_TAG},
+		{"receive times out", func() error { _, err := c.Recv(1, 0); return err }(), MPI_ERR_TIMEOUT},
+		{"start non-persistent request", MPI_Start(newRequest(c, func() error { return nil })), MPI_ERR_REQUEST},
+		{"start nil request", MPI_Start(nil), MPI_ERR_REQUEST},
+		{"invalid compression", c.SetCompression(Compression(99), 0), MPI_ERR_ARG},
+	} {
+		if !errors.Is(tc.err, tc.class) {
+			t.Errorf("%s: errors.Is(%v, %v) is false", tc.name, tc.err, tc.class)
+		}
+		if got := MPI_Error_class(tc.err); got != tc.class {
+			t.Errorf("%s: class %v, want %v", tc.name, got, tc.class)
+		}
+	}
+	var te *TimeoutError
+	if _, err := c.Recv(1, 0); !errors.As(err, &te) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("receive timeout returned %v, want a *TimeoutError for a deadline", err)
+	}
+}
+
+func TestInitErrorClasses(t *testing.T) {
+	if err := MPI_InitWithOptions(WithMaxMessageSize(1)); !errors.Is(err, MPI_ERR_ARG) {
+		t.Errorf("invalid option: got %v, want MPI_ERR_ARG", err)
+	}
+	if _, err := NewComm(Config{Size: 2, Rank: 2}); !errors.Is(err, MPI_ERR_ARG) {
+		t.Errorf("rank out of range: got %v, want MPI_ERR_ARG", err)
+	}
+
+	worldMu.Lock()
+	world = newTestComms(t, 1, Config{})[0]
+	worldMu.Unlock()
+	err := MPI_InitWithOptions()
+	worldMu.Lock()
+	world = nil
+	finalized = true
+	worldMu.Unlock()
+	if !errors.Is(err, MPI_ERR_OTHER) {
+		t.Errorf("second MPI_Init: got %v, want MPI_ERR_OTHER", err)
+	}
+	err = MPI_InitWithOptions()
+	worldMu.Lock()
+	finalized = false
+	worldMu.Unlock()
+	if !errors.Is(err, MPI_ERR_OTHER) {
+		t.Errorf("MPI_Init after MPI_Finalize: got %v, want MPI_ERR_OTHER", err)
+	}
+}
+
+func TestErrorsReturn(t *testing.T) {
+	c := newTestComms(t, 1, Config{})[0]
+	if c.Errhandler() != MPI_ERRORS_RETURN {
+		t.Fatalf("default error handler is %v", c.Errhandler())
+	}
+	if err := c.Send([]byte{1}, 5, 0); err == nil {
+		t.Fatal("send to a missing rank succeeded")
+	}
+
+	var handled []error
+	eh := MPI_Comm_create_errhandler(func(_ *Comm, err error) { handled = append(handled, err) })
+	if err := c.SetErrhandler(eh); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Send([]byte{1}, 5, 0)
+	err2 := MPI_Start(newRequest(c, func() error { return nil }))
+	if len(handled) != 2 || handled[0] != err || handled[1] != err2 {
+		t.Errorf("handler saw %v, want [%v %v]", handled, err, err2)
+	}
+	if err := c.SetErrhandler(nil); !errors.Is(err, MPI_ERR_ARG) {
+		t.Errorf("setting a nil handler: got %v, want MPI_ERR_ARG", err)
+	}
+}
+
+func TestErrorsAreFatal(t *testing.T) {
+	if os.Getenv("MPI_TEST_FATAL") == "1" {
+		c := newTestComms(t, 1, Config{})[0]
+		c.SetErrhandler(MPI_ERRORS_ARE_FATAL)
+		c.Send([]byte{1}, 5, 0)
+		// Not reached; the handler exits with status 1
+		os.Exit(0)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestErrorsAreFatal$")
+	cmd.Env = append(os.Environ(), "MPI_TEST_FATAL=1")
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Fatalf("process with a fatal error handler ended with %v, want exit status 1", err)
+	}
+}
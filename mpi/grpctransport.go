@@ -0,0 +1,180 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMinConnectTimeout bounds each attempt to connect to a peer
+const grpcMinConnectTimeout = 5 * time.Second
+
+// grpcTransport sends every message as a Send RPC, or as a SendStream RPC if
+// it is larger than streamChunkSize. It also serves the standard gRPC health
+// service, which reports SERVING until the communicator is finalized.
+type grpcTransport struct {
+	comm   *Comm
+	lis    net.Listener
+	server *grpc.Server
+	health *health.Server
+}
+
+func newGRPCTransport(c *Comm, cfg Config) (Transport, error) {
+	lis, err := listen(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcTransport{comm: c, lis: lis}, nil
+}
+
+// listen returns cfg.Listener, or a new listener on the address of c's rank
+func listen(c *Comm, cfg Config) (net.Listener, error) {
+	if cfg.Listener != nil {
+		return cfg.Listener, nil
+	}
+	lis, err := net.Listen("tcp", c.addresses[c.rank])
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err)
+	}
+	return lis, nil
+}
+
+func (t *grpcTransport) Name() string {
+	return TransportGRPC
+}
+
+func (t *grpcTransport) Serve(inbox Inbox) error {
+	c := t.comm
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(c.maxMessageSize),
+		grpc.MaxSendMsgSize(c.maxMessageSize),
+		grpc.ChainUnaryInterceptor(c.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(c.streamAuthInterceptor),
+	}
+	if k := c.keepalive; k.Time > 0 {
+		opts = append(opts,
+			grpc.KeepaliveParams(keepalive.ServerParameters{Time: k.Time, Timeout: k.Timeout}),
+			// Let clients ping as often as we do
+			grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: k.Time, PermitWithoutStream: true}),
+		)
+	}
+	if c.serverTLS != nil {
+		opts = append(opts, grpc.Creds(c.serverCredentials()))
+	}
+	t.server = grpc.NewServer(opts...)
+	RegisterMPIServerServer(t.server, &grpcService{inbox: inbox})
+	t.health = health.NewServer()
+	t.health.SetServingStatus(MPIServer_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(t.server, t.health)
+	// The listener is already bound, so peers that connect before Serve
+	// starts accepting wait in the backlog instead of being refused
+	go func() {
+		if err := t.server.Serve(t.lis); err != nil {
+			c.logger.Error("gRPC server stopped", "rank", c.rank, "err", err)
+		}
+	}()
+	return nil
+}
+
+func (t *grpcTransport) Connect(dest int) (Conn, error) {
+	c := t.comm
+	transportCreds := grpc.WithInsecure()
+	if c.tlsBase != nil {
+		transportCreds = grpc.WithTransportCredentials(c.clientCredentials(dest))
+	}
+	opts := []grpc.DialOption{
+		transportCreds,
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallSendMsgSize(c.maxMessageSize),
+			grpc.MaxCallRecvMsgSize(c.maxMessageSize),
+		),
+		// Reconnect no more slowly than sends are retried, rather than with
+		// gRPC's default backoff of up to two minutes
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  c.retry.InitialBackoff,
+				Multiplier: backoff.DefaultConfig.Multiplier,
+				Jitter:     backoff.DefaultConfig.Jitter,
+				MaxDelay:   c.retry.MaxBackoff,
+			},
+			MinConnectTimeout: grpcMinConnectTimeout,
+		}),
+	}
+	if k := c.keepalive; k.Time > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: k.Time, Timeout: k.Timeout, PermitWithoutStream: true}))
+	}
+	if c.jobToken != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(jobCredentials{token: c.jobToken, rank: c.rank}))
+	}
+	conn, err := grpc.Dial(c.addresses[dest], opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcConn{conn: conn, client: NewMPIServerClient(conn), peer: dest}, nil
+}
+
+func (t *grpcTransport) Close() error {
+	if t.server != nil {
+		t.health.Shutdown()
+		t.server.GracefulStop()
+	} else {
+		t.lis.Close()
+	}
+	return nil
+}
+
+type grpcConn struct {
+	conn   *grpc.ClientConn
+	client MPIServerClient
+	peer   int
+}
+
+func (gc *grpcConn) Send(ctx context.Context, msg *Message) error {
+	var err error
+	if len(msg.Data) > streamChunkSize {
+		err = sendStream(ctx, gc.client, msg)
+	} else {
+		_, err = gc.client.Send(ctx, msg)
+	}
+	if status.Code(err) == codes.Canceled && ctx.Err() == nil {
+		// The connection was closed under the RPC, most likely because
+		// another send found it broken; it is worth retrying on a new one
+		err = status.Errorf(codes.Unavailable, "connection to rank %d closed: %s", gc.peer, status.Convert(err).Message())
+	}
+	return err
+}
+
+// openStream starts streaming msg to the peer; the caller sends the chunks
+func (gc *grpcConn) openStream(ctx context.Context, msg *Message, total int64) (MPIServer_SendStreamClient, error) {
+	return openStream(ctx, gc.client, msg, total)
+}
+
+func (gc *grpcConn) Peer() int {
+	return gc.peer
+}
+
+func (gc *grpcConn) Close() error {
+	return gc.conn.Close()
+}
+
+// grpcService is the gRPC face of an inbox
+type grpcService struct {
+	UnimplementedMPIServerServer
+	inbox Inbox
+}
+
+func (s *grpcService) Send(ctx context.Context, msg *Message) (*Empty, error) {
+	if err := s.inbox.Deliver(ctx, peerRank(ctx), msg); err != nil {
+		return nil, err
+	}
+	return &Empty{}, nil
+}
@@ -0,0 +1,361 @@
+package mpi
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBootstrapValue is the largest value a Coordinator accepts
+const maxBootstrapValue = 1 << 20
+
+// Coordinator is an HTTP service through which the ranks of a job claim
+// ranks and exchange bootstrap values, for MPI_DISCOVERY=http. Serve it where
+// every rank can reach it, for example with
+// http.ListenAndServe(":7000", mpi.NewCoordinator(4, token)), and set
+// MPI_BOOTSTRAP_URL=http://<host>:7000 and the same token as MPI_JOB_TOKEN on
+// every rank. A coordinator serves one job. Each rank joins as MPI_RANK if it
+// is set, or else as the first free rank, and publishes MPI_HOST (default:
+// the host's outbound IP) at MPI_PORT.
+//
+// Every request must carry the job token as "Authorization: Bearer <token>",
+// so that only ranks of the job can claim ranks, publish addresses or read
+// them. The token travels in the clear over plain HTTP, so serve the
+// coordinator over HTTPS on networks that can be observed.
+type Coordinator struct {
+	size  int
+	token []byte
+	mux   *http.ServeMux
+
+	mu      sync.Mutex
+	members []string            // Address that joined as each rank, "" if free
+	values  []map[string][]byte // Values put by each rank
+	fences  map[int]*coordinatorFence
+}
+
+type coordinatorFence struct {
+	arrived map[int]bool
+	done    chan struct{} // Closed when every rank has arrived
+}
+
+// coordinatorJoin is the body of a join request and its response
+type coordinatorJoin struct {
+	Rank    int    `json:"rank"` // -1 to take any free rank
+	Size    int    `json:"size,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// NewCoordinator returns a coordinator for a job of size ranks that accepts
+// requests carrying token. With an empty token it refuses every request.
+func NewCoordinator(size int, token []byte) *Coordinator {
+	c := &Coordinator{
+		size:    size,
+		token:   token,
+		mux:     http.NewServeMux(),
+		members: make([]string, size),
+		values:  make([]map[string][]byte, size),
+		fences:  make(map[int]*coordinatorFence),
+	}
+	for r := range c.values {
+		c.values[r] = make(map[string][]byte)
+	}
+	c.mux.HandleFunc("POST /join", c.handleJoin)
+	c.mux.HandleFunc("PUT /kv/{rank}/{key}", c.handlePut)
+	c.mux.HandleFunc("GET /kv/{rank}/{key}", c.handleGet)
+	c.mux.HandleFunc("POST /fence/{n}/{rank}", c.handleFence)
+	return c
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing or invalid job token", http.StatusUnauthorized)
+		return
+	}
+	c.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r carries the job token
+func (c *Coordinator) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && len(c.token) > 0 && subtle.ConstantTimeCompare([]byte(token), c.token) == 1
+}
+
+// handleJoin assigns the requested rank, or the first free one. An address
+// that joins again gets back the rank it had.
+func (c *Coordinator) handleJoin(w http.ResponseWriter, r *http.Request) {
+	var req coordinatorJoin
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Address == "" {
+		http.Error(w, "join needs a rank and an address", http.StatusBadRequest)
+		return
+	}
+	if req.Rank < -1 || req.Rank >= c.size {
+		http.Error(w, fmt.Sprintf("rank %d out of range for a job of size %d", req.Rank, c.size), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	rank := -1
+	for i, addr := range c.members {
+		if addr == req.Address {
+			rank = i
+		}
+	}
+	switch {
+	case rank >= 0 && req.Rank >= 0 && rank != req.Rank:
+		c.mu.Unlock()
+		http.Error(w, fmt.Sprintf("%s already joined as rank %d", req.Address, rank), http.StatusConflict)
+		return
+	case rank >= 0:
+	case req.Rank >= 0:
+		if c.members[req.Rank] != "" {
+			c.mu.Unlock()
+			http.Error(w, fmt.Sprintf("rank %d already joined", req.Rank), http.StatusConflict)
+			return
+		}
+		rank = req.Rank
+	default:
+		for i, addr := range c.members {
+			if addr == "" {
+				rank = i
+				break
+			}
+		}
+		if rank < 0 {
+			c.mu.Unlock()
+			http.Error(w, fmt.Sprintf("all %d ranks already joined", c.size), http.StatusConflict)
+			return
+		}
+	}
+	c.members[rank] = req.Address
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coordinatorJoin{Rank: rank, Size: c.size})
+}
+
+func (c *Coordinator) handlePut(w http.ResponseWriter, r *http.Request) {
+	rank, ok := c.rankParam(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	if err := checkBootstrapKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBootstrapValue))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	c.mu.Lock()
+	c.values[rank][key] = value
+	c.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleGet(w http.ResponseWriter, r *http.Request) {
+	rank, ok := c.rankParam(w, r)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	value, ok := c.values[rank][r.PathValue("key")]
+	c.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// handleFence records that a rank reached fence n and responds once all
+// ranks have
+func (c *Coordinator) handleFence(w http.ResponseWriter, r *http.Request) {
+	rank, ok := c.rankParam(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 {
+		http.Error(w, "invalid fence number", http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	f := c.fences[n]
+	if f == nil {
+		f = &coordinatorFence{arrived: make(map[int]bool), done: make(chan struct{})}
+		c.fences[n] = f
+	}
+	if !f.arrived[rank] {
+		f.arrived[rank] = true
+		if len(f.arrived) == c.size {
+			close(f.done)
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		w.WriteHeader(http.StatusNoContent)
+	case <-r.Context().Done():
+	}
+}
+
+func (c *Coordinator) rankParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	rank, err := strconv.Atoi(r.PathValue("rank"))
+	if err != nil || rank < 0 || rank >= c.size {
+		http.Error(w, fmt.Sprintf("invalid rank %q", r.PathValue("rank")), http.StatusBadRequest)
+		return 0, false
+	}
+	return rank, true
+}
+
+// httpBootstrap is the client of a Coordinator
+type httpBootstrap struct {
+	url    string
+	token  []byte
+	client *http.Client
+	rank   int
+	size   int
+	fences int
+}
+
+// newHTTPBootstrap joins the Coordinator at MPI_BOOTSTRAP_URL as MPI_RANK, or
+// as any free rank if it is not set, publishes its address (MPI_HOST at
+// MPI_PORT) and waits for the other ranks to do the same. It authenticates
+// with the job token, which must be set.
+func newHTTPBootstrap(ctx context.Context, _ BootstrapConfig) (Bootstrap, error) {
+	url := os.Getenv("MPI_BOOTSTRAP_URL")
+	if url == "" {
+		return nil, errors.New("MPI_BOOTSTRAP_URL not set")
+	}
+	token, err := loadJobToken()
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, errors.New("MPI_DISCOVERY=http needs the job token in MPI_JOB_TOKEN or MPI_JOB_TOKEN_FILE")
+	}
+	req := coordinatorJoin{Rank: -1}
+	if s := os.Getenv("MPI_RANK"); s != "" {
+		var err error
+		req.Rank, err = strconv.Atoi(s)
+		if err != nil || req.Rank < 0 {
+			return nil, fmt.Errorf("MPI_RANK invalid: %q", s)
+		}
+	}
+	host, err := advertisedHost()
+	if err != nil {
+		return nil, err
+	}
+	port, err := portFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	req.Address = net.JoinHostPort(host, strconv.Itoa(port))
+
+	b := &httpBootstrap{url: strings.TrimSuffix(url, "/"), token: token, client: http.DefaultClient}
+	body, _ := json.Marshal(req)
+	resp, err := b.do(ctx, http.MethodPost, "/join", body)
+	if err != nil {
+		return nil, fmt.Errorf("error joining coordinator %s: %v", url, err)
+	}
+	var joined coordinatorJoin
+	if err := json.Unmarshal(resp, &joined); err != nil {
+		return nil, fmt.Errorf("invalid response from coordinator %s: %v", url, err)
+	}
+	b.rank, b.size = joined.Rank, joined.Size
+
+	if err := b.Put(ctx, BootstrapAddressKey, []byte(req.Address)); err != nil {
+		return nil, err
+	}
+	if err := b.Fence(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *httpBootstrap) Rank() int { return b.rank }
+func (b *httpBootstrap) Size() int { return b.size }
+
+func (b *httpBootstrap) Put(ctx context.Context, key string, value []byte) error {
+	if err := checkBootstrapKey(key); err != nil {
+		return err
+	}
+	if _, err := b.do(ctx, http.MethodPut, b.key(b.rank, key), value); err != nil {
+		return fmt.Errorf("error putting %s: %v", key, err)
+	}
+	return nil
+}
+
+func (b *httpBootstrap) Get(ctx context.Context, rank int, key string) ([]byte, error) {
+	if rank < 0 || rank >= b.size {
+		return nil, fmt.Errorf("rank %d out of range for a job of size %d", rank, b.size)
+	}
+	if err := checkBootstrapKey(key); err != nil {
+		return nil, err
+	}
+	value, err := b.do(ctx, http.MethodGet, b.key(rank, key), nil)
+	if errors.Is(err, ErrBootstrapKeyNotFound) {
+		return nil, fmt.Errorf("%w: %s of rank %d", ErrBootstrapKeyNotFound, key, rank)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s of rank %d: %v", key, rank, err)
+	}
+	return value, nil
+}
+
+func (b *httpBootstrap) Fence(ctx context.Context) error {
+	path := "/fence/" + strconv.Itoa(b.fences) + "/" + strconv.Itoa(b.rank)
+	if _, err := b.do(ctx, http.MethodPost, path, nil); err != nil {
+		return fmt.Errorf("error waiting at fence %d: %v", b.fences, err)
+	}
+	b.fences++
+	return nil
+}
+
+func (b *httpBootstrap) Close() error { return nil }
+
+func (b *httpBootstrap) key(rank int, key string) string {
+	return "/kv/" + strconv.Itoa(rank) + "/" + key
+}
+
+// do sends a request to the coordinator and returns the response body. A
+// 404 response is reported as ErrBootstrapKeyNotFound.
+func (b *httpBootstrap) do(ctx context.Context, method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(b.token))
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrBootstrapKeyNotFound
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,93 @@
+package mpi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// coordinatorRequest sends a request to srv with the given bearer token, if
+// any, and returns the response status
+func coordinatorRequest(t *testing.T, srv *httptest.Server, method, path, body, token string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestCoordinatorRequiresToken(t *testing.T) {
+	srv := httptest.NewServer(NewCoordinator(2, []byte("secret")))
+	defer srv.Close()
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/join", `{"rank":0,"address":"10.0.0.1:5000"}`},
+		{http.MethodPut, "/kv/0/address", "10.0.0.1:5000"},
+		{http.MethodGet, "/kv/0/address", ""},
+		{http.MethodPost, "/fence/0/0", ""},
+	} {
+		for _, token := range []string{"", "wrong"} {
+			if code := coordinatorRequest(t, srv, tc.method, tc.path, tc.body, token); code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: status %d, want %d", tc.method, tc.path, token, code, http.StatusUnauthorized)
+			}
+		}
+	}
+	if code := coordinatorRequest(t, srv, http.MethodPost, "/join", `{"rank":0,"address":"10.0.0.1:5000"}`, "secret"); code != http.StatusOK {
+		t.Errorf("join with the job token: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestCoordinatorWithoutTokenRefusesAll(t *testing.T) {
+	srv := httptest.NewServer(NewCoordinator(1, nil))
+	defer srv.Close()
+	if code := coordinatorRequest(t, srv, http.MethodPost, "/join", `{"rank":0,"address":"10.0.0.1:5000"}`, "x"); code != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestCoordinatorRejectsNegativeRank(t *testing.T) {
+	srv := httptest.NewServer(NewCoordinator(2, []byte("secret")))
+	defer srv.Close()
+	if code := coordinatorRequest(t, srv, http.MethodPost, "/join", `{"rank":-2,"address":"10.0.0.1:5000"}`, "secret"); code != http.StatusBadRequest {
+		t.Errorf("join as rank -2: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestHTTPBootstrap(t *testing.T) {
+	srv := httptest.NewServer(NewCoordinator(1, []byte("secret")))
+	defer srv.Close()
+	t.Setenv("MPI_BOOTSTRAP_URL", srv.URL)
+	t.Setenv("MPI_HOST", "127.0.0.1")
+	t.Setenv("MPI_PORT", "5000")
+
+	t.Setenv("MPI_JOB_TOKEN", "")
+	if _, err := newHTTPBootstrap(context.Background(), BootstrapConfig{}); err == nil {
+		t.Fatal("bootstrap without a job token succeeded")
+	}
+
+	t.Setenv("MPI_JOB_TOKEN", "secret")
+	b, err := newHTTPBootstrap(context.Background(), BootstrapConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	addr, err := b.Get(context.Background(), 0, BootstrapAddressKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(addr) != "127.0.0.1:5000" {
+		t.Errorf("published address %q, want 127.0.0.1:5000", addr)
+	}
+}
@@ -0,0 +1,138 @@
+package mpi
+
+import "context"
+
+// Nonblocking collectives each use a private tag so that several can be in
+// flight at once without their messages being mixed up. Every process must
+// start collectives in the same order, so the sequence numbers agree. The
+// tags are negative, so user sends and receives can never use or match them.
+//
+// Unlike the blocking collectives, nonblocking ones are not bound by the
+// receive timeout; only the context given to the Ctx forms ends them early.
+const (
+	tagNonblockingBase  = 1 << 20
+	tagNonblockingRange = 1 << 20
+)
+
+func (c *Comm) nextNonblockingTag() int {
+	c.nbcMu.Lock()
+	defer c.nbcMu.Unlock()
+	tag := -(tagNonblockingBase + c.nbcSeq%tagNonblockingRange)
+	c.nbcSeq++
+	return tag
+}
+
+// noRecvTimeoutKey marks a context whose receives wait until it ends, however
+// long the receive timeout of the communicator is
+type noRecvTimeoutKey struct{}
+
+// withoutRecvTimeout returns ctx with the receive timeout lifted. Nonblocking
+// collectives use it, since they may legitimately stay in flight for longer
+// than any one receive should wait, e.g. during long computation.
+func withoutRecvTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRecvTimeoutKey{}, true)
+}
+
+// MPI_Ibcast starts a broadcast from root and returns immediately. data must
+// not be used until the request completes.
+func MPI_Ibcast(data interface{}, count int, root int) *Request {
+	return world.Ibcast(data, count, root)
+}
+
+// MPI_IbcastCtx is MPI_Ibcast whose request fails with a *TimeoutError if ctx
+// ends before the broadcast completes
+func MPI_IbcastCtx(ctx context.Context, data interface{}, count int, root int) *Request {
+	return world.IbcastCtx(ctx, data, count, root)
+}
+
+// MPI_Ireduce starts a reduction to root and returns immediately. recvData
+// must not be used until the request completes.
+func MPI_Ireduce(sendData interface{}, recvData interface{}, op ReductionOp, root int) *Request {
+	return world.Ireduce(sendData, recvData, op, root)
+}
+
+// MPI_IreduceCtx is MPI_Ireduce whose request fails with a *TimeoutError if
+// ctx ends before the reduction completes
+func MPI_IreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp, root int) *Request {
+	return world.IreduceCtx(ctx, sendData, recvData, op, root)
+}
+
+// MPI_Iallreduce starts a reduction whose result is left on every process and
+// returns immediately. recvData must not be used until the request completes.
+func MPI_Iallreduce(sendData interface{}, recvData interface{}, op ReductionOp) *Request {
+	return world.Iallreduce(sendData, recvData, op)
+}
+
+// MPI_IallreduceCtx is MPI_Iallreduce whose request fails with a
+// *TimeoutError if ctx ends before the reduction completes
+func MPI_IallreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp) *Request {
+	return world.IallreduceCtx(ctx, sendData, recvData, op)
+}
+
+// MPI_Ibarrier starts a barrier and returns immediately. The request
+// completes once every process has entered the barrier.
+func MPI_Ibarrier() *Request {
+	return world.Ibarrier()
+}
+
+// MPI_IbarrierCtx is MPI_Ibarrier whose request fails with a *TimeoutError if
+// ctx ends before every process has entered the barrier
+func MPI_IbarrierCtx(ctx context.Context) *Request {
+	return world.IbarrierCtx(ctx)
+}
+
+// Ibcast is MPI_Ibcast on c
+func (c *Comm) Ibcast(data interface{}, count int, root int) *Request {
+	return c.IbcastCtx(context.Background(), data, count, root)
+}
+
+// IbcastCtx is MPI_IbcastCtx on c
+func (c *Comm) IbcastCtx(ctx context.Context, data interface{}, count int, root int) *Request {
+	tag := c.nextNonblockingTag()
+	ctx = withoutRecvTimeout(ctx)
+	return newRequest(c, func() error {
+		return c.bcast(ctx, "MPI_Ibcast", data, root, tag)
+	})
+}
+
+// Ireduce is MPI_Ireduce on c
+func (c *Comm) Ireduce(sendData interface{}, recvData interface{}, op ReductionOp, root int) *Request {
+	return c.IreduceCtx(context.Background(), sendData, recvData, op, root)
+}
+
+// IreduceCtx is MPI_IreduceCtx on c
+func (c *Comm) IreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp, root int) *Request {
+	tag := c.nextNonblockingTag()
+	ctx = withoutRecvTimeout(ctx)
+	return newRequest(c, func() error {
+		return c.reduce(ctx, "MPI_Ireduce", sendData, recvData, op, root, tag)
+	})
+}
+
+// Iallreduce is MPI_Iallreduce on c
+func (c *Comm) Iallreduce(sendData interface{}, recvData interface{}, op ReductionOp) *Request {
+	return c.IallreduceCtx(context.Background(), sendData, recvData, op)
+}
+
+// IallreduceCtx is MPI_IallreduceCtx on c
+func (c *Comm) IallreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp) *Request {
+	tag := c.nextNonblockingTag()
+	ctx = withoutRecvTimeout(ctx)
+	return newRequest(c, func() error {
+		return c.allreduce(ctx, "MPI_Iallreduce", sendData, recvData, op, tag)
+	})
+}
+
+// Ibarrier is MPI_Ibarrier on c
+func (c *Comm) Ibarrier() *Request {
+	return c.IbarrierCtx(context.Background())
+}
+
+// IbarrierCtx is MPI_IbarrierCtx on c
+func (c *Comm) IbarrierCtx(ctx context.Context) *Request {
+	tag := c.nextNonblockingTag()
+	ctx = withoutRecvTimeout(ctx)
+	return newRequest(c, func() error {
+		return c.barrier(ctx, "MPI_Ibarrier", tag)
+	})
+}
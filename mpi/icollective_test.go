@@ -0,0 +1,76 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNonblockingCollectiveOutlivesRecvTimeout(t *testing.T) {
+	comms := newTestComms(t, 3, Config{RecvTimeout: 50 * time.Millisecond})
+	var wg sync.WaitGroup
+	errs := make([]error, len(comms))
+	for r, c := range comms {
+		wg.Add(1)
+		go func(r int, c *Comm) {
+			defer wg.Done()
+			if r == 2 {
+				// Long computation before entering the barrier
+				time.Sleep(300 * time.Millisecond)
+			}
+			errs[r] = MPI_Wait(c.Ibarrier())
+		}(r, c)
+	}
+	wg.Wait()
+	for r, err := range errs {
+		if err != nil {
+			t.Errorf("rank %d: %v", r, err)
+		}
+	}
+}
+
+func TestNonblockingCollectiveHonorsContext(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := MPI_Wait(comms[0].IbarrierCtx(ctx))
+	var te *TimeoutError
+	if !errors.As(err, &te) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if te.Tag >= 0 {
+		t.Errorf("nonblocking collective used user tag %d", te.Tag)
+	}
+}
+
+func TestAnyTagDoesNotMatchNonblockingCollective(t *testing.T) {
+	comms := newTestComms(t, 2, Config{RecvTimeout: 100 * time.Millisecond})
+	data := []float64{1, 2, 3}
+	req := comms[0].Ibcast(data, len(data), 0)
+	if err := MPI_Wait(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comms[1].Recv(-1, -1); !errors.Is(err, MPI_ERR_TIMEOUT) {
+		t.Fatalf("receive with any tag: got %v, want MPI_ERR_TIMEOUT", err)
+	}
+	got := make([]float64, 3)
+	if err := MPI_Wait(comms[1].Ibcast(got, len(got), 0)); err != nil {
+		t.Fatal(err)
+	}
+	if got[2] != 3 {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestUserTagsExcludeInternalTags(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	tag := -tagNonblockingBase
+	if err := comms[0].Send(nil, 1, tag); !errors.Is(err, MPI_ERR_TAG) {
+		t.Errorf("send with tag %d: got %v, want MPI_ERR_TAG", tag, err)
+	}
+	if _, err := comms[1].Recv(0, tag); !errors.Is(err, MPI_ERR_TAG) {
+		t.Errorf("receive with tag %d: got %v, want MPI_ERR_TAG", tag, err)
+	}
+}
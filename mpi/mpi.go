@@ -1,95 +1,334 @@
 package mpi
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
 	"net"
-	"os"
-	"strconv"
-
-	"google.golang.org/grpc"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Comm is a communicator: one rank's view of a job and everything it needs to
+// talk to the other ranks. MPI_Init creates the world communicator that the
+// MPI_* functions use; NewComm creates independent ones, for example to run
+// several ranks in one process.
+type Comm struct {
+	rank      int
+	size      int
+	addresses map[int]string
+	bootstrap Bootstrap
+
+	server    *server // Receive queue
+	transport Transport
+	connsMu   sync.Mutex
+	conns     map[int]Conn
+	sendSeq   []atomic.Uint64 // Sequence number of the last message to each rank
+	retry     RetryConfig
+
+	errhandler atomic.Pointer[Errhandler] // nil means MPI_ERRORS_RETURN
+
+	codec                atomic.Pointer[Codec] // nil means the package default
+	compressionMu        sync.RWMutex
+	compression          Compression
+	compressionThreshold int
+
+	serverTLS *tls.Config
+	tlsBase   *tls.Config // Shared settings that client configurations are derived from
+	jobToken  []byte      // Per-job shared secret, nil if authentication is off
+
+	maxMessageSize      int
+	maxDecompressedSize int
+	recvTimeout         time.Duration
+	keepalive           KeepaliveConfig
+	logger              *slog.Logger
+
+	bsend  bsendState
+	nbcMu  sync.Mutex
+	nbcSeq int
+	stats  commStats
+}
+
+// Config describes the rank a communicator represents and how it reaches
+// the rest of the job
+type Config struct {
+	Rank      int
+	Size      int
+	Addresses map[int]string // host:port of every rank
+	// Bootstrap, if set, is the one the layout came from. It stays available
+	// through Comm.Bootstrap, and is closed when the communicator is.
+	Bootstrap Bootstrap
+
+	// Transport names the transport to use, TransportGRPC if empty
+	Transport string
+	// Listener, if set, is used by network transports instead of listening
+	// on Addresses[Rank]
+	Listener net.Listener
+	// Network connects the ranks of an in-process job for TransportMemory
+	Network *MemoryNetwork
+	// DisableSharedMemory keeps traffic between ranks on the same host on
+	// the network transport. Otherwise, on Linux, it goes through a ring
+	// buffer in a file mapped from SharedMemoryDir, falling back to the
+	// network if that cannot be set up.
+	DisableSharedMemory bool
+	// SharedMemoryDir is where shared memory segments are created,
+	// DefaultSharedMemoryDir if empty
+	SharedMemoryDir string
+
+	Codec                Codec       // nil selects the package default
+	Compression          Compression // Compression_NONE disables compression
+	CompressionThreshold int         // Smallest payload that is compressed
+	// MaxDecompressedSize limits the size a compressed payload may declare
+	// and expand to, DefaultMaxDecompressedSize if zero. Larger payloads are
+	// sent uncompressed.
+	MaxDecompressedSize int
+
+	TLS TLSConfig
+	// JobToken is a secret shared by the ranks of the job, nil to disable
+	// authentication. Every RPC then carries the sender's rank and an HMAC
+	// of it, and messages whose Source is not the authenticated sender are
+	// rejected. Under mutual TLS the rank must match the certificate. The
+	// proof is a bearer credential, so use TLS as well on networks that can
+	// be observed.
+	JobToken []byte
+
+	// MaxMessageSize limits a single gRPC message, DefaultMaxMessageSize if
+	// zero. Payloads larger than a stream chunk are streamed regardless over
+	// gRPC, but the tcp transport and shared memory, which do not stream,
+	// refuse messages larger than this.
+	MaxMessageSize int
+	// RecvTimeout is how long a receive waits for a matching message,
+	// DefaultRecvTimeout if zero. A negative timeout waits forever.
+	RecvTimeout time.Duration
+	// Keepalive sets gRPC keepalive pings, see KeepaliveConfig
+	Keepalive KeepaliveConfig
+	// Retry sets how sends that fail with transient network errors are
+	// retried, see RetryConfig
+	Retry RetryConfig
+	// Logger receives diagnostics, slog.Default() if nil
+	Logger *slog.Logger
+}
+
+// world is the communicator set up by MPI_Init
+var world *Comm
+
 var (
-	rank              int
-	size              int
-	mpiServer         *grpc.Server
-	clients           map[int]MPIServerClient
-	addresses         map[int]string
-	mpiServerInstance *server
+	worldMu   sync.Mutex // Serializes MPI_Init and MPI_Finalize
+	finalized bool
 )
 
-// MPI_Init initializes the MPI environment
-func MPI_Init() {
-	var err error
-	rank, err = strconv.Atoi(os.Getenv("MPI_RANK"))
+// MPI_Init initializes the MPI environment from the environment variables
+// and the config file named by MPI_CONFIG. On failure nothing is left
+// running, so it can be retried.
+func MPI_Init() error {
+	return MPI_InitWithOptions()
+}
+
+// MPI_InitWithOptions initializes the MPI environment with settings from, in
+// increasing precedence, the defaults, the job manifest, the config file, opts
+// and the environment variables MPI_TRANSPORT, MPI_SHM, MPI_SHM_DIR,
+// MPI_MAX_MESSAGE_SIZE, MPI_RECV_TIMEOUT, MPI_READY_TIMEOUT,
+// MPI_KEEPALIVE_TIME, MPI_KEEPALIVE_TIMEOUT, MPI_RETRY_MAX_ATTEMPTS,
+// MPI_RETRY_INITIAL_BACKOFF, MPI_RETRY_MAX_BACKOFF, MPI_CODEC,
+// MPI_COMPRESSION, MPI_COMPRESSION_THRESHOLD, MPI_MAX_DECOMPRESSED_SIZE,
+// MPI_LOG_LEVEL, MPI_LOG_FORMAT,
+// MPI_DISCOVERY, MPI_DISCOVERY_TIMEOUT and MPI_DISCOVERY_POLL_INTERVAL.
+//
+// It returns once every rank is serving and reachable; see WaitReady. If that
+// takes longer than the ready timeout, DefaultReadyTimeout unless set, it
+// fails with an error naming the missing ranks. On failure nothing is left
+// running, so it can be retried.
+func MPI_InitWithOptions(opts ...Option) error {
+	worldMu.Lock()
+	defer worldMu.Unlock()
+	if finalized {
+		return errorf(MPI_ERR_OTHER, "MPI cannot be initialized again after MPI_Finalize")
+	}
+	if world != nil {
+		return errorf(MPI_ERR_OTHER, "MPI is already initialized")
+	}
+	ic, err := configFromOptions(opts)
 	if err != nil {
-		log.Fatalf("MPI_RANK not set or invalid: %v", err)
+		return err
 	}
-	size, err = strconv.Atoi(os.Getenv("MPI_SIZE"))
+	cfg := ic.Config
+	c, err := NewComm(cfg)
 	if err != nil {
-		log.Fatalf("MPI_SIZE not set or invalid: %v", err)
+		if cfg.Bootstrap != nil {
+			cfg.Bootstrap.Close()
+		}
+		return withClass(MPI_ERR_OTHER, err)
 	}
-
-	// Initialize clients and addresses
-	clients = make(map[int]MPIServerClient)
-	addresses = make(map[int]string)
-	for i := 0; i < size; i++ {
-		addr := os.Getenv("MPI_ADDRESS_" + strconv.Itoa(i))
-		if addr == "" {
-			log.Fatalf("MPI_ADDRESS_%d not set", i)
+	if timeout := ic.readyTimeout; timeout >= 0 {
+		if timeout == 0 {
+			timeout = DefaultReadyTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.WaitReady(ctx)
+		cancel()
+		if err != nil {
+			c.Finalize()
+			return fmt.Errorf("startup handshake did not complete within %v: %w", timeout, err)
 		}
-		addresses[i] = addr
 	}
+	world = c
+	return nil
+}
 
-	// Start the gRPC server
-	mpiServerInstance = &server{
-		messages: make(map[int32][]*Message),
-	}
-	go startServer()
+// MPI_Initialized reports whether MPI_Init has succeeded
+func MPI_Initialized() bool {
+	worldMu.Lock()
+	defer worldMu.Unlock()
+	return world != nil
+}
+
+// MPI_Finalized reports whether MPI_Finalize has been called
+func MPI_Finalized() bool {
+	worldMu.Lock()
+	defer worldMu.Unlock()
+	return finalized
 }
 
+// MPI_Finalize shuts down the environment set up by MPI_Init
 func MPI_Finalize() {
-	if mpiServer != nil {
-		mpiServer.GracefulStop()
+	worldMu.Lock()
+	defer worldMu.Unlock()
+	if world != nil {
+		world.Finalize()
+		finalized = true
 	}
 }
 
-func startServer() {
-	lis, err := net.Listen("tcp", addresses[rank])
-	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+// NewComm sets up a communicator for cfg.Rank and starts serving requests
+// from the other ranks
+func NewComm(cfg Config) (*Comm, error) {
+	if cfg.Size <= 0 {
+		return nil, errorf(MPI_ERR_ARG, "invalid job size %d", cfg.Size)
 	}
-	// Increase the maximum message size
-	var maxMsgSize = 1024 * 1024 * 50 // 50 MiB, adjust as needed
+	if cfg.Rank < 0 || cfg.Rank >= cfg.Size {
+		return nil, errorf(MPI_ERR_ARG, "rank %d out of range for a job of size %d", cfg.Rank, cfg.Size)
+	}
+	for i := 0; i < cfg.Size; i++ {
+		if cfg.Addresses[i] == "" {
+			return nil, errorf(MPI_ERR_ARG, "no address for rank %d", i)
+		}
+	}
+
+	c := &Comm{
+		rank:      cfg.Rank,
+		size:      cfg.Size,
+		addresses: cfg.Addresses,
+		bootstrap: cfg.Bootstrap,
+		conns:     make(map[int]Conn),
+		sendSeq:   make([]atomic.Uint64, cfg.Size),
+		retry:     cfg.Retry.withDefaults(),
+
+		maxMessageSize:      cfg.MaxMessageSize,
+		maxDecompressedSize: cfg.MaxDecompressedSize,
+		recvTimeout:         cfg.RecvTimeout,
+		keepalive:           cfg.Keepalive,
+		logger:              cfg.Logger,
+	}
+	c.SetCodec(cfg.Codec)
+	if c.maxMessageSize == 0 {
+		c.maxMessageSize = DefaultMaxMessageSize
+	}
+	if c.maxDecompressedSize == 0 {
+		c.maxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	if c.recvTimeout == 0 {
+		c.recvTimeout = DefaultRecvTimeout
+	}
+	if c.keepalive.Time == 0 {
+		c.keepalive.Time = DefaultKeepaliveTime
+	}
+	if c.keepalive.Timeout == 0 {
+		c.keepalive.Timeout = DefaultKeepaliveTimeout
+	}
+	if c.logger == nil {
+		c.logger = slog.Default()
+	}
+	c.bsend.init()
+	if err := c.SetCompression(cfg.Compression, cfg.CompressionThreshold); err != nil {
+		return nil, err
+	}
+	if err := c.setupTLS(cfg.TLS); err != nil {
+		return nil, errorf(MPI_ERR_ARG, "TLS setup failed: %v", err)
+	}
+	c.jobToken = cfg.JobToken
 
-	mpiServer = grpc.NewServer(
-		grpc.MaxRecvMsgSize(maxMsgSize),
-		grpc.MaxSendMsgSize(maxMsgSize),
-	)
-	RegisterMPIServerServer(mpiServer, mpiServerInstance)
-	if err := mpiServer.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve: %v", err)
+	c.server = &server{
+		comm:     c,
+		messages: make(map[int32][]*Message),
+		matched:  make(map[*Message]chan struct{}),
+		streams:  make(map[*Message]*incomingStream),
+		arrived:  make(chan struct{}),
+		ready:    make(map[int]bool),
+		seqs:     make(map[int32]*seqTracker),
 	}
+	var err error
+	c.transport, err = newTransport(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.DisableSharedMemory && cfg.Transport != TransportMemory {
+		c.transport = newShmTransport(c, c.transport, cfg.SharedMemoryDir)
+	}
+	if err := c.transport.Serve(c.server); err != nil {
+		c.transport.Close()
+		return nil, err
+	}
+	c.logger.Debug("communicator started", "rank", c.rank, "size", c.size, "transport", c.transport.Name())
+	return c, nil
 }
 
-func getClient(dest int) (MPIServerClient, error) {
-	if client, ok := clients[dest]; ok {
-		return client, nil
+// Finalize stops serving requests and closes the connections to other ranks
+func (c *Comm) Finalize() {
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.conns = make(map[int]Conn)
+	c.transport.Close()
+	if c.bootstrap != nil {
+		c.bootstrap.Close()
 	}
-	var maxMsgSize = 1024 * 1024 * 50 // 50 MiB
+}
 
-	conn, err := grpc.Dial(
-		addresses[dest],
-		grpc.WithInsecure(),
-		grpc.WithDefaultCallOptions(
-			grpc.MaxCallSendMsgSize(maxMsgSize),
-			grpc.MaxCallRecvMsgSize(maxMsgSize),
-		),
-	)
+// Bootstrap returns the bootstrap c was set up from, or nil
+func (c *Comm) Bootstrap() Bootstrap {
+	return c.bootstrap
+}
+
+// Transport returns the name of the transport c uses
+func (c *Comm) Transport() string {
+	return c.transport.Name()
+}
+
+func (c *Comm) getConn(dest int) (Conn, error) {
+	c.connsMu.Lock()
+	defer c.connsMu.Unlock()
+	if conn, ok := c.conns[dest]; ok {
+		return conn, nil
+	}
+	conn, err := c.transport.Connect(dest)
 	if err != nil {
 		return nil, err
 	}
-	client := NewMPIServerClient(conn)
-	clients[dest] = client
-	return client, nil
+	c.conns[dest] = conn
+	return conn, nil
+}
+
+// dropConn forgets conn as the connection to dest and closes it, so the next
+// getConn dials again
+func (c *Comm) dropConn(dest int, conn Conn) {
+	c.connsMu.Lock()
+	if c.conns[dest] == conn {
+		delete(c.conns, dest)
+	}
+	c.connsMu.Unlock()
+	conn.Close()
 }
@@ -20,15 +20,120 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type SendMode int32
+
+const (
+	SendMode_STANDARD    SendMode = 0
+	SendMode_SYNCHRONOUS SendMode = 1 // Send completes once the receiver has matched the message
+	SendMode_READY       SendMode = 2 // Send fails unless a matching receive is already posted
+)
+
+// Enum value maps for SendMode.
+var (
+	SendMode_name = map[int32]string{
+		0: "STANDARD",
+		1: "SYNCHRONOUS",
+		2: "READY",
+	}
+	SendMode_value = map[string]int32{
+		"STANDARD":    0,
+		"SYNCHRONOUS": 1,
+		"READY":       2,
+	}
+)
+
+func (x SendMode) Enum() *SendMode {
+	p := new(SendMode)
+	*p = x
+	return p
+}
+
+func (x SendMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SendMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_mpi_proto_enumTypes[0].Descriptor()
+}
+
+func (SendMode) Type() protoreflect.EnumType {
+	return &file_mpi_proto_enumTypes[0]
+}
+
+func (x SendMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SendMode.Descriptor instead.
+func (SendMode) EnumDescriptor() ([]byte, []int) {
+	return file_mpi_proto_rawDescGZIP(), []int{0}
+}
+
+type Compression int32
+
+const (
+	Compression_NONE   Compression = 0
+	Compression_GZIP   Compression = 1
+	Compression_SNAPPY Compression = 2
+	Compression_ZSTD   Compression = 3
+)
+
+// Enum value maps for Compression.
+var (
+	Compression_name = map[int32]string{
+		0: "NONE",
+		1: "GZIP",
+		2: "SNAPPY",
+		3: "ZSTD",
+	}
+	Compression_value = map[string]int32{
+		"NONE":   0,
+		"GZIP":   1,
+		"SNAPPY": 2,
+		"ZSTD":   3,
+	}
+)
+
+func (x Compression) Enum() *Compression {
+	p := new(Compression)
+	*p = x
+	return p
+}
+
+func (x Compression) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Compression) Descriptor() protoreflect.EnumDescriptor {
+	return file_mpi_proto_enumTypes[1].Descriptor()
+}
+
+func (Compression) Type() protoreflect.EnumType {
+	return &file_mpi_proto_enumTypes[1]
+}
+
+func (x Compression) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Compression.Descriptor instead.
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return file_mpi_proto_rawDescGZIP(), []int{1}
+}
+
 type Message struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Source int32  `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
-	Dest   int32  `protobuf:"varint,2,opt,name=dest,proto3" json:"dest,omitempty"`
-	Tag    int32  `protobuf:"varint,3,opt,name=tag,proto3" json:"tag,omitempty"`
-	Data   []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Source           int32       `protobuf:"varint,1,opt,name=source,proto3" json:"source,omitempty"`
+	Dest             int32       `protobuf:"varint,2,opt,name=dest,proto3" json:"dest,omitempty"`
+	Tag              int32       `protobuf:"varint,3,opt,name=tag,proto3" json:"tag,omitempty"`
+	Data             []byte      `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Mode             SendMode    `protobuf:"varint,5,opt,name=mode,proto3,enum=mpi.SendMode" json:"mode,omitempty"`
+	Compression      Compression `protobuf:"varint,6,opt,name=compression,proto3,enum=mpi.Compression" json:"compression,omitempty"`              // Algorithm data is compressed with, if any
+	UncompressedSize int64       `protobuf:"varint,7,opt,name=uncompressed_size,json=uncompressedSize,proto3" json:"uncompressed_size,omitempty"` // Size of data before compression
+	Seq              uint64      `protobuf:"varint,8,opt,name=seq,proto3" json:"seq,omitempty"`                                                   // Per-destination sequence number for deduplicating retries, 0 if unset
 }
 
 func (x *Message) Reset() {
@@ -89,6 +194,97 @@ func (x *Message) GetData() []byte {
 	return nil
 }
 
+func (x *Message) GetMode() SendMode {
+	if x != nil {
+		return x.Mode
+	}
+	return SendMode_STANDARD
+}
+
+func (x *Message) GetCompression() Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return Compression_NONE
+}
+
+func (x *Message) GetUncompressedSize() int64 {
+	if x != nil {
+		return x.UncompressedSize
+	}
+	return 0
+}
+
+func (x *Message) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+// Chunk is one fragment of a message streamed with SendStream. The first
+// chunk carries the envelope and total size; the rest carry only data.
+type Chunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Header    *Message `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`                         // Envelope without data, first chunk only
+	TotalSize int64    `protobuf:"varint,2,opt,name=total_size,json=totalSize,proto3" json:"total_size,omitempty"` // Payload size in bytes, first chunk only
+	Data      []byte   `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *Chunk) Reset() {
+	*x = Chunk{}
+	mi := &file_mpi_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Chunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Chunk) ProtoMessage() {}
+
+func (x *Chunk) ProtoReflect() protoreflect.Message {
+	mi := &file_mpi_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Chunk.ProtoReflect.Descriptor instead.
+func (*Chunk) Descriptor() ([]byte, []int) {
+	return file_mpi_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Chunk) GetHeader() *Message {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *Chunk) GetTotalSize() int64 {
+	if x != nil {
+		return x.TotalSize
+	}
+	return 0
+}
+
+func (x *Chunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 type RecvRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -100,7 +296,7 @@ type RecvRequest struct {
 
 func (x *RecvRequest) Reset() {
 	*x = RecvRequest{}
-	mi := &file_mpi_proto_msgTypes[1]
+	mi := &file_mpi_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -112,7 +308,7 @@ func (x *RecvRequest) String() string {
 func (*RecvRequest) ProtoMessage() {}
 
 func (x *RecvRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_mpi_proto_msgTypes[1]
+	mi := &file_mpi_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -125,7 +321,7 @@ func (x *RecvRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RecvRequest.ProtoReflect.Descriptor instead.
 func (*RecvRequest) Descriptor() ([]byte, []int) {
-	return file_mpi_proto_rawDescGZIP(), []int{1}
+	return file_mpi_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *RecvRequest) GetSource() int32 {
@@ -150,7 +346,7 @@ type Empty struct {
 
 func (x *Empty) Reset() {
 	*x = Empty{}
-	mi := &file_mpi_proto_msgTypes[2]
+	mi := &file_mpi_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -162,7 +358,7 @@ func (x *Empty) String() string {
 func (*Empty) ProtoMessage() {}
 
 func (x *Empty) ProtoReflect() protoreflect.Message {
-	mi := &file_mpi_proto_msgTypes[2]
+	mi := &file_mpi_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -175,29 +371,54 @@ func (x *Empty) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Empty.ProtoReflect.Descriptor instead.
 func (*Empty) Descriptor() ([]byte, []int) {
-	return file_mpi_proto_rawDescGZIP(), []int{2}
+	return file_mpi_proto_rawDescGZIP(), []int{3}
 }
 
 var File_mpi_proto protoreflect.FileDescriptor
 
 var file_mpi_proto_rawDesc = []byte{
 	0x0a, 0x09, 0x6d, 0x70, 0x69, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x6d, 0x70, 0x69,
-	0x22, 0x5b, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73,
-	0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x73, 0x6f, 0x75,
-	0x72, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x65, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x05, 0x52, 0x04, 0x64, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x61, 0x67, 0x18, 0x03,
-	0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x74, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74,
-	0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x37, 0x0a,
-	0x0b, 0x52, 0x65, 0x63, 0x76, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06,
+	0x22, 0xf1, 0x01, 0x0a, 0x07, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x06,
 	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x73, 0x6f,
-	0x75, 0x72, 0x63, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x61, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x05, 0x52, 0x03, 0x74, 0x61, 0x67, 0x22, 0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x32,
-	0x55, 0x0a, 0x09, 0x4d, 0x50, 0x49, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x20, 0x0a, 0x04,
-	0x53, 0x65, 0x6e, 0x64, 0x12, 0x0c, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x1a, 0x0a, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x26,
-	0x0a, 0x04, 0x52, 0x65, 0x63, 0x76, 0x12, 0x10, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x52, 0x65, 0x63,
-	0x76, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x0c, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x4d,
-	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2e, 0x2f, 0x6d, 0x70, 0x69,
+	0x75, 0x72, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x65, 0x73, 0x74, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x04, 0x64, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x61, 0x67, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x74, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61,
+	0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x21,
+	0x0a, 0x04, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e, 0x6d,
+	0x70, 0x69, 0x2e, 0x53, 0x65, 0x6e, 0x64, 0x4d, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x6d, 0x6f, 0x64,
+	0x65, 0x12, 0x32, 0x0a, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x10, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x43, 0x6f, 0x6d,
+	0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2b, 0x0a, 0x11, 0x75, 0x6e, 0x63, 0x6f, 0x6d, 0x70, 0x72,
+	0x65, 0x73, 0x73, 0x65, 0x64, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x10, 0x75, 0x6e, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x65, 0x64, 0x53, 0x69,
+	0x7a, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x03, 0x73, 0x65, 0x71, 0x22, 0x60, 0x0a, 0x05, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x24, 0x0a,
+	0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e,
+	0x6d, 0x70, 0x69, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x06, 0x68, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x5f, 0x73, 0x69, 0x7a,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x53, 0x69,
+	0x7a, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x37, 0x0a, 0x0b, 0x52, 0x65, 0x63, 0x76, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x74, 0x61, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x74, 0x61, 0x67, 0x22,
+	0x07, 0x0a, 0x05, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x2a, 0x34, 0x0a, 0x08, 0x53, 0x65, 0x6e, 0x64,
+	0x4d, 0x6f, 0x64, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x53, 0x54, 0x41, 0x4e, 0x44, 0x41, 0x52, 0x44,
+	0x10, 0x00, 0x12, 0x0f, 0x0a, 0x0b, 0x53, 0x59, 0x4e, 0x43, 0x48, 0x52, 0x4f, 0x4e, 0x4f, 0x55,
+	0x53, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x52, 0x45, 0x41, 0x44, 0x59, 0x10, 0x02, 0x2a, 0x37,
+	0x0a, 0x0b, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x08, 0x0a,
+	0x04, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x47, 0x5a, 0x49, 0x50, 0x10,
+	0x01, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x4e, 0x41, 0x50, 0x50, 0x59, 0x10, 0x02, 0x12, 0x08, 0x0a,
+	0x04, 0x5a, 0x53, 0x54, 0x44, 0x10, 0x03, 0x32, 0x7d, 0x0a, 0x09, 0x4d, 0x50, 0x49, 0x53, 0x65,
+	0x72, 0x76, 0x65, 0x72, 0x12, 0x20, 0x0a, 0x04, 0x53, 0x65, 0x6e, 0x64, 0x12, 0x0c, 0x2e, 0x6d,
+	0x70, 0x69, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x0a, 0x2e, 0x6d, 0x70, 0x69,
+	0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x26, 0x0a, 0x04, 0x52, 0x65, 0x63, 0x76, 0x12, 0x10,
+	0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x52, 0x65, 0x63, 0x76, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x0c, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x26,
+	0x0a, 0x0a, 0x53, 0x65, 0x6e, 0x64, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x0a, 0x2e, 0x6d,
+	0x70, 0x69, 0x2e, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x1a, 0x0a, 0x2e, 0x6d, 0x70, 0x69, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x28, 0x01, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2e, 0x2f, 0x6d, 0x70, 0x69,
 	0x3b, 0x6d, 0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
@@ -213,22 +434,31 @@ func file_mpi_proto_rawDescGZIP() []byte {
 	return file_mpi_proto_rawDescData
 }
 
-var file_mpi_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_mpi_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_mpi_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_mpi_proto_goTypes = []any{
-	(*Message)(nil),     // 0: mpi.Message
-	(*RecvRequest)(nil), // 1: mpi.RecvRequest
-	(*Empty)(nil),       // 2: mpi.Empty
+	(SendMode)(0),       // 0: mpi.SendMode
+	(Compression)(0),    // 1: mpi.Compression
+	(*Message)(nil),     // 2: mpi.Message
+	(*Chunk)(nil),       // 3: mpi.Chunk
+	(*RecvRequest)(nil), // 4: mpi.RecvRequest
+	(*Empty)(nil),       // 5: mpi.Empty
 }
 var file_mpi_proto_depIdxs = []int32{
-	0, // 0: mpi.MPIServer.Send:input_type -> mpi.Message
-	1, // 1: mpi.MPIServer.Recv:input_type -> mpi.RecvRequest
-	2, // 2: mpi.MPIServer.Send:output_type -> mpi.Empty
-	0, // 3: mpi.MPIServer.Recv:output_type -> mpi.Message
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: mpi.Message.mode:type_name -> mpi.SendMode
+	1, // 1: mpi.Message.compression:type_name -> mpi.Compression
+	2, // 2: mpi.Chunk.header:type_name -> mpi.Message
+	2, // 3: mpi.MPIServer.Send:input_type -> mpi.Message
+	4, // 4: mpi.MPIServer.Recv:input_type -> mpi.RecvRequest
+	3, // 5: mpi.MPIServer.SendStream:input_type -> mpi.Chunk
+	5, // 6: mpi.MPIServer.Send:output_type -> mpi.Empty
+	2, // 7: mpi.MPIServer.Recv:output_type -> mpi.Message
+	5, // 8: mpi.MPIServer.SendStream:output_type -> mpi.Empty
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_mpi_proto_init() }
@@ -241,13 +471,14 @@ func file_mpi_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_mpi_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_mpi_proto_goTypes,
 		DependencyIndexes: file_mpi_proto_depIdxs,
+		EnumInfos:         file_mpi_proto_enumTypes,
 		MessageInfos:      file_mpi_proto_msgTypes,
 	}.Build()
 	File_mpi_proto = out.File
@@ -19,8 +19,9 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	MPIServer_Send_FullMethodName = "/mpi.MPIServer/Send"
-	MPIServer_Recv_FullMethodName = "/mpi.MPIServer/Recv"
+	MPIServer_Send_FullMethodName       = "/mpi.MPIServer/Send"
+	MPIServer_Recv_FullMethodName       = "/mpi.MPIServer/Recv"
+	MPIServer_SendStream_FullMethodName = "/mpi.MPIServer/SendStream"
 )
 
 // MPIServerClient is the client API for MPIServer service.
@@ -29,6 +30,7 @@ const (
 type MPIServerClient interface {
 	Send(ctx context.Context, in *Message, opts ...grpc.CallOption) (*Empty, error)
 	Recv(ctx context.Context, in *RecvRequest, opts ...grpc.CallOption) (*Message, error)
+	SendStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Chunk, Empty], error)
 }
 
 type mPIServerClient struct {
@@ -59,12 +61,26 @@ func (c *mPIServerClient) Recv(ctx context.Context, in *RecvRequest, opts ...grp
 	return out, nil
 }
 
+func (c *mPIServerClient) SendStream(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Chunk, Empty], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MPIServer_ServiceDesc.Streams[0], MPIServer_SendStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Chunk, Empty]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MPIServer_SendStreamClient = grpc.ClientStreamingClient[Chunk, Empty]
+
 // MPIServerServer is the server API for MPIServer service.
 // All implementations must embed UnimplementedMPIServerServer
 // for forward compatibility.
 type MPIServerServer interface {
 	Send(context.Context, *Message) (*Empty, error)
 	Recv(context.Context, *RecvRequest) (*Message, error)
+	SendStream(grpc.ClientStreamingServer[Chunk, Empty]) error
 	mustEmbedUnimplementedMPIServerServer()
 }
 
@@ -81,6 +97,9 @@ func (UnimplementedMPIServerServer) Send(context.Context, *Message) (*Empty, err
 func (UnimplementedMPIServerServer) Recv(context.Context, *RecvRequest) (*Message, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Recv not implemented")
 }
+func (UnimplementedMPIServerServer) SendStream(grpc.ClientStreamingServer[Chunk, Empty]) error {
+	return status.Errorf(codes.Unimplemented, "method SendStream not implemented")
+}
 func (UnimplementedMPIServerServer) mustEmbedUnimplementedMPIServerServer() {}
 func (UnimplementedMPIServerServer) testEmbeddedByValue()                   {}
 
@@ -138,6 +157,13 @@ func _MPIServer_Recv_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MPIServer_SendStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MPIServerServer).SendStream(&grpc.GenericServerStream[Chunk, Empty]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MPIServer_SendStreamServer = grpc.ClientStreamingServer[Chunk, Empty]
+
 // MPIServer_ServiceDesc is the grpc.ServiceDesc for MPIServer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -154,6 +180,12 @@ var MPIServer_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _MPIServer_Recv_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendStream",
+			Handler:       _MPIServer_SendStream_Handler,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "mpi.proto",
 }
@@ -1,6 +1,7 @@
 package mpi
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 )
@@ -19,6 +20,17 @@ func Sum(a, b interface{}) interface{} {
 		return v1.Int() + v2.Int()
 	case reflect.Float32:
 		return float32(v1.Float() + v2.Float())
+	case reflect.Slice:
+		// Element-wise sum, e.g. for gradient vectors
+		if v1.Len() != v2.Len() {
+			panic(fmt.Sprintf("Sum reduction of slices with different lengths: %d and %d", v1.Len(), v2.Len()))
+		}
+		out := reflect.MakeSlice(v1.Type(), v1.Len(), v1.Len())
+		for i := 0; i < v1.Len(); i++ {
+			sum := Sum(v1.Index(i).Interface(), v2.Index(i).Interface())
+			out.Index(i).Set(reflect.ValueOf(sum).Convert(v1.Type().Elem()))
+		}
+		return out.Interface()
 	default:
 		panic(fmt.Sprintf("Unsupported type for Sum reduction: %T", a))
 	}
@@ -26,141 +38,433 @@ func Sum(a, b interface{}) interface{} {
 
 // MPI_Bcast broadcasts data from the root process to all other processes
 func MPI_Bcast(data interface{}, count int, root int) error {
-	// If this is the root process, send to all others
+	return world.Bcast(data, count, root)
+}
+
+// MPI_BcastCtx is MPI_Bcast that gives up when ctx ends, with a *TimeoutError
+func MPI_BcastCtx(ctx context.Context, data interface{}, count int, root int) error {
+	return world.BcastCtx(ctx, data, count, root)
+}
+
+// Bcast is MPI_Bcast on c
+func (c *Comm) Bcast(data interface{}, count int, root int) error {
+	return c.BcastCtx(context.Background(), data, count, root)
+}
+
+// BcastCtx is MPI_BcastCtx on c
+func (c *Comm) BcastCtx(ctx context.Context, data interface{}, count int, root int) error {
+	return c.handleError(c.bcast(ctx, "MPI_Bcast", data, root, TagBroadcast))
+}
+
+func (c *Comm) bcast(ctx context.Context, op string, data interface{}, root int, tag int) error {
+	if err := c.checkRoot(root); err != nil {
+		return err
+	}
+
+	// Binomial tree over ranks numbered relative to root. Each process
+	// receives from its parent and forwards to its children; large payloads
+	// are forwarded chunk by chunk while they are still arriving.
+	rank, size := c.rank, c.size
+	vrank := (rank - root + size) % size
+	mask := 1
+	for mask < size && vrank&mask == 0 {
+		mask <<= 1
+	}
+	var children []int
+	for m := mask >> 1; m > 0; m >>= 1 {
+		if vrank+m < size {
+			children = append(children, (vrank+m+root)%size)
+		}
+	}
+
+	// If this is the root process, send to its children
 	if rank == root {
 		// Serialize the entire data
-		serializedData := Serialize(data)
+		serializedData, err := c.serialize(data)
+		if err != nil {
+			return fmt.Errorf("error serializing broadcast data: %w", err)
+		}
 
-		for i := 0; i < size; i++ {
-			if i != root {
-				err := MPI_Send(serializedData, i, TagBroadcast)
-				if err != nil {
-					return fmt.Errorf("error broadcasting to rank %d: %v", i, err)
-				}
+		for _, child := range children {
+			err := c.send(ctx, op, serializedData, child, tag, SendMode_STANDARD)
+			if err != nil {
+				return fmt.Errorf("error broadcasting to rank %d: %w", child, err)
 			}
 		}
-	} else {
-		// Non-root processes receive data
-		receivedData, err := MPI_Recv(root, TagBroadcast)
+		return nil
+	}
+
+	// Non-root processes receive data from their parent and pass it on
+	parent := (vrank - mask + root) % size
+	msg, stream, err := c.recvMessage(ctx, op, parent, tag)
+	if err != nil {
+		return fmt.Errorf("error receiving broadcast data: %w", err)
+	}
+	pending := children
+	if stream != nil {
+		// Chunks are forwarded as they arrived, still compressed if they were
+		if len(children) > 0 {
+			pending, err = c.forwardStream(ctx, msg, stream, children, tag)
+			if err != nil {
+				return fmt.Errorf("error broadcasting: %w", err)
+			}
+		}
+		msg.Data, err = stream.wait()
 		if err != nil {
-			return fmt.Errorf("error receiving broadcast data: %v", err)
+			return fmt.Errorf("error receiving broadcast data: %w", err)
 		}
-
-		// Deserialize into the provided data interface
-		err = Deserialize(receivedData, data)
+	}
+	if err := c.decompressMessage(msg); err != nil {
+		return fmt.Errorf("error receiving broadcast data: %w", err)
+	}
+	receivedData := msg.Data
+	for _, child := range pending {
+		err := c.send(ctx, op, receivedData, child, tag, SendMode_STANDARD)
 		if err != nil {
-			return fmt.Errorf("error deserializing broadcast data: %v", err)
+			return fmt.Errorf("error broadcasting to rank %d: %w", child, err)
 		}
 	}
 
+	// Deserialize into the provided data interface
+	err = c.deserialize(receivedData, data)
+	if err != nil {
+		return fmt.Errorf("error deserializing broadcast data: %w", err)
+	}
 	return nil
 }
 
 // MPI_Reduce reduces values from all processes to the root using the specified operation
 func MPI_Reduce(sendData interface{}, recvData interface{}, op ReductionOp, root int) error {
+	return world.Reduce(sendData, recvData, op, root)
+}
+
+// MPI_ReduceCtx is MPI_Reduce that gives up when ctx ends, with a *TimeoutError
+func MPI_ReduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp, root int) error {
+	return world.ReduceCtx(ctx, sendData, recvData, op, root)
+}
+
+// Reduce is MPI_Reduce on c
+func (c *Comm) Reduce(sendData interface{}, recvData interface{}, op ReductionOp, root int) error {
+	return c.ReduceCtx(context.Background(), sendData, recvData, op, root)
+}
+
+// ReduceCtx is MPI_ReduceCtx on c
+func (c *Comm) ReduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp, root int) error {
+	return c.handleError(c.reduce(ctx, "MPI_Reduce", sendData, recvData, op, root, TagReduce))
+}
+
+func (c *Comm) reduce(ctx context.Context, name string, sendData interface{}, recvData interface{}, op ReductionOp, root int, tag int) error {
+	if err := c.checkRoot(root); err != nil {
+		return err
+	}
+	if op == nil {
+		return errorf(MPI_ERR_OP, "reduction operation is nil")
+	}
+
 	// Serialize the send data
-	serializedData := Serialize(sendData)
+	serializedData, err := c.serialize(sendData)
+	if err != nil {
+		return fmt.Errorf("error serializing reduce data: %w", err)
+	}
 
-	if rank == root {
+	if c.rank == root {
 		// Initialize receive data with the first process's data
-		Deserialize(serializedData, recvData)
+		if err := c.deserialize(serializedData, recvData); err != nil {
+			return fmt.Errorf("error initializing reduce result: %w", err)
+		}
 
 		// Receive and reduce data from other processes
-		for i := 0; i < size; i++ {
+		for i := 0; i < c.size; i++ {
 			if i == root {
 				continue // Skip the root process itself
 			}
 
 			// Receive data from each non-root process
-			receivedBytes, err := MPI_Recv(i, TagReduce)
+			receivedBytes, err := c.recv(ctx, name, i, tag)
 			if err != nil {
-				return fmt.Errorf("error receiving data from rank %d: %v", i, err)
+				return fmt.Errorf("error receiving data from rank %d: %w", i, err)
 			}
 
-			// Deserialize the received data
-			var receivedValue interface{}
-			err = Deserialize(receivedBytes, &receivedValue)
+			// Deserialize the received data into a value of the receive type
+			result := reflect.ValueOf(recvData).Elem()
+			receivedValue := reflect.New(result.Type())
+			err = c.deserialize(receivedBytes, receivedValue.Interface())
 			if err != nil {
-				return fmt.Errorf("error deserializing data from rank %d: %v", i, err)
+				return fmt.Errorf("error deserializing data from rank %d: %w", i, err)
 			}
 
-			// Perform the reduction operation
-			reducedValue := op(reflect.ValueOf(recvData).Elem().Interface(), receivedValue)
-
-			// Update the receive data
-			reflect.ValueOf(recvData).Elem().Set(reflect.ValueOf(reducedValue))
+			// Perform the reduction operation and update the receive data
+			if err := applyOp(op, result, receivedValue.Elem()); err != nil {
+				return fmt.Errorf("error reducing data from rank %d: %w", i, err)
+			}
 		}
 	} else {
 		// Non-root processes send their data to the root
-		err := MPI_Send(serializedData, root, TagReduce)
+		err := c.send(ctx, name, serializedData, root, tag, SendMode_STANDARD)
 		if err != nil {
-			return fmt.Errorf("error sending data to root: %v", err)
+			return fmt.Errorf("error sending data to root: %w", err)
 		}
 	}
 
 	return nil
 }
 
+// applyOp sets result to op applied to result and v. A panic in op, or a
+// result that does not convert to the type of result, is an MPI_ERR_OP error.
+func applyOp(op ReductionOp, result reflect.Value, v reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorf(MPI_ERR_OP, "reduction operation failed: %v", r)
+		}
+	}()
+	out := op(result.Interface(), v.Interface())
+	reduced := reflect.ValueOf(out)
+	if !reduced.IsValid() || !reduced.Type().ConvertibleTo(result.Type()) {
+		return errorf(MPI_ERR_OP, "reduction operation returned %T, which does not convert to %s", out, result.Type())
+	}
+	result.Set(reduced.Convert(result.Type()))
+	return nil
+}
+
+// MPI_Allreduce reduces values from all processes and leaves the result on every process
+func MPI_Allreduce(sendData interface{}, recvData interface{}, op ReductionOp) error {
+	return world.Allreduce(sendData, recvData, op)
+}
+
+// MPI_AllreduceCtx is MPI_Allreduce that gives up when ctx ends, with a
+// *TimeoutError
+func MPI_AllreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp) error {
+	return world.AllreduceCtx(ctx, sendData, recvData, op)
+}
+
+// Allreduce is MPI_Allreduce on c
+func (c *Comm) Allreduce(sendData interface{}, recvData interface{}, op ReductionOp) error {
+	return c.AllreduceCtx(context.Background(), sendData, recvData, op)
+}
+
+// AllreduceCtx is MPI_AllreduceCtx on c
+func (c *Comm) AllreduceCtx(ctx context.Context, sendData interface{}, recvData interface{}, op ReductionOp) error {
+	return c.handleError(c.allreduce(ctx, "MPI_Allreduce", sendData, recvData, op, TagAllreduce))
+}
+
+func (c *Comm) allreduce(ctx context.Context, name string, sendData interface{}, recvData interface{}, op ReductionOp, tag int) error {
+	const root = 0
+	if err := c.reduce(ctx, name, sendData, recvData, op, root, tag); err != nil {
+		return err
+	}
+	return c.bcast(ctx, name, recvData, root, tag)
+}
+
+// MPI_Barrier blocks until every process has entered the barrier
+func MPI_Barrier() error {
+	return world.Barrier()
+}
+
+// MPI_BarrierCtx is MPI_Barrier that gives up when ctx ends, with a
+// *TimeoutError
+func MPI_BarrierCtx(ctx context.Context) error {
+	return world.BarrierCtx(ctx)
+}
+
+// Barrier is MPI_Barrier on c
+func (c *Comm) Barrier() error {
+	return c.BarrierCtx(context.Background())
+}
+
+// BarrierCtx is MPI_BarrierCtx on c
+func (c *Comm) BarrierCtx(ctx context.Context) error {
+	return c.handleError(c.barrier(ctx, "MPI_Barrier", TagBarrier))
+}
+
+func (c *Comm) barrier(ctx context.Context, op string, tag int) error {
+	const root = 0
+	if c.rank == root {
+		// Wait for everyone to arrive, then release them
+		for i := 0; i < c.size; i++ {
+			if i == root {
+				continue
+			}
+			if _, err := c.recv(ctx, op, i, tag); err != nil {
+				return fmt.Errorf("error waiting for rank %d at barrier: %w", i, err)
+			}
+		}
+		for i := 0; i < c.size; i++ {
+			if i == root {
+				continue
+			}
+			if err := c.send(ctx, op, nil, i, tag, SendMode_STANDARD); err != nil {
+				return fmt.Errorf("error releasing rank %d from barrier: %w", i, err)
+			}
+		}
+	} else {
+		if err := c.send(ctx, op, nil, root, tag, SendMode_STANDARD); err != nil {
+			return fmt.Errorf("error entering barrier: %w", err)
+		}
+		if _, err := c.recv(ctx, op, root, tag); err != nil {
+			return fmt.Errorf("error leaving barrier: %w", err)
+		}
+	}
+	return nil
+}
+
 // MPI_Scatter distributes data from root to all processes
 func MPI_Scatter(sendData interface{}, recvData interface{}, count int, root int) error {
-	if rank == root {
-		for i := 0; i < size; i++ {
+	return world.Scatter(sendData, recvData, count, root)
+}
+
+// MPI_ScatterCtx is MPI_Scatter that gives up when ctx ends, with a
+// *TimeoutError
+func MPI_ScatterCtx(ctx context.Context, sendData interface{}, recvData interface{}, count int, root int) error {
+	return world.ScatterCtx(ctx, sendData, recvData, count, root)
+}
+
+// Scatter is MPI_Scatter on c
+func (c *Comm) Scatter(sendData interface{}, recvData interface{}, count int, root int) error {
+	return c.ScatterCtx(context.Background(), sendData, recvData, count, root)
+}
+
+// ScatterCtx is MPI_ScatterCtx on c
+func (c *Comm) ScatterCtx(ctx context.Context, sendData interface{}, recvData interface{}, count int, root int) (err error) {
+	const op = "MPI_Scatter"
+	defer func() { err = c.handleError(err) }()
+	if err := c.checkRoot(root); err != nil {
+		return err
+	}
+	if count < 0 {
+		return errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	if c.rank == root {
+		send, err := float64Buffer(op, "send", sendData, c.size*count)
+		if err != nil {
+			return err
+		}
+		recv, err := float64Buffer(op, "receive", recvData, count)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < c.size; i++ {
 			if i == root {
 				// Copy data to root's local buffer
-				copy(recvData.([]float64), sendData.([]float64)[i*count:(i+1)*count])
+				copy(recv, send[i*count:(i+1)*count])
 			} else {
 				// Send data to other processes
 				start := i * count
 				end := (i + 1) * count
-				serializedData := Serialize(sendData.([]float64)[start:end])
-				err := MPI_Send(serializedData, i, TagScatter)
+				serializedData, err := c.serialize(send[start:end])
+				if err != nil {
+					return fmt.Errorf("error serializing data for rank %d: %w", i, err)
+				}
+				err = c.send(ctx, op, serializedData, i, TagScatter, SendMode_STANDARD)
 				if err != nil {
-					return fmt.Errorf("error scattering to rank %d: %v", i, err)
+					return fmt.Errorf("error scattering to rank %d: %w", i, err)
 				}
 			}
 		}
 	} else {
 		// Receive data from root process
-		receivedData, err := MPI_Recv(root, TagScatter)
+		receivedData, err := c.recv(ctx, op, root, TagScatter)
 		if err != nil {
-			return fmt.Errorf("error receiving scattered data: %v", err)
+			return fmt.Errorf("error receiving scattered data: %w", err)
+		}
+		if err := c.deserialize(receivedData, recvData); err != nil {
+			return fmt.Errorf("error deserializing scattered data: %w", err)
 		}
-		Deserialize(receivedData, recvData)
 	}
 	return nil
 }
 
 // MPI_Gather collects data from all processes to the root
 func MPI_Gather(sendData interface{}, recvData interface{}, count int, root int) error {
-	if rank == root {
-		for i := 0; i < size; i++ {
+	return world.Gather(sendData, recvData, count, root)
+}
+
+// MPI_GatherCtx is MPI_Gather that gives up when ctx ends, with a
+// *TimeoutError
+func MPI_GatherCtx(ctx context.Context, sendData interface{}, recvData interface{}, count int, root int) error {
+	return world.GatherCtx(ctx, sendData, recvData, count, root)
+}
+
+// Gather is MPI_Gather on c
+func (c *Comm) Gather(sendData interface{}, recvData interface{}, count int, root int) error {
+	return c.GatherCtx(context.Background(), sendData, recvData, count, root)
+}
+
+// GatherCtx is MPI_GatherCtx on c
+func (c *Comm) GatherCtx(ctx context.Context, sendData interface{}, recvData interface{}, count int, root int) (err error) {
+	const op = "MPI_Gather"
+	defer func() { err = c.handleError(err) }()
+	if err := c.checkRoot(root); err != nil {
+		return err
+	}
+	if count < 0 {
+		return errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	if c.rank == root {
+		recv, err := float64Buffer(op, "receive", recvData, c.size*count)
+		if err != nil {
+			return err
+		}
+		send, err := float64Buffer(op, "send", sendData, 0)
+		if err != nil {
+			return err
+		}
+		if len(send) > count {
+			return errorf(MPI_ERR_TRUNCATE, "%s of %d items from rank %d into %d items per rank", op, len(send), c.rank, count)
+		}
+		for i := 0; i < c.size; i++ {
 			if i == root {
 				// Copy data from root's local buffer
-				copy(recvData.([]float64)[i*count:(i+1)*count], sendData.([]float64))
+				copy(recv[i*count:(i+1)*count], send)
 			} else {
 				// Receive data from other processes
-				receivedBytes, err := MPI_Recv(i, TagGather)
+				receivedBytes, err := c.recv(ctx, op, i, TagGather)
 				if err != nil {
-					return fmt.Errorf("error receiving gathered data from rank %d: %v", i, err)
+					return fmt.Errorf("error receiving gathered data from rank %d: %w", i, err)
 				}
 				var receivedData []float64
-				Deserialize(receivedBytes, &receivedData)
-				copy(recvData.([]float64)[i*count:(i+1)*count], receivedData)
+				if err := c.deserialize(receivedBytes, &receivedData); err != nil {
+					return fmt.Errorf("error deserializing gathered data from rank %d: %w", i, err)
+				}
+				if len(receivedData) > count {
+					return errorf(MPI_ERR_TRUNCATE, "%s of %d items from rank %d into %d items per rank", op, len(receivedData), i, count)
+				}
+				copy(recv[i*count:(i+1)*count], receivedData)
 			}
 		}
 	} else {
 		// Send data to root process
-		serializedData := Serialize(sendData)
-		err := MPI_Send(serializedData, root, TagGather)
+		serializedData, err := c.serialize(sendData)
+		if err != nil {
+			return fmt.Errorf("error serializing gathered data: %w", err)
+		}
+		err = c.send(ctx, op, serializedData, root, TagGather, SendMode_STANDARD)
 		if err != nil {
-			return fmt.Errorf("error sending gathered data: %v", err)
+			return fmt.Errorf("error sending gathered data: %w", err)
 		}
 	}
 	return nil
 }
 
-// Add new tag constants in sendrecv.go
+// float64Buffer returns buf, the send or receive buffer of op, as a []float64
+// of at least n items
+func float64Buffer(op string, which string, buf interface{}, n int) ([]float64, error) {
+	s, ok := buf.([]float64)
+	if !ok {
+		return nil, errorf(MPI_ERR_TYPE, "%s %s buffer must be a []float64, got %T", op, which, buf)
+	}
+	if len(s) < n {
+		return nil, errorf(MPI_ERR_BUFFER, "%s %s buffer holds %d items, need %d", op, which, len(s), n)
+	}
+	return s, nil
+}
+
+// Tags of the blocking collectives. Like the other internal tags they are
+// negative, so user sends and receives can never use or match them.
 const (
-	TagScatter = 2
-	TagGather  = 3
+	TagBroadcast = -10
+	TagReduce    = -11
+	TagScatter   = -12
+	TagGather    = -13
+	TagBarrier   = -14
+	TagAllreduce = -15
 )
@@ -0,0 +1,105 @@
+// Package mpitest runs a whole MPI job inside one process, one goroutine per
+// rank, so that code built on the mpi package can be exercised by go test
+// without launching a process per rank.
+package mpitest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+)
+
+// Run starts n ranks connected over loopback gRPC, without shared memory, and
+// calls fn for each of them concurrently. It waits for every rank to return
+// and then shuts the job down. Errors and panics are reported per rank,
+// prefixed with the rank.
+func Run(n int, fn func(comm *mpi.Comm) error) error {
+	return RunConfig(n, mpi.Config{DisableSharedMemory: true}, fn)
+}
+
+// RunConfig is Run with the settings in base applied to every rank. Rank,
+// Size, Addresses and Listener in base are filled in by the harness, and so
+// is Network if base.Transport is mpi.TransportMemory. As in a job on a
+// single host, the ranks talk through shared memory where the platform
+// supports it unless base.DisableSharedMemory is set.
+func RunConfig(n int, base mpi.Config, fn func(comm *mpi.Comm) error) error {
+	if n <= 0 {
+		return fmt.Errorf("invalid number of ranks %d", n)
+	}
+
+	// Listen first, so every address is known before any rank starts
+	listeners := make([]net.Listener, n)
+	addresses := make(map[int]string, n)
+	if base.Transport == mpi.TransportMemory {
+		// Nothing to listen on; the addresses only need to be present
+		if base.Network == nil {
+			base.Network = mpi.NewMemoryNetwork()
+		}
+		for r := 0; r < n; r++ {
+			addresses[r] = fmt.Sprintf("memory:%d", r)
+		}
+	} else {
+		for r := 0; r < n; r++ {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				closeAll(listeners)
+				return fmt.Errorf("failed to listen: %v", err)
+			}
+			listeners[r] = lis
+			addresses[r] = lis.Addr().String()
+		}
+	}
+
+	comms := make([]*mpi.Comm, n)
+	for r := 0; r < n; r++ {
+		cfg := base
+		cfg.Rank = r
+		cfg.Size = n
+		cfg.Addresses = addresses
+		cfg.Listener = listeners[r]
+		comm, err := mpi.NewComm(cfg)
+		if err != nil {
+			for _, c := range comms[:r] {
+				c.Finalize()
+			}
+			closeAll(listeners[r:])
+			return fmt.Errorf("rank %d: %v", r, err)
+		}
+		comms[r] = comm
+	}
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for r, comm := range comms {
+		wg.Add(1)
+		go func(r int, comm *mpi.Comm) {
+			defer wg.Done()
+			defer func() {
+				if p := recover(); p != nil {
+					errs[r] = fmt.Errorf("rank %d panicked: %v\n%s", r, p, debug.Stack())
+				}
+			}()
+			if err := fn(comm); err != nil {
+				errs[r] = fmt.Errorf("rank %d: %w", r, err)
+			}
+		}(r, comm)
+	}
+	wg.Wait()
+
+	for _, comm := range comms {
+		comm.Finalize()
+	}
+	return errors.Join(errs...)
+}
+
+func closeAll(listeners []net.Listener) {
+	for _, l := range listeners {
+		if l != nil {
+			l.Close()
+		}
+	}
+}
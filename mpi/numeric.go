@@ -0,0 +1,251 @@
+package mpi
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// Numeric slices skip gob entirely. They are encoded as a 4-byte header
+// followed by the elements in little-endian order:
+//
+//	0xFF 'N' version kind elements...
+//
+// A gob stream can never start with 0xFF followed by a byte below 0x80, so
+// the two encodings cannot be confused.
+const (
+	numericMagic0  = 0xFF
+	numericMagic1  = 'N'
+	numericVersion = 1
+	numericHeader  = 4
+)
+
+type numericKind byte
+
+const (
+	kindInt8 numericKind = iota + 1
+	kindInt16
+	kindInt32
+	kindInt64
+	kindInt // Always encoded as 64 bits
+	kindUint8
+	kindUint16
+	kindUint32
+	kindUint64
+	kindUint // Always encoded as 64 bits
+	kindFloat32
+	kindFloat64
+	kindComplex64
+	kindComplex128
+)
+
+type number interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~int |
+		~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uint |
+		~float32 | ~float64 | ~complex64 | ~complex128
+}
+
+var hostLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// encodeNumeric encodes data with the raw numeric format. It reports false if
+// data is not a supported numeric slice.
+func encodeNumeric(data interface{}) ([]byte, bool) {
+	switch s := data.(type) {
+	case []int8:
+		return encodeSlice(kindInt8, s, 1), true
+	case []int16:
+		return encodeSlice(kindInt16, s, 2), true
+	case []int32:
+		return encodeSlice(kindInt32, s, 4), true
+	case []int64:
+		return encodeSlice(kindInt64, s, 8), true
+	case []int:
+		if strconv.IntSize == 64 {
+			return encodeSlice(kindInt, s, 8), true
+		}
+		return encodeSlice(kindInt, widen[int, int64](s), 8), true
+	case []uint8:
+		return encodeSlice(kindUint8, s, 1), true
+	case []uint16:
+		return encodeSlice(kindUint16, s, 2), true
+	case []uint32:
+		return encodeSlice(kindUint32, s, 4), true
+	case []uint64:
+		return encodeSlice(kindUint64, s, 8), true
+	case []uint:
+		if strconv.IntSize == 64 {
+			return encodeSlice(kindUint, s, 8), true
+		}
+		return encodeSlice(kindUint, widen[uint, uint64](s), 8), true
+	case []float32:
+		return encodeSlice(kindFloat32, s, 4), true
+	case []float64:
+		return encodeSlice(kindFloat64, s, 8), true
+	case []complex64:
+		return encodeSlice(kindComplex64, s, 4), true
+	case []complex128:
+		return encodeSlice(kindComplex128, s, 8), true
+	}
+	return nil, false
+}
+
+// isNumeric reports whether data was produced by encodeNumeric
+func isNumeric(data []byte) bool {
+	return len(data) >= numericHeader && data[0] == numericMagic0 && data[1] == numericMagic1
+}
+
+// decodeNumeric decodes data produced by encodeNumeric into v, which may be
+// a slice of the matching type (filled in place), a pointer to such a slice
+// (resized, reusing its capacity) or a pointer to an empty interface.
+func decodeNumeric(data []byte, v interface{}) error {
+	if data[2] != numericVersion {
+		return fmt.Errorf("unsupported numeric encoding version %d", data[2])
+	}
+	kind, payload := numericKind(data[3]), data[numericHeader:]
+
+	switch kind {
+	case kindInt8:
+		return decodeSlice[int8](payload, v, 1)
+	case kindInt16:
+		return decodeSlice[int16](payload, v, 2)
+	case kindInt32:
+		return decodeSlice[int32](payload, v, 4)
+	case kindInt64:
+		return decodeSlice[int64](payload, v, 8)
+	case kindInt:
+		if strconv.IntSize == 64 {
+			return decodeSlice[int](payload, v, 8)
+		}
+		return decodeNarrowed[int64, int](payload, v)
+	case kindUint8:
+		return decodeSlice[uint8](payload, v, 1)
+	case kindUint16:
+		return decodeSlice[uint16](payload, v, 2)
+	case kindUint32:
+		return decodeSlice[uint32](payload, v, 4)
+	case kindUint64:
+		return decodeSlice[uint64](payload, v, 8)
+	case kindUint:
+		if strconv.IntSize == 64 {
+			return decodeSlice[uint](payload, v, 8)
+		}
+		return decodeNarrowed[uint64, uint](payload, v)
+	case kindFloat32:
+		return decodeSlice[float32](payload, v, 4)
+	case kindFloat64:
+		return decodeSlice[float64](payload, v, 8)
+	case kindComplex64:
+		return decodeSlice[complex64](payload, v, 4)
+	case kindComplex128:
+		return decodeSlice[complex128](payload, v, 8)
+	}
+	return fmt.Errorf("unknown numeric element kind %d", kind)
+}
+
+// encodeSlice writes the header and the elements of s. unit is the size of
+// the scalar parts of an element (the two halves of a complex number are
+// byte-swapped separately on big-endian hosts).
+func encodeSlice[T number](kind numericKind, s []T, unit int) []byte {
+	raw := sliceBytes(s)
+	buf := make([]byte, numericHeader+len(raw))
+	buf[0], buf[1], buf[2], buf[3] = numericMagic0, numericMagic1, numericVersion, byte(kind)
+	copy(buf[numericHeader:], raw)
+	if !hostLittleEndian {
+		swapBytes(buf[numericHeader:], unit)
+	}
+	return buf
+}
+
+func decodeSlice[T number](payload []byte, v interface{}, unit int) error {
+	var zero T
+	elemSize := int(unsafe.Sizeof(zero))
+	if len(payload)%elemSize != 0 {
+		return fmt.Errorf("numeric payload of %d bytes is not a whole number of %T", len(payload), zero)
+	}
+	n := len(payload) / elemSize
+
+	var dst []T
+	switch t := v.(type) {
+	case []T:
+		if len(t) < n {
+			return fmt.Errorf("receive buffer of %d elements is too small for %d", len(t), n)
+		}
+		dst = t[:n]
+	case *[]T:
+		if cap(*t) < n {
+			*t = make([]T, n)
+		}
+		*t = (*t)[:n]
+		dst = *t
+	case *interface{}:
+		dst = make([]T, n)
+		*t = dst
+	default:
+		return fmt.Errorf("cannot decode []%T into %T", zero, v)
+	}
+
+	raw := sliceBytes(dst)
+	copy(raw, payload)
+	if !hostLittleEndian {
+		swapBytes(raw, unit)
+	}
+	return nil
+}
+
+// decodeNarrowed decodes 64-bit elements into a slice of a narrower
+// platform-sized integer type
+func decodeNarrowed[W int64 | uint64, N int | uint](payload []byte, v interface{}) error {
+	var wide []W
+	if err := decodeSlice[W](payload, &wide, 8); err != nil {
+		return err
+	}
+	narrow := make([]N, len(wide))
+	for i, x := range wide {
+		narrow[i] = N(x)
+	}
+	switch t := v.(type) {
+	case []N:
+		if len(t) < len(narrow) {
+			return fmt.Errorf("receive buffer of %d elements is too small for %d", len(t), len(narrow))
+		}
+		copy(t, narrow)
+	case *[]N:
+		*t = narrow
+	case *interface{}:
+		*t = narrow
+	default:
+		return fmt.Errorf("cannot decode %T into %T", narrow, v)
+	}
+	return nil
+}
+
+func widen[N int | uint, W int64 | uint64](s []N) []W {
+	out := make([]W, len(s))
+	for i, x := range s {
+		out[i] = W(x)
+	}
+	return out
+}
+
+// sliceBytes returns the memory backing s without copying it
+func sliceBytes[T number](s []T) []byte {
+	if len(s) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), len(s)*int(unsafe.Sizeof(s[0])))
+}
+
+// swapBytes reverses the byte order of every unit-sized word in b
+func swapBytes(b []byte, unit int) {
+	if unit == 1 {
+		return
+	}
+	for i := 0; i+unit <= len(b); i += unit {
+		for l, r := i, i+unit-1; l < r; l, r = l+1, r-1 {
+			b[l], b[r] = b[r], b[l]
+		}
+	}
+}
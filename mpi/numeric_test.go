@@ -0,0 +1,166 @@
+package mpi
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// roundTrip encodes s with GobCodec and decodes it into a new slice of the
+// same type through a pointer
+func roundTrip(t *testing.T, s interface{}) {
+	t.Helper()
+	data, err := GobCodec.Marshal(s)
+	if err != nil {
+		t.Fatalf("%T: %v", s, err)
+	}
+	if !isNumeric(data) {
+		t.Fatalf("%T was not encoded with the numeric format", s)
+	}
+	out := reflect.New(reflect.TypeOf(s))
+	if err := GobCodec.Unmarshal(data, out.Interface()); err != nil {
+		t.Fatalf("%T: %v", s, err)
+	}
+	if got := out.Elem().Interface(); reflect.ValueOf(s).Len() != reflect.ValueOf(got).Len() ||
+		(reflect.ValueOf(s).Len() > 0 && !reflect.DeepEqual(got, s)) {
+		t.Errorf("%T: got %v, want %v", s, got, s)
+	}
+}
+
+func TestNumericRoundTrip(t *testing.T) {
+	for _, s := range []interface{}{
+		[]int8{-128, 0, 127},
+		[]int16{-32768, 1, 32767},
+		[]int32{-1 << 31, 2, 1<<31 - 1},
+		[]int64{-1 << 63, 3, 1<<63 - 1},
+		[]int{math.MinInt, 4, math.MaxInt},
+		[]uint8{0, 1, 255},
+		[]uint16{0, 1, 65535},
+		[]uint32{0, 1, 1<<32 - 1},
+		[]uint64{0, 1, 1<<64 - 1},
+		[]uint{0, 1, math.MaxUint},
+		[]float32{-1.5, 0, 3.25},
+		[]float64{-1.5, 0, 1e300},
+		[]complex64{complex(1, -2), 0},
+		[]complex128{complex(1e300, -2), 0},
+	} {
+		roundTrip(t, s)
+		// Empty slices of every kind round-trip too
+		roundTrip(t, reflect.MakeSlice(reflect.TypeOf(s), 0, 0).Interface())
+	}
+}
+
+func TestNumericDecodeInPlace(t *testing.T) {
+	data, _ := GobCodec.Marshal([]float64{1, 2})
+	buf := make([]float64, 3)
+	if err := GobCodec.Unmarshal(data, buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != 1 || buf[1] != 2 {
+		t.Errorf("got %v", buf)
+	}
+	if err := GobCodec.Unmarshal(data, make([]float64, 1)); err == nil {
+		t.Error("decoding into a buffer that is too small succeeded")
+	}
+	if err := GobCodec.Unmarshal(data, &[]int32{}); err == nil {
+		t.Error("decoding float64 elements into []int32 succeeded")
+	}
+	var v interface{}
+	if err := GobCodec.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := v.([]float64); !ok || len(got) != 2 {
+		t.Errorf("decoded into interface as %#v", v)
+	}
+}
+
+func TestNumericCorruptHeader(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short header":    {numericMagic0, numericMagic1},
+		"bad version":     {numericMagic0, numericMagic1, 99, byte(kindFloat64)},
+		"unknown kind":    {numericMagic0, numericMagic1, numericVersion, 200},
+		"zero kind":       {numericMagic0, numericMagic1, numericVersion, 0},
+		"partial element": {numericMagic0, numericMagic1, numericVersion, byte(kindFloat64), 1, 2, 3},
+	} {
+		var out []float64
+		if err := GobCodec.Unmarshal(data, &out); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
+
+func benchmarkSlices() map[string]interface{} {
+	f := make([]float64, 1<<16)
+	i := make([]int32, 1<<16)
+	b := make([]byte, 1<<19)
+	for k := range f {
+		f[k] = float64(k) * 1.5
+		i[k] = int32(k)
+	}
+	for k := range b {
+		b[k] = byte(k)
+	}
+	return map[string]interface{}{"float64": f, "int32": i, "byte": b}
+}
+
+// sliceSize returns the number of bytes in the elements of s
+func sliceSize(s interface{}) int64 {
+	v := reflect.ValueOf(s)
+	return int64(v.Len()) * int64(v.Type().Elem().Size())
+}
+
+// BenchmarkEncode compares gob with the raw numeric encoding. Throughput is
+// measured in bytes of slice elements.
+func BenchmarkEncode(b *testing.B) {
+	for name, s := range benchmarkSlices() {
+		b.Run(name+"/gob", func(b *testing.B) {
+			b.SetBytes(sliceSize(s))
+			for n := 0; n < b.N; n++ {
+				var buf bytes.Buffer
+				if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/raw", func(b *testing.B) {
+			b.SetBytes(sliceSize(s))
+			for n := 0; n < b.N; n++ {
+				if _, err := GobCodec.Marshal(s); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecode compares gob with the raw numeric encoding
+func BenchmarkDecode(b *testing.B) {
+	for name, s := range benchmarkSlices() {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+			b.Fatal(err)
+		}
+		gobData := buf.Bytes()
+		rawData, _ := GobCodec.Marshal(s)
+		b.Run(name+"/gob", func(b *testing.B) {
+			b.SetBytes(sliceSize(s))
+			for n := 0; n < b.N; n++ {
+				out := reflect.New(reflect.TypeOf(s))
+				if err := gob.NewDecoder(bytes.NewReader(gobData)).Decode(out.Interface()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/raw", func(b *testing.B) {
+			b.SetBytes(sliceSize(s))
+			out := reflect.New(reflect.TypeOf(s))
+			for n := 0; n < b.N; n++ {
+				if err := GobCodec.Unmarshal(rawData, out.Interface()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
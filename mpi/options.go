@@ -0,0 +1,336 @@
+package mpi
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the settings that MPI_InitWithOptions and NewComm use when they
+// are left at zero
+const (
+	DefaultMaxMessageSize = 50 << 20 // Largest gRPC message sent or received
+	DefaultRecvTimeout    = 30 * time.Second
+	DefaultPollInterval   = 2 * time.Second // Between checks while discovery waits
+
+	DefaultKeepaliveTime    = 30 * time.Second
+	DefaultKeepaliveTimeout = 20 * time.Second
+)
+
+// minMaxMessageSize leaves room for a full stream chunk and its envelope
+const minMaxMessageSize = streamChunkSize + 64<<10
+
+// KeepaliveConfig sets how often gRPC pings idle connections, so that a
+// connection whose peer has gone away is noticed and replaced
+type KeepaliveConfig struct {
+	// Time is the idle time after which a connection is pinged,
+	// DefaultKeepaliveTime if zero. A negative Time disables pings.
+	Time time.Duration `yaml:"time" toml:"time"`
+	// Timeout is how long a ping may go unanswered before the connection is
+	// closed, DefaultKeepaliveTimeout if zero
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// Option changes a setting of MPI_InitWithOptions
+type Option func(*initConfig)
+
+// initConfig is everything MPI_InitWithOptions needs: the communicator
+// configuration and the settings used to get there
+type initConfig struct {
+	Config
+	configFile       string
+	discovery        string
+	discoveryTimeout time.Duration
+	pollInterval     time.Duration
+	readyTimeout     time.Duration
+	logLevel         string
+	logFormat        string
+}
+
+// WithConfigFile reads settings from a YAML or TOML file, see FileConfig.
+// MPI_CONFIG takes precedence.
+func WithConfigFile(path string) Option {
+	return func(ic *initConfig) { ic.configFile = path }
+}
+
+// WithTransport selects a transport by name, such as TransportTCP
+func WithTransport(name string) Option {
+	return func(ic *initConfig) { ic.Transport = name }
+}
+
+// WithSharedMemory enables or disables shared memory between ranks on the
+// same host
+func WithSharedMemory(enabled bool) Option {
+	return func(ic *initConfig) { ic.DisableSharedMemory = !enabled }
+}
+
+// WithMaxMessageSize limits the size of a single gRPC message. Payloads
+// larger than a stream chunk are streamed and not subject to it.
+func WithMaxMessageSize(n int) Option {
+	return func(ic *initConfig) { ic.MaxMessageSize = n }
+}
+
+// WithRecvTimeout sets how long a receive waits for a matching message. A
+// negative timeout waits forever.
+func WithRecvTimeout(d time.Duration) Option {
+	return func(ic *initConfig) { ic.RecvTimeout = d }
+}
+
+// WithKeepalive sets gRPC keepalive pings
+func WithKeepalive(k KeepaliveConfig) Option {
+	return func(ic *initConfig) { ic.Keepalive = k }
+}
+
+// WithRetry sets how sends that fail with transient network errors are
+// retried
+func WithRetry(r RetryConfig) Option {
+	return func(ic *initConfig) { ic.Retry = r }
+}
+
+// WithCodec selects the codec of the collective operations
+func WithCodec(c Codec) Option {
+	return func(ic *initConfig) { ic.Codec = c }
+}
+
+// WithCompression compresses payloads of at least threshold bytes with alg
+func WithCompression(alg Compression, threshold int) Option {
+	return func(ic *initConfig) {
+		ic.Compression = alg
+		ic.CompressionThreshold = threshold
+	}
+}
+
+// WithMaxDecompressedSize limits the size a compressed payload may expand to
+func WithMaxDecompressedSize(n int) Option {
+	return func(ic *initConfig) { ic.MaxDecompressedSize = n }
+}
+
+// WithTLS secures traffic between ranks, see TLSConfig
+func WithTLS(cfg TLSConfig) Option {
+	return func(ic *initConfig) { ic.TLS = cfg }
+}
+
+// WithJobToken sets the shared secret that authenticates RPCs
+func WithJobToken(token []byte) Option {
+	return func(ic *initConfig) { ic.JobToken = token }
+}
+
+// WithLogger sends diagnostics to l
+func WithLogger(l *slog.Logger) Option {
+	return func(ic *initConfig) {
+		ic.Logger = l
+		ic.logLevel, ic.logFormat = "", ""
+	}
+}
+
+// WithDiscovery selects how ranks find each other, such as DiscoverySSM
+func WithDiscovery(method string) Option {
+	return func(ic *initConfig) { ic.discovery = method }
+}
+
+// WithDiscoveryTimeout bounds how long discovery waits for the whole job
+func WithDiscoveryTimeout(d time.Duration) Option {
+	return func(ic *initConfig) { ic.discoveryTimeout = d }
+}
+
+// WithReadyTimeout bounds the startup handshake, in which every rank waits
+// until all others are serving. A negative timeout skips the handshake.
+func WithReadyTimeout(d time.Duration) Option {
+	return func(ic *initConfig) { ic.readyTimeout = d }
+}
+
+// WithPollInterval sets the time between checks while discovery waits
+func WithPollInterval(d time.Duration) Option {
+	return func(ic *initConfig) { ic.pollInterval = d }
+}
+
+// configFromOptions works out the configuration of this rank. Settings are
+// taken, from lowest to highest precedence, from the defaults, the job
+// manifest of the discovery method, the config file, opts and the
+// environment.
+func configFromOptions(opts []Option) (*initConfig, error) {
+	var probe initConfig
+	for _, opt := range opts {
+		opt(&probe)
+	}
+	path := probe.configFile
+	if env := os.Getenv("MPI_CONFIG"); env != "" {
+		path = env
+	}
+	var file *FileConfig
+	if path != "" {
+		var err error
+		file, err = LoadConfigFile(path)
+		if err != nil {
+			return nil, withClass(MPI_ERR_ARG, err)
+		}
+	}
+
+	build := func(manifest *JobManifest, rank int) (*initConfig, error) {
+		ic := &initConfig{}
+		ic.Rank = rank
+		if manifest != nil {
+			manifest.apply(&ic.Config)
+		}
+		if file != nil {
+			if err := file.apply(ic); err != nil {
+				return nil, errorf(MPI_ERR_ARG, "%s: %v", path, err)
+			}
+		}
+		for _, opt := range opts {
+			opt(ic)
+		}
+		if err := ic.applyEnv(); err != nil {
+			return nil, withClass(MPI_ERR_ARG, err)
+		}
+		return ic, ic.validate()
+	}
+
+	// Discovery settings do not depend on the rank or the manifest
+	ic, err := build(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var layoutCfg Config
+	manifest, err := layout(&layoutCfg, ic.discovery, ic.discoveryTimeout, ic.pollInterval)
+	if err != nil {
+		return nil, withClass(MPI_ERR_OTHER, err)
+	}
+	ic, err = build(manifest, layoutCfg.Rank)
+	if err != nil {
+		layoutCfg.Bootstrap.Close()
+		return nil, err
+	}
+	ic.Rank, ic.Size, ic.Addresses, ic.Bootstrap = layoutCfg.Rank, layoutCfg.Size, layoutCfg.Addresses, layoutCfg.Bootstrap
+	return ic, nil
+}
+
+// applyEnv overrides ic with the MPI_* environment variables that are set
+func (ic *initConfig) applyEnv() error {
+	str := func(dst *string, name string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	integer := func(dst *int, name string) error {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s invalid: %v", name, err)
+			}
+			*dst = n
+		}
+		return nil
+	}
+	duration := func(dst *time.Duration, name string) error {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s invalid: %v", name, err)
+			}
+			*dst = d
+		}
+		return nil
+	}
+
+	str(&ic.discovery, "MPI_DISCOVERY")
+	str(&ic.Transport, "MPI_TRANSPORT")
+	str(&ic.SharedMemoryDir, "MPI_SHM_DIR")
+	str(&ic.logLevel, "MPI_LOG_LEVEL")
+	str(&ic.logFormat, "MPI_LOG_FORMAT")
+	if v := os.Getenv("MPI_SHM"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("MPI_SHM invalid: %v", err)
+		}
+		ic.DisableSharedMemory = !enabled
+	}
+	if v := os.Getenv("MPI_CODEC"); v != "" {
+		codec, err := codecByName(v)
+		if err != nil {
+			return fmt.Errorf("MPI_CODEC invalid: %v", err)
+		}
+		ic.Codec = codec
+	}
+	for _, err := range []error{
+		integer(&ic.MaxMessageSize, "MPI_MAX_MESSAGE_SIZE"),
+		integer(&ic.MaxDecompressedSize, "MPI_MAX_DECOMPRESSED_SIZE"),
+		duration(&ic.RecvTimeout, "MPI_RECV_TIMEOUT"),
+		duration(&ic.Keepalive.Time, "MPI_KEEPALIVE_TIME"),
+		duration(&ic.Keepalive.Timeout, "MPI_KEEPALIVE_TIMEOUT"),
+		integer(&ic.Retry.MaxAttempts, "MPI_RETRY_MAX_ATTEMPTS"),
+		duration(&ic.Retry.InitialBackoff, "MPI_RETRY_INITIAL_BACKOFF"),
+		duration(&ic.Retry.MaxBackoff, "MPI_RETRY_MAX_BACKOFF"),
+		duration(&ic.discoveryTimeout, "MPI_DISCOVERY_TIMEOUT"),
+		duration(&ic.pollInterval, "MPI_DISCOVERY_POLL_INTERVAL"),
+		duration(&ic.readyTimeout, "MPI_READY_TIMEOUT"),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if err = applyCompressionEnv(&ic.Config); err != nil {
+		return err
+	}
+	if ic.TLS, err = loadTLSConfig(ic.TLS, ic.Rank); err != nil {
+		return err
+	}
+	if token, err := loadJobToken(); err != nil {
+		return err
+	} else if token != nil {
+		ic.JobToken = token
+	}
+	return nil
+}
+
+// validate checks the settings and builds the logger they describe
+func (ic *initConfig) validate() error {
+	if ic.MaxMessageSize != 0 && ic.MaxMessageSize < minMaxMessageSize {
+		return errorf(MPI_ERR_ARG, "max message size %d is below the minimum of %d", ic.MaxMessageSize, minMaxMessageSize)
+	}
+	if ic.Keepalive.Timeout < 0 {
+		return errorf(MPI_ERR_ARG, "keepalive timeout must not be negative")
+	}
+	if ic.Retry.MaxAttempts < 0 || ic.Retry.InitialBackoff < 0 || ic.Retry.MaxBackoff < 0 {
+		return errorf(MPI_ERR_ARG, "retry attempts and backoffs must not be negative")
+	}
+	if ic.CompressionThreshold < 0 || ic.MaxDecompressedSize < 0 {
+		return errorf(MPI_ERR_ARG, "compression threshold and max decompressed size must not be negative")
+	}
+	if ic.discoveryTimeout < 0 || ic.pollInterval < 0 {
+		return errorf(MPI_ERR_ARG, "discovery timeout and poll interval must not be negative")
+	}
+	if ic.logLevel != "" || ic.logFormat != "" {
+		l, err := newLogger(ic.logLevel, ic.logFormat)
+		if err != nil {
+			return errorf(MPI_ERR_ARG, "%w", err)
+		}
+		ic.Logger = l
+	}
+	return nil
+}
+
+// newLogger returns a logger writing to standard error at level (debug,
+// info, warn or error; info if empty) in format (text or json; text if empty)
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("unknown log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
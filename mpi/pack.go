@@ -0,0 +1,53 @@
+package mpi
+
+// Packed buffers hold the data of each MPI_Pack call back to back, with every
+// scalar in little-endian order. The format carries no type information, so
+// it does not depend on gob registration; the receiver unpacks with the same
+// sequence of datatypes that the sender packed with.
+
+// MPI_Pack_size returns the number of bytes MPI_Pack needs for count items of dt
+func MPI_Pack_size(count int, dt *Datatype) (int, error) {
+	if err := dt.usable(); err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, errorf(MPI_ERR_COUNT, "invalid count %d", count)
+	}
+	return count * dt.size, nil
+}
+
+// MPI_Pack copies count items of dt from inbuf into outbuf starting at
+// *position, and advances *position past them
+func MPI_Pack(inbuf interface{}, count int, dt *Datatype, outbuf []byte, position *int) error {
+	need, err := MPI_Pack_size(count, dt)
+	if err != nil {
+		return err
+	}
+	pos := *position
+	if pos < 0 || pos+need > len(outbuf) {
+		return errorf(MPI_ERR_BUFFER, "packing %d bytes at position %d overflows a %d byte buffer", need, pos, len(outbuf))
+	}
+	if _, err := appendPacked(outbuf[pos:pos], inbuf, count, dt); err != nil {
+		return err
+	}
+	*position = pos + need
+	return nil
+}
+
+// MPI_Unpack copies count items of dt from inbuf starting at *position into
+// outbuf, and advances *position past them
+func MPI_Unpack(inbuf []byte, position *int, outbuf interface{}, count int, dt *Datatype) error {
+	need, err := MPI_Pack_size(count, dt)
+	if err != nil {
+		return err
+	}
+	pos := *position
+	if pos < 0 || pos+need > len(inbuf) {
+		return errorf(MPI_ERR_BUFFER, "unpacking %d bytes at position %d overruns a %d byte buffer", need, pos, len(inbuf))
+	}
+	if err := unpackDatatype(inbuf[pos:pos+need], outbuf, count, dt); err != nil {
+		return err
+	}
+	*position = pos + need
+	return nil
+}
@@ -0,0 +1,95 @@
+package mpi_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+)
+
+func TestPackUnpackAcrossRanks(t *testing.T) {
+	ints := []int32{1, -2, 3}
+	floats := []float64{0.5, -1.25}
+	forEachJob(t, func(comm *mpi.Comm) error {
+		if comm.Size() < 2 {
+			return nil
+		}
+		switch comm.Rank() {
+		case 0:
+			isize, err := mpi.MPI_Pack_size(len(ints), mpi.MPI_INT32)
+			if err != nil {
+				return err
+			}
+			fsize, err := mpi.MPI_Pack_size(len(floats), mpi.MPI_FLOAT64)
+			if err != nil {
+				return err
+			}
+			buf := make([]byte, isize+fsize)
+			pos := 0
+			if err := mpi.MPI_Pack(ints, len(ints), mpi.MPI_INT32, buf, &pos); err != nil {
+				return err
+			}
+			if err := mpi.MPI_Pack(floats, len(floats), mpi.MPI_FLOAT64, buf, &pos); err != nil {
+				return err
+			}
+			if pos != len(buf) {
+				return fmt.Errorf("packed %d bytes, MPI_Pack_size gave %d", pos, len(buf))
+			}
+			return comm.Send(buf, 1, 0)
+		case 1:
+			buf, err := comm.Recv(0, 0)
+			if err != nil {
+				return err
+			}
+			gotInts := make([]int32, len(ints))
+			gotFloats := make([]float64, len(floats))
+			pos := 0
+			if err := mpi.MPI_Unpack(buf, &pos, gotInts, len(ints), mpi.MPI_INT32); err != nil {
+				return err
+			}
+			if err := mpi.MPI_Unpack(buf, &pos, gotFloats, len(floats), mpi.MPI_FLOAT64); err != nil {
+				return err
+			}
+			if !slices.Equal(gotInts, ints) || !slices.Equal(gotFloats, floats) || pos != len(buf) {
+				return fmt.Errorf("unpacked %v and %v ending at %d of %d", gotInts, gotFloats, pos, len(buf))
+			}
+		}
+		return nil
+	})
+}
+
+func TestPackSizeChecks(t *testing.T) {
+	if n, err := mpi.MPI_Pack_size(3, mpi.MPI_FLOAT64); err != nil || n != 24 {
+		t.Errorf("MPI_Pack_size(3, MPI_FLOAT64) = %d, %v; want 24", n, err)
+	}
+	if _, err := mpi.MPI_Pack_size(-1, mpi.MPI_INT32); !errors.Is(err, mpi.MPI_ERR_COUNT) {
+		t.Errorf("negative count: got %v, want MPI_ERR_COUNT", err)
+	}
+
+	buf := make([]byte, 8)
+	pos := 4
+	err := mpi.MPI_Pack([]int32{1, 2}, 2, mpi.MPI_INT32, buf, &pos)
+	if !errors.Is(err, mpi.MPI_ERR_BUFFER) || pos != 4 {
+		t.Errorf("overflowing pack: got %v at position %d, want MPI_ERR_BUFFER at 4", err, pos)
+	}
+	pos = 0
+	if err := mpi.MPI_Pack([]int32{1, 2}, 2, mpi.MPI_INT32, buf, &pos); err != nil || pos != 8 {
+		t.Fatalf("pack: got %v at position %d", err, pos)
+	}
+	out := make([]int32, 3)
+	pos = 0
+	err = mpi.MPI_Unpack(buf, &pos, out, 3, mpi.MPI_INT32)
+	if !errors.Is(err, mpi.MPI_ERR_BUFFER) || pos != 0 {
+		t.Errorf("overrunning unpack: got %v at position %d, want MPI_ERR_BUFFER at 0", err, pos)
+	}
+
+	vec, err := mpi.MPI_Type_vector(2, 1, 2, mpi.MPI_INT32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mpi.MPI_Pack_size(1, vec); !errors.Is(err, mpi.MPI_ERR_TYPE) {
+		t.Errorf("uncommitted datatype: got %v, want MPI_ERR_TYPE", err)
+	}
+}
@@ -0,0 +1,57 @@
+package mpi
+
+import "context"
+
+// MPI_Send_init creates a persistent send of data to dest with tag. The
+// destination and tag are checked once; each MPI_Start sends the current
+// contents of data as a new message.
+func MPI_Send_init(data []byte, dest int, tag int) (*Request, error) {
+	return world.SendInit(data, dest, tag)
+}
+
+// MPI_Recv_init creates a persistent receive from source with tag. Each
+// completed MPI_Start copies the message into *buf, reusing its capacity.
+func MPI_Recv_init(buf *[]byte, source int, tag int) (*Request, error) {
+	return world.RecvInit(buf, source, tag)
+}
+
+// SendInit is MPI_Send_init on c
+func (c *Comm) SendInit(data []byte, dest int, tag int) (*Request, error) {
+	if err := c.checkDest(dest, tag); err != nil {
+		return nil, c.handleError(err)
+	}
+	return &Request{
+		comm:       c,
+		persistent: true,
+		op: func() error {
+			// A new message each time, since delivery numbers it
+			return c.send(context.Background(), "MPI_Start", data, dest, tag, SendMode_STANDARD)
+		},
+	}, nil
+}
+
+// RecvInit is MPI_Recv_init on c
+func (c *Comm) RecvInit(buf *[]byte, source int, tag int) (*Request, error) {
+	if buf == nil {
+		return nil, c.handleError(errorf(MPI_ERR_BUFFER, "receive buffer must not be nil"))
+	}
+	if err := c.checkSource(source, tag); err != nil {
+		return nil, c.handleError(err)
+	}
+	req := &RecvRequest{
+		Source: int32(source),
+		Tag:    int32(tag),
+	}
+	return &Request{
+		comm:       c,
+		persistent: true,
+		op: func() error {
+			msg, err := c.server.Recv(context.Background(), "MPI_Start", req)
+			if err != nil {
+				return err
+			}
+			*buf = append((*buf)[:0], msg.Data...)
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,124 @@
+package mpi
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// forEachTransport runs fn as a subtest with two communicators connected
+// over each transport
+func forEachTransport(t *testing.T, fn func(t *testing.T, comms []*Comm)) {
+	t.Run(TransportMemory, func(t *testing.T) { fn(t, newTestComms(t, 2, Config{})) })
+	forEachNetworkTransport(t, func(t *testing.T, transport string) {
+		fn(t, newNetworkComms(t, transport, []Config{{}, {}}))
+	})
+}
+
+func TestPersistentRoundTrip(t *testing.T) {
+	forEachTransport(t, func(t *testing.T, comms []*Comm) {
+		data := make([]byte, 8)
+		send, err := comms[0].SendInit(data, 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf []byte
+		recv, err := comms[1].RecvInit(&buf, 0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs := []*Request{recv, send}
+		for i := 0; i < 5; i++ {
+			// Each start sends what data holds at the time
+			copy(data, fmt.Sprintf("round %d", i))
+			if err := MPI_Startall(reqs); err != nil {
+				t.Fatal(err)
+			}
+			if err := MPI_Waitall(reqs); err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("round %d", i); string(buf[:len(want)]) != want {
+				t.Fatalf("round %d: received %q", i, buf)
+			}
+		}
+		if n := comms[1].Stats().DuplicatesDropped; n != 0 {
+			t.Errorf("%d restarts dropped as duplicates", n)
+		}
+	})
+}
+
+func TestPersistentSendsQueueInOrder(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	data := []byte{0}
+	send, err := comms[0].SendInit(data, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Restarted before any of its messages is received
+	for i := 0; i < 3; i++ {
+		data[0] = byte(i)
+		if err := MPI_Start(send); err != nil {
+			t.Fatal(err)
+		}
+		if err := MPI_Wait(send); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		got, err := comms[1].Recv(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0] != byte(i) {
+			t.Fatalf("message %d: received %v", i, got)
+		}
+	}
+}
+
+func TestPersistentStartWhileActive(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	var buf []byte
+	recv, err := comms[1].RecvInit(&buf, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MPI_Start(recv); err != nil {
+		t.Fatal(err)
+	}
+	if err := MPI_Start(recv); !errors.Is(err, MPI_ERR_REQUEST) {
+		t.Errorf("second start: got %v, want MPI_ERR_REQUEST", err)
+	}
+	if done, err := MPI_Test(recv); done || err != nil {
+		t.Fatalf("receive completed without a message: %v", err)
+	}
+	if err := comms[0].Send([]byte("late"), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := MPI_Wait(recv); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "late" {
+		t.Errorf("received %q", buf)
+	}
+	// Inactive again, so it may be started once more
+	if err := MPI_Start(recv); err != nil {
+		t.Fatal(err)
+	}
+	comms[0].Send([]byte("again"), 1, 0)
+	if err := MPI_Wait(recv); err != nil || string(buf) != "again" {
+		t.Errorf("restart: received %q, %v", buf, err)
+	}
+}
+
+func TestPersistentInitChecks(t *testing.T) {
+	c := newTestComms(t, 2, Config{})[0]
+	if _, err := c.SendInit(nil, 2, 0); !errors.Is(err, MPI_ERR_RANK) {
+		t.Errorf("send to missing rank: got %v, want MPI_ERR_RANK", err)
+	}
+	if _, err := c.SendInit(nil, 1, -1); !errors.Is(err, MPI_ERR_TAG) {
+		t.Errorf("send with negative tag: got %v, want MPI_ERR_TAG", err)
+	}
+	if _, err := c.RecvInit(nil, 1, 0); !errors.Is(err, MPI_ERR_BUFFER) {
+		t.Errorf("receive into nil: got %v, want MPI_ERR_BUFFER", err)
+	}
+}
@@ -0,0 +1,129 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tagReady marks the message a rank sends each peer during the startup
+// handshake to say it is serving. User tags are never negative.
+const tagReady = -3
+
+// DefaultReadyTimeout bounds the startup handshake of MPI_Init unless
+// configured otherwise
+const DefaultReadyTimeout = 2 * time.Minute
+
+const (
+	readyAttemptTimeout = 5 * time.Second // Per attempt to reach a peer
+	readyMaxBackoff     = time.Second
+)
+
+// WaitReady tells every other rank that c is serving and waits until all of
+// them have said the same and could be reached. MPI_Init calls it, so the
+// first message to a rank that starts late does not fail. Every rank of the
+// job must call it. If ctx ends first, the error names the missing ranks.
+func (c *Comm) WaitReady(ctx context.Context) error {
+	var peers []int
+	for r := 0; r < c.size; r++ {
+		if r != c.rank {
+			peers = append(peers, r)
+		}
+	}
+
+	sendErrs := make([]error, c.size) // Last failure reaching each peer, nil once reached
+	var wg sync.WaitGroup
+	for _, r := range peers {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			sendErrs[r] = c.sendReady(ctx, r)
+		}(r)
+	}
+	missing := c.server.waitReady(ctx, peers)
+	wg.Wait()
+
+	var problems []string
+	for _, r := range peers {
+		if sendErrs[r] != nil {
+			problems = append(problems, fmt.Sprintf("rank %d (%s) unreachable: %v", r, c.addresses[r], sendErrs[r]))
+		}
+	}
+	for _, r := range missing {
+		if sendErrs[r] == nil {
+			problems = append(problems, fmt.Sprintf("rank %d (%s) has not reported ready", r, c.addresses[r]))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errorf(MPI_ERR_PROC_FAILED, "ranks not ready: %s", strings.Join(problems, "; "))
+}
+
+// sendReady delivers the ready message to dest, retrying with backoff until
+// it succeeds or ctx ends. A failed connection is dropped, so the next
+// attempt dials afresh.
+func (c *Comm) sendReady(ctx context.Context, dest int) error {
+	backoff := 50 * time.Millisecond
+	for {
+		err := c.trySendReady(ctx, dest)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, readyMaxBackoff)
+	}
+}
+
+func (c *Comm) trySendReady(ctx context.Context, dest int) error {
+	conn, err := c.getConn(dest)
+	if err != nil {
+		return err
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, readyAttemptTimeout)
+	defer cancel()
+	err = conn.Send(attemptCtx, &Message{Source: int32(c.rank), Dest: int32(dest), Tag: tagReady})
+	if err != nil {
+		c.dropConn(dest, conn)
+	}
+	return err
+}
+
+// markReady records that rank has reported ready
+func (s *server) markReady(rank int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready[rank] = true
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+}
+
+// waitReady blocks until every rank in ranks has reported ready or ctx ends,
+// and returns the ranks that have not
+func (s *server) waitReady(ctx context.Context, ranks []int) []int {
+	for {
+		s.mu.Lock()
+		var missing []int
+		for _, r := range ranks {
+			if !s.ready[r] {
+				missing = append(missing, r)
+			}
+		}
+		arrived := s.arrived
+		s.mu.Unlock()
+		if len(missing) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return missing
+		case <-arrived:
+		}
+	}
+}
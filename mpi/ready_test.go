@@ -0,0 +1,93 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitReadyNamesMissingRanks(t *testing.T) {
+	// Ranks 0 and 1 take part, rank 2 serves but never reports ready, and
+	// rank 3 never starts
+	const n = 4
+	listeners := make([]net.Listener, n)
+	addresses := make(map[int]string, n)
+	for r := 0; r < n; r++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[r] = lis
+		addresses[r] = lis.Addr().String()
+	}
+	listeners[3].Close()
+	comms := make([]*Comm, 3)
+	for r := range comms {
+		c, err := NewComm(Config{
+			Rank: r, Size: n, Addresses: addresses, Listener: listeners[r],
+			Transport: TransportTCP, DisableSharedMemory: true,
+		})
+		if err != nil {
+			t.Fatalf("rank %d: %v", r, err)
+		}
+		t.Cleanup(c.Finalize)
+		comms[r] = c
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for r := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[r] = comms[r].WaitReady(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for r, err := range errs {
+		if !errors.Is(err, MPI_ERR_PROC_FAILED) {
+			t.Fatalf("rank %d: got %v, want MPI_ERR_PROC_FAILED", r, err)
+		}
+		msg := err.Error()
+		for _, want := range []string{
+			fmt.Sprintf("rank 2 (%s) has not reported ready", addresses[2]),
+			fmt.Sprintf("rank 3 (%s) unreachable", addresses[3]),
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("rank %d: %q does not say %q", r, msg, want)
+			}
+		}
+		if other := fmt.Sprintf("rank %d (", 1-r); strings.Contains(msg, other) {
+			t.Errorf("rank %d: %q names rank %d, which is ready", r, msg, 1-r)
+		}
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	comms := newNetworkComms(t, TransportTCP, []Config{{}, {}, {}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	errs := make([]error, len(comms))
+	var wg sync.WaitGroup
+	for r, c := range comms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[r] = c.WaitReady(ctx)
+		}()
+	}
+	wg.Wait()
+	for r, err := range errs {
+		if err != nil {
+			t.Errorf("rank %d: %v", r, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mpi
+
+import (
+	"sync"
+)
+
+// Request tracks a communication operation that runs in the background
+type Request struct {
+	mu         sync.Mutex
+	comm       *Comm // Its error handler sees the error of the operation
+	op         func() error
+	done       chan struct{} // Closed when the operation completes, nil while inactive
+	err        error
+	persistent bool
+}
+
+// newRequest starts op on c in the background and returns a request for it
+func newRequest(c *Comm, op func() error) *Request {
+	req := &Request{comm: c, op: op}
+	req.begin()
+	return req
+}
+
+func (req *Request) begin() {
+	done := make(chan struct{})
+	req.done = done
+	go func() {
+		err := req.op()
+		req.mu.Lock()
+		req.err = err
+		req.mu.Unlock()
+		close(done)
+	}()
+}
+
+// finish marks a completed request inactive and returns its result, after
+// passing an error to the error handler of its communicator
+func (req *Request) finish() error {
+	req.mu.Lock()
+	err := req.err
+	req.done = nil
+	req.err = nil
+	req.mu.Unlock()
+	return req.comm.handleError(err)
+}
+
+// MPI_Wait blocks until the request completes. Waiting on a nil or inactive
+// request returns immediately.
+func MPI_Wait(req *Request) error {
+	if req == nil {
+		return nil
+	}
+	req.mu.Lock()
+	done := req.done
+	req.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	return req.finish()
+}
+
+// MPI_Test reports whether the request has completed without blocking
+func MPI_Test(req *Request) (bool, error) {
+	if req == nil {
+		return true, nil
+	}
+	req.mu.Lock()
+	done := req.done
+	req.mu.Unlock()
+	if done == nil {
+		return true, nil
+	}
+	select {
+	case <-done:
+		return true, req.finish()
+	default:
+		return false, nil
+	}
+}
+
+// MPI_Waitall waits for every request and returns the first error encountered
+func MPI_Waitall(reqs []*Request) error {
+	var firstErr error
+	for _, req := range reqs {
+		if err := MPI_Wait(req); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// MPI_Start activates a persistent request created by MPI_Send_init or MPI_Recv_init
+func MPI_Start(req *Request) error {
+	if req == nil || !req.persistent {
+		// A nil request has no communicator; its error goes to world's handler
+		c := world
+		if req != nil {
+			c = req.comm
+		}
+		err := errorf(MPI_ERR_REQUEST, "MPI_Start requires a persistent request")
+		if c == nil {
+			return err
+		}
+		return c.handleError(err)
+	}
+	req.mu.Lock()
+	if req.done != nil {
+		req.mu.Unlock()
+		return req.comm.handleError(errorf(MPI_ERR_REQUEST, "persistent request is already active"))
+	}
+	req.begin()
+	req.mu.Unlock()
+	return nil
+}
+
+// MPI_Startall activates every persistent request in reqs
+func MPI_Startall(reqs []*Request) error {
+	for _, req := range reqs {
+		if err := MPI_Start(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
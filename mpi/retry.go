@@ -0,0 +1,248 @@
+package mpi
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults of RetryConfig
+const (
+	DefaultRetryAttempts   = 5
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 5 * time.Second
+)
+
+// seqMaxJump is how far ahead of the newest sequence number from a sender
+// the next one may be. Messages to one rank can overtake each other only by
+// as many as are in flight at once, so a larger jump means a corrupt message.
+const seqMaxJump = 1 << 20
+
+// seqMaxGaps is how many runs of missing sequence numbers are remembered per
+// sender. Most gaps close within moments; the ones that stay open are left by
+// messages that were withdrawn and never resent, and when there are too many
+// the oldest are given up on.
+const seqMaxGaps = 1024
+
+// RetryConfig sets how sends that fail with a transient network error, such
+// as a refused or broken connection, are retried. Every message carries a
+// sequence number, so a retry of a message that did arrive is dropped by the
+// receiver instead of being delivered twice.
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts per send, including the first,
+	// DefaultRetryAttempts if zero. 1 disables retries.
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// InitialBackoff is the wait before the first retry, DefaultRetryBackoff
+	// if zero. It doubles with every retry, with jitter.
+	InitialBackoff time.Duration `yaml:"initial_backoff" toml:"initial_backoff"`
+	// MaxBackoff caps the wait between retries, DefaultRetryMaxBackoff if zero
+	MaxBackoff time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+}
+
+// withDefaults fills in the settings left at zero
+func (rc RetryConfig) withDefaults() RetryConfig {
+	if rc.MaxAttempts == 0 {
+		rc.MaxAttempts = DefaultRetryAttempts
+	}
+	if rc.InitialBackoff == 0 {
+		rc.InitialBackoff = DefaultRetryBackoff
+	}
+	if rc.MaxBackoff == 0 {
+		rc.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	return rc
+}
+
+// isTransient reports whether err is a network failure that a later attempt
+// may not run into. Transports report those as codes.Unavailable.
+func isTransient(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// nextSeq returns the sequence number of the next message to dest
+func (c *Comm) nextSeq(dest int) uint64 {
+	return c.sendSeq[dest].Add(1)
+}
+
+// sendWithRetry sends msg to dest, retrying transient failures with
+// exponential backoff. A connection that failed is dropped, so the next
+// attempt dials afresh.
+func (c *Comm) sendWithRetry(ctx context.Context, dest int, msg *Message) error {
+	backoff := c.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := c.getConn(dest)
+		if err == nil {
+			err = conn.Send(ctx, msg)
+			if err != nil && isTransient(err) {
+				c.dropConn(dest, conn)
+			}
+		}
+		if err == nil || !isTransient(err) || attempt >= c.retry.MaxAttempts {
+			return err
+		}
+		// Full jitter keeps ranks that failed together from retrying together
+		wait := backoff/2 + rand.N(backoff/2+1)
+		c.logger.Debug("retrying send", "rank", c.rank, "dest", dest, "tag", msg.Tag, "attempt", attempt, "wait", wait, "err", err)
+		c.stats.sendRetries.Add(1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, c.retry.MaxBackoff)
+	}
+}
+
+// seqTracker remembers which sequence numbers have arrived from one rank:
+// every number up to newest except the gaps, which are few since messages
+// overtake each other only while in flight
+type seqTracker struct {
+	newest uint64              // Highest sequence number that arrived
+	gaps   seqRanges           // Numbers below newest that have not arrived
+	broken map[uint64]struct{} // Numbers whose stream broke after a receive took it
+}
+
+// arrived reports whether seq has arrived already
+func (t *seqTracker) arrived(seq uint64) bool {
+	return seq <= t.newest && !t.gaps.contains(seq)
+}
+
+// seqRange is the sequence numbers from first to last inclusive
+type seqRange struct {
+	first, last uint64
+}
+
+// seqRanges is a set of sequence numbers as ordered, disjoint, non-adjacent
+// ranges, so that a jump past many numbers costs a single range
+type seqRanges []seqRange
+
+// find returns the index of the first range that ends at or after seq
+func (rs seqRanges) find(seq uint64) int {
+	return sort.Search(len(rs), func(i int) bool { return rs[i].last >= seq })
+}
+
+func (rs seqRanges) contains(seq uint64) bool {
+	i := rs.find(seq)
+	return i < len(rs) && rs[i].first <= seq
+}
+
+// add adds the numbers from first to last, which must not be in rs yet, and
+// drops the oldest ranges beyond seqMaxGaps
+func (rs *seqRanges) add(first, last uint64) {
+	r := *rs
+	i := r.find(first)
+	switch {
+	case i > 0 && r[i-1].last+1 == first && i < len(r) && last+1 == r[i].first:
+		r[i-1].last = r[i].last
+		r = append(r[:i], r[i+1:]...)
+	case i > 0 && r[i-1].last+1 == first:
+		r[i-1].last = last
+	case i < len(r) && last+1 == r[i].first:
+		r[i].first = first
+	default:
+		r = slices.Insert(r, i, seqRange{first, last})
+	}
+	if n := len(r) - seqMaxGaps; n > 0 {
+		r = append(r[:0], r[n:]...)
+	}
+	*rs = r
+}
+
+// remove removes seq from rs
+func (rs *seqRanges) remove(seq uint64) {
+	r := *rs
+	i := r.find(seq)
+	if i == len(r) || r[i].first > seq {
+		return
+	}
+	switch g := r[i]; {
+	case g.first == g.last:
+		r = append(r[:i], r[i+1:]...)
+	case seq == g.first:
+		r[i].first++
+	case seq == g.last:
+		r[i].last--
+	default:
+		r[i].last = seq - 1
+		r = slices.Insert(r, i+1, seqRange{seq + 1, g.last})
+		if len(r) > seqMaxGaps {
+			r = append(r[:0], r[1:]...)
+		}
+	}
+	*rs = r
+}
+
+// checkDuplicate records the arrival of msg. It reports whether msg must not
+// be delivered, because it is a retry of a message that was already delivered
+// or because its sequence number is implausible, and in the latter case or
+// for a retry of a streamed message that broke after a receive took it, the
+// error to give the sender. Messages without a sequence number are never
+// duplicates.
+func (s *server) checkDuplicate(msg *Message) (bool, error) {
+	if msg.Seq == 0 {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.seqs[msg.Source]
+	if t == nil {
+		t = &seqTracker{broken: make(map[uint64]struct{})}
+		if msg.Seq > seqMaxJump {
+			// The sender was running before this rank restarted
+			t.newest = msg.Seq - 1
+		}
+		s.seqs[msg.Source] = t
+	}
+	if t.arrived(msg.Seq) {
+		s.comm.stats.duplicatesDropped.Add(1)
+		if _, ok := t.broken[msg.Seq]; ok {
+			return true, status.Errorf(codes.DataLoss,
+				"message %d from rank %d broke after it was received and cannot be resent", msg.Seq, msg.Source)
+		}
+		return true, nil
+	}
+	if msg.Seq > t.newest {
+		if msg.Seq-t.newest > seqMaxJump {
+			return true, status.Errorf(codes.InvalidArgument,
+				"sequence number %d from rank %d is too far ahead of %d", msg.Seq, msg.Source, t.newest)
+		}
+		if msg.Seq > t.newest+1 {
+			t.gaps.add(t.newest+1, msg.Seq-1)
+		}
+		t.newest = msg.Seq
+	} else {
+		t.gaps.remove(msg.Seq)
+	}
+	return false, nil
+}
+
+// forgetSeq undoes checkDuplicate for a message that was withdrawn before a
+// receive took it, so that a retry is delivered
+func (s *server) forgetSeq(msg *Message) {
+	if msg.Seq == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.seqs[msg.Source]; t != nil && t.arrived(msg.Seq) {
+		t.gaps.add(msg.Seq, msg.Seq)
+	}
+}
+
+// markBroken records that msg, which a receive has already taken, did not
+// arrive in full, so that a retry is refused instead of acknowledged
+func (s *server) markBroken(msg *Message) {
+	if msg.Seq == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t := s.seqs[msg.Source]; t != nil && t.arrived(msg.Seq) {
+		t.broken[msg.Seq] = struct{}{}
+	}
+}
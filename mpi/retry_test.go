@@ -0,0 +1,220 @@
+package mpi
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sendSeq sends a one-byte message with the given sequence number and tag
+// from comms[0] to comms[1], bypassing the numbering of Send
+func sendSeq(t *testing.T, comms []*Comm, seq uint64, tag int) {
+	t.Helper()
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Source: 0, Dest: 1, Tag: int32(tag), Data: []byte{byte(tag)}, Seq: seq}
+	if err := conn.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLateMessageFarBehindIsDelivered(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	sendSeq(t, comms, 10000, 1)
+	// 9999 behind the newest; a fixed window would take it for a retry
+	sendSeq(t, comms, 1, 2)
+	for _, tag := range []int{1, 2} {
+		if _, err := comms[1].Recv(0, tag); err != nil {
+			t.Fatalf("tag %d: %v", tag, err)
+		}
+	}
+	if n := comms[1].Stats().DuplicatesDropped; n != 0 {
+		t.Errorf("%d messages dropped as duplicates, want 0", n)
+	}
+}
+
+func TestDuplicateSeqIsDropped(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	sendSeq(t, comms, 3, 1)
+	sendSeq(t, comms, 1, 2)
+	// A retry of either must not be delivered again
+	sendSeq(t, comms, 3, 3)
+	sendSeq(t, comms, 1, 4)
+	for _, tag := range []int{1, 2} {
+		if _, err := comms[1].Recv(0, tag); err != nil {
+			t.Fatalf("tag %d: %v", tag, err)
+		}
+	}
+	if n := comms[1].Stats().DuplicatesDropped; n != 2 {
+		t.Errorf("%d messages dropped as duplicates, want 2", n)
+	}
+	// 2 is still missing, so it is not a duplicate
+	sendSeq(t, comms, 2, 5)
+	if _, err := comms[1].Recv(0, 5); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForgottenSeqIsDeliveredAgain(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	s := comms[1].server
+	msg := &Message{Source: 0, Dest: 1, Seq: 1}
+	if dup, err := s.checkDuplicate(msg); dup || err != nil {
+		t.Fatalf("first arrival: duplicate %v, error %v", dup, err)
+	}
+	s.forgetSeq(msg)
+	if dup, err := s.checkDuplicate(msg); dup || err != nil {
+		t.Fatalf("retry after withdrawal: duplicate %v, error %v", dup, err)
+	}
+	s.markBroken(msg)
+	if dup, err := s.checkDuplicate(msg); !dup || status.Code(err) != codes.DataLoss {
+		t.Fatalf("retry after break: duplicate %v, error %v", dup, err)
+	}
+}
+
+func TestImplausibleSeqIsRejected(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	s := comms[1].server
+	if dup, err := s.checkDuplicate(&Message{Source: 0, Seq: 1}); dup || err != nil {
+		t.Fatalf("first arrival: duplicate %v, error %v", dup, err)
+	}
+	dup, err := s.checkDuplicate(&Message{Source: 0, Seq: 2 + seqMaxJump})
+	if !dup || status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("jump of %d: duplicate %v, error %v", seqMaxJump+1, dup, err)
+	}
+}
+
+func TestSeqRangesMatchSet(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	var rs seqRanges
+	set := make(map[uint64]bool)
+	for i := 0; i < 5000; i++ {
+		seq := rng.Uint64N(200)
+		if set[seq] {
+			rs.remove(seq)
+			delete(set, seq)
+		} else {
+			last := seq
+			for last+1 < 200 && !set[last+1] && rng.IntN(2) == 0 {
+				last++
+			}
+			rs.add(seq, last)
+			for s := seq; s <= last; s++ {
+				set[s] = true
+			}
+		}
+		for seq := uint64(0); seq < 200; seq++ {
+			if rs.contains(seq) != set[seq] {
+				t.Fatalf("step %d: contains(%d) is %v, want %v", i, seq, rs.contains(seq), set[seq])
+			}
+		}
+		for j := 1; j < len(rs); j++ {
+			if rs[j-1].last+1 >= rs[j].first {
+				t.Fatalf("step %d: ranges %v and %v overlap or touch", i, rs[j-1], rs[j])
+			}
+		}
+	}
+}
+
+func TestSeqGapsAreBounded(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	s := comms[1].server
+	// A jump as far as allowed is remembered as a single gap
+	if dup, err := s.checkDuplicate(&Message{Source: 0, Seq: seqMaxJump}); dup || err != nil {
+		t.Fatalf("jump: duplicate %v, error %v", dup, err)
+	}
+	if gaps := s.seqs[0].gaps; len(gaps) != 1 || gaps[0] != (seqRange{1, seqMaxJump - 1}) {
+		t.Fatalf("gaps %v, want one of 1 to %d", gaps, seqMaxJump-1)
+	}
+	// Every other number after it leaves a gap; only the newest are kept
+	seq := uint64(seqMaxJump)
+	for i := 0; i < 2*seqMaxGaps; i++ {
+		seq += 2
+		if dup, err := s.checkDuplicate(&Message{Source: 0, Seq: seq}); dup || err != nil {
+			t.Fatalf("seq %d: duplicate %v, error %v", seq, dup, err)
+		}
+	}
+	if n := len(s.seqs[0].gaps); n != seqMaxGaps {
+		t.Errorf("%d gaps remembered, want %d", n, seqMaxGaps)
+	}
+	if dup, _ := s.checkDuplicate(&Message{Source: 0, Seq: seq - 1}); dup {
+		t.Error("a recent gap was taken for a duplicate")
+	}
+	if dup, _ := s.checkDuplicate(&Message{Source: 0, Seq: 1}); !dup {
+		t.Error("the oldest gap is still remembered")
+	}
+}
+
+// flakyStreamConn is a connection whose streams fail with err
+type flakyStreamConn struct {
+	Conn
+	err error
+}
+
+func (fc *flakyStreamConn) openStream(ctx context.Context, msg *Message, total int64) (MPIServer_SendStreamClient, error) {
+	return nil, fc.err
+}
+
+// completeStream returns a stream that has arrived in full with data
+func completeStream(data []byte) *incomingStream {
+	st := newIncomingStream(int64(len(data)))
+	st.add(data)
+	st.finish(nil)
+	return st
+}
+
+func TestForwardStreamRetriesTransientFailure(t *testing.T) {
+	comms := newTestComms(t, 2, Config{Retry: RetryConfig{InitialBackoff: 1}})
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stand in for a broken connection; the retry dials the real one again
+	comms[0].conns[1] = &flakyStreamConn{Conn: conn, err: status.Error(codes.Unavailable, "connection reset")}
+
+	data := []byte("forwarded payload")
+	rest, err := comms[0].forwardStream(context.Background(), &Message{}, completeStream(data), []int{1}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 0 {
+		t.Errorf("destinations left to the caller: %v", rest)
+	}
+	got, err := comms[1].Recv(0, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("received %q, want %q", got, data)
+	}
+	if n := comms[0].Stats().SendRetries; n != 1 {
+		t.Errorf("%d retries, want 1", n)
+	}
+	if newest := comms[1].server.seqs[0].newest; newest != 1 {
+		t.Errorf("forwarded message arrived with sequence number %d, want 1", newest)
+	}
+}
+
+func TestForwardStreamFailsPermanentError(t *testing.T) {
+	comms := newTestComms(t, 2, Config{})
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cause := status.Error(codes.PermissionDenied, "rejected")
+	comms[0].conns[1] = &flakyStreamConn{Conn: conn, err: cause}
+
+	_, err = comms[0].forwardStream(context.Background(), &Message{}, completeStream([]byte("x")), []int{1}, 7)
+	if !errors.Is(err, cause) {
+		t.Fatalf("got error %v, want %v", err, cause)
+	}
+	if n := comms[0].Stats().SendRetries; n != 0 {
+		t.Errorf("%d retries, want 0", n)
+	}
+}
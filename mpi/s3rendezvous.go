@@ -0,0 +1,338 @@
+package mpi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// JobManifest describes a whole job: its ranks and the settings they share.
+// Settings left empty keep their defaults.
+type JobManifest struct {
+	Size      int      `json:"size"`
+	Addresses []string `json:"addresses"` // host:port of every rank
+
+	Transport            string       `json:"transport,omitempty"`
+	SharedMemory         *bool        `json:"shared_memory,omitempty"`
+	Compression          string       `json:"compression,omitempty"` // gzip, snappy or zstd
+	CompressionThreshold int          `json:"compression_threshold,omitempty"`
+	JobToken             string       `json:"job_token,omitempty"`
+	TLS                  *ManifestTLS `json:"tls,omitempty"`
+}
+
+// ManifestTLS is the TLS material of a job, in PEM form
+type ManifestTLS struct {
+	Mode  string   `json:"mode"`
+	CA    string   `json:"ca"`
+	Certs []string `json:"certs"` // Certificate of each rank
+	Keys  []string `json:"keys"`  // Private key of each rank
+}
+
+func (m *JobManifest) validate() error {
+	if m.Size < 1 {
+		return fmt.Errorf("manifest has invalid size %d", m.Size)
+	}
+	if len(m.Addresses) != m.Size {
+		return fmt.Errorf("manifest has %d addresses for %d ranks", len(m.Addresses), m.Size)
+	}
+	if m.Compression != "" {
+		if _, ok := Compression_value[strings.ToUpper(m.Compression)]; !ok {
+			return fmt.Errorf("manifest has unknown compression %q", m.Compression)
+		}
+	}
+	if m.TLS != nil && m.TLS.Mode != TLSModeOff && m.TLS.Mode != "" {
+		if len(m.TLS.Certs) != m.Size || len(m.TLS.Keys) != m.Size {
+			return fmt.Errorf("manifest needs a TLS certificate and key for each of %d ranks", m.Size)
+		}
+	}
+	return nil
+}
+
+// addressMap returns the addresses of m by rank
+func (m *JobManifest) addressMap() map[int]string {
+	addresses := make(map[int]string, m.Size)
+	for r, addr := range m.Addresses {
+		addresses[r] = addr
+	}
+	return addresses
+}
+
+// apply copies the shared settings of m into cfg
+func (m *JobManifest) apply(cfg *Config) {
+	if m.Transport != "" {
+		cfg.Transport = m.Transport
+	}
+	if m.SharedMemory != nil {
+		cfg.DisableSharedMemory = !*m.SharedMemory
+	}
+	if m.Compression != "" {
+		cfg.Compression = Compression(Compression_value[strings.ToUpper(m.Compression)])
+		cfg.CompressionThreshold = DefaultCompressionThreshold
+		if m.CompressionThreshold > 0 {
+			cfg.CompressionThreshold = m.CompressionThreshold
+		}
+	}
+	if m.JobToken != "" {
+		cfg.JobToken = []byte(m.JobToken)
+	}
+	if m.TLS != nil {
+		cfg.TLS = TLSConfig{Mode: m.TLS.Mode, CAPEM: []byte(m.TLS.CA)}
+		if cfg.Rank < len(m.TLS.Certs) && cfg.Rank < len(m.TLS.Keys) {
+			cfg.TLS.CertPEM = []byte(m.TLS.Certs[cfg.Rank])
+			cfg.TLS.KeyPEM = []byte(m.TLS.Keys[cfg.Rank])
+		}
+	}
+}
+
+// S3API is the part of the S3 client that S3Rendezvous uses, so tests can
+// substitute a fake
+type S3API interface {
+	s3.ListObjectsV2APIClient
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// S3Rendezvous reads a JobManifest from an S3 object and lets the ranks tell
+// each other they have started, through marker objects named ready/<rank>
+// next to the manifest. The S3 bootstrap keeps its values and fence markers
+// there too. Objects left by an earlier job with the same manifest location
+// count as current, so remove them between runs.
+//
+// MPI_DISCOVERY=s3 reads the manifest named by MPI_S3_MANIFEST
+// (s3://bucket/key) and finds the calling rank by its IP address unless
+// MPI_RANK is set. Environment variables take precedence over the settings
+// in the manifest. MPI_S3_ENDPOINT points at MinIO or another S3-compatible
+// store, with path-style addressing.
+type S3Rendezvous struct {
+	Client S3API
+	Bucket string
+	Key    string // Key of the manifest
+	// PollInterval is the time between checks while waiting for the other
+	// ranks, DefaultPollInterval if zero
+	PollInterval time.Duration
+}
+
+// Manifest reads and checks the job manifest
+func (s *S3Rendezvous) Manifest(ctx context.Context) (*JobManifest, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.Bucket), Key: aws.String(s.Key)})
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest s3://%s/%s: %v", s.Bucket, s.Key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest s3://%s/%s: %v", s.Bucket, s.Key, err)
+	}
+	m := &JobManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest s3://%s/%s: %v", s.Bucket, s.Key, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Ready publishes the marker of rank and waits until all size ranks have
+// published theirs
+func (s *S3Rendezvous) Ready(ctx context.Context, rank, size int) error {
+	return s.barrier(ctx, "ready", rank, size, "become ready")
+}
+
+// barrier writes the marker dir/<rank> next to the manifest and waits until
+// all size ranks have written theirs. what describes the wait in the timeout
+// error.
+func (s *S3Rendezvous) barrier(ctx context.Context, dir string, rank, size int, what string) error {
+	prefix := s.prefix(dir)
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(prefix + strconv.Itoa(rank)),
+		Body:   bytes.NewReader([]byte(time.Now().UTC().Format(time.RFC3339))),
+	})
+	if err != nil {
+		return fmt.Errorf("error writing marker %s%d: %v", prefix, rank, err)
+	}
+
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	ready := make(map[int]bool) // As of the last complete listing
+	for {
+		listed, err := s.markers(ctx, prefix, size)
+		switch {
+		case err == nil:
+			ready = listed
+		case ctx.Err() == nil:
+			return err
+		}
+		if len(ready) == size {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			var missing []string
+			for r := 0; r < size; r++ {
+				if !ready[r] {
+					missing = append(missing, strconv.Itoa(r))
+				}
+			}
+			return fmt.Errorf("timed out waiting for ranks %s to %s", strings.Join(missing, ", "), what)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// markers returns the ranks below size that have a marker under prefix
+func (s *S3Rendezvous) markers(ctx context.Context, prefix string, size int) (map[int]bool, error) {
+	ready := make(map[int]bool)
+	pages := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing markers under %s: %v", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			r, err := strconv.Atoi(strings.TrimPrefix(aws.ToString(obj.Key), prefix))
+			if err == nil && r >= 0 && r < size {
+				ready[r] = true
+			}
+		}
+	}
+	return ready, nil
+}
+
+// prefix returns the key prefix of dir, which sits next to the manifest
+func (s *S3Rendezvous) prefix(dir string) string {
+	if i := strings.LastIndex(s.Key, "/"); i >= 0 {
+		return s.Key[:i+1] + dir + "/"
+	}
+	return dir + "/"
+}
+
+// newS3Bootstrap reads the manifest named by MPI_S3_MANIFEST
+// (s3://bucket/key), finds this rank in it and waits for the others. The rank
+// is MPI_RANK, or else the one whose address is on this host. The client uses
+// the usual AWS configuration, and MPI_S3_ENDPOINT with path-style addressing
+// if set.
+func newS3Bootstrap(ctx context.Context, cfg BootstrapConfig) (Bootstrap, error) {
+	location := os.Getenv("MPI_S3_MANIFEST")
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !strings.HasPrefix(location, "s3://") || !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("MPI_S3_MANIFEST not set or not of the form s3://bucket/key: %q", location)
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if endpoint := os.Getenv("MPI_S3_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	s := &S3Rendezvous{Client: client, Bucket: bucket, Key: key, PollInterval: cfg.PollInterval}
+	m, err := s.Manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addresses := m.addressMap()
+	rank, err := rankFromEnv(addresses)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Ready(ctx, rank, m.Size); err != nil {
+		return nil, err
+	}
+	return &s3Bootstrap{s: s, m: m, rank: rank, addresses: addresses}, nil
+}
+
+// s3Bootstrap exchanges values through objects next to the manifest:
+// kv/<rank>/<key> holds a value and fence/<n>/<rank> marks that a rank
+// reached its n-th fence
+type s3Bootstrap struct {
+	s         *S3Rendezvous
+	m         *JobManifest
+	rank      int
+	addresses map[int]string
+	fences    int
+}
+
+func (b *s3Bootstrap) Rank() int { return b.rank }
+func (b *s3Bootstrap) Size() int { return b.m.Size }
+
+func (b *s3Bootstrap) Put(ctx context.Context, key string, value []byte) error {
+	if err := checkBootstrapKey(key); err != nil {
+		return err
+	}
+	_, err := b.s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.s.Bucket),
+		Key:    aws.String(b.key(b.rank, key)),
+		Body:   bytes.NewReader(value),
+	})
+	if err != nil {
+		return fmt.Errorf("error putting %s in S3: %v", key, err)
+	}
+	return nil
+}
+
+func (b *s3Bootstrap) Get(ctx context.Context, rank int, key string) ([]byte, error) {
+	if rank < 0 || rank >= b.m.Size {
+		return nil, fmt.Errorf("rank %d out of range for a job of size %d", rank, b.m.Size)
+	}
+	if key == BootstrapAddressKey {
+		return []byte(b.addresses[rank]), nil
+	}
+	if err := checkBootstrapKey(key); err != nil {
+		return nil, err
+	}
+	out, err := b.s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.s.Bucket),
+		Key:    aws.String(b.key(rank, key)),
+	})
+	var noKey *types.NoSuchKey
+	if errors.As(err, &noKey) {
+		return nil, fmt.Errorf("%w: %s of rank %d", ErrBootstrapKeyNotFound, key, rank)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s of rank %d from S3: %v", key, rank, err)
+	}
+	defer out.Body.Close()
+	value, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s of rank %d from S3: %v", key, rank, err)
+	}
+	return value, nil
+}
+
+func (b *s3Bootstrap) Fence(ctx context.Context) error {
+	what := "reach fence " + strconv.Itoa(b.fences)
+	if err := b.s.barrier(ctx, fenceKey(b.fences), b.rank, b.m.Size, what); err != nil {
+		return err
+	}
+	b.fences++
+	return nil
+}
+
+func (b *s3Bootstrap) Close() error { return nil }
+
+func (b *s3Bootstrap) manifest() *JobManifest { return b.m }
+
+func (b *s3Bootstrap) key(rank int, key string) string {
+	return b.s.prefix("kv/"+strconv.Itoa(rank)) + key
+}
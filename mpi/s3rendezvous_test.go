@@ -0,0 +1,217 @@
+package mpi
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3StandIn serves the S3 calls that S3Rendezvous makes, with path-style
+// addressing, from an in-memory bucket
+type s3StandIn struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type s3ListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Name     string
+	Prefix   string
+	KeyCount int
+	Contents []struct{ Key string }
+}
+
+func newS3StandIn(t *testing.T, bucket string) (*s3StandIn, *httptest.Server) {
+	t.Helper()
+	s := &s3StandIn{bucket: bucket, objects: make(map[string][]byte)}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return s, srv
+}
+
+func (s *s3StandIn) put(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = []byte(value)
+}
+
+// keys returns the keys of the objects in the bucket, in order
+func (s *s3StandIn) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != s.bucket {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		prefix := r.URL.Query().Get("prefix")
+		result := s3ListResult{Name: bucket, Prefix: prefix}
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, struct{ Key string }{k})
+		}
+		result.KeyCount = len(keys)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = data
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Manifest(t *testing.T) {
+	standIn, srv := newS3StandIn(t, "jobs")
+	t.Setenv("MPI_S3_ENDPOINT", srv.URL)
+	for _, tc := range []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"valid", `{"size":1,"addresses":["10.0.0.1:5000"],"compression":"zstd"}`, ""},
+		{"not JSON", `size: 2`, "error parsing manifest"},
+		{"invalid size", `{"size":0,"addresses":[]}`, "invalid size 0"},
+		{"addresses missing", `{"size":2,"addresses":["10.0.0.1:5000"]}`, "1 addresses for 2 ranks"},
+		{"unknown compression", `{"size":1,"addresses":["10.0.0.1:5000"],"compression":"lz4"}`, `unknown compression "lz4"`},
+		{"TLS keys missing", `{"size":1,"addresses":["10.0.0.1:5000"],"tls":{"mode":"mutual","certs":["x"]}}`, "TLS certificate and key"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			standIn.put("job-1/manifest.json", tc.manifest)
+			t.Setenv("MPI_S3_MANIFEST", "s3://jobs/job-1/manifest.json")
+			t.Setenv("MPI_RANK", "0")
+			b, err := newS3Bootstrap(context.Background(), BootstrapConfig{PollInterval: time.Millisecond})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err == nil {
+				b.Close()
+			}
+		})
+	}
+}
+
+func TestS3ManifestLocation(t *testing.T) {
+	for _, location := range []string{"", "jobs/manifest.json", "s3://jobs", "s3:///manifest.json"} {
+		t.Setenv("MPI_S3_MANIFEST", location)
+		if _, err := newS3Bootstrap(context.Background(), BootstrapConfig{}); err == nil || !strings.Contains(err.Error(), "MPI_S3_MANIFEST") {
+			t.Errorf("manifest at %q: got %v", location, err)
+		}
+	}
+}
+
+func TestS3Bootstrap(t *testing.T) {
+	standIn, srv := newS3StandIn(t, "jobs")
+	t.Setenv("MPI_S3_ENDPOINT", srv.URL)
+	t.Setenv("MPI_S3_MANIFEST", "s3://jobs/job-1/manifest.json")
+	t.Setenv("MPI_RANK", "1")
+	standIn.put("job-1/manifest.json", `{"size":2,"addresses":["10.0.0.1:5000","10.0.0.2:5000"],"transport":"tcp"}`)
+	// Rank 0 is already up
+	standIn.put("job-1/ready/0", "")
+
+	ctx := context.Background()
+	b, err := newS3Bootstrap(ctx, BootstrapConfig{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if b.Rank() != 1 || b.Size() != 2 {
+		t.Fatalf("rank %d of %d, want 1 of 2", b.Rank(), b.Size())
+	}
+	if keys := standIn.keys(); !slices.Contains(keys, "job-1/ready/1") {
+		t.Errorf("no readiness marker for rank 1 among %v", keys)
+	}
+	if m := b.(*s3Bootstrap).manifest(); m.Transport != TransportTCP {
+		t.Errorf("manifest transport %q", m.Transport)
+	}
+
+	if err := b.Put(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.Get(ctx, 1, "key"); err != nil || string(got) != "value" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := b.Get(ctx, 0, "key"); err == nil || !strings.Contains(err.Error(), ErrBootstrapKeyNotFound.Error()) {
+		t.Errorf("value rank 0 never put: got %v", err)
+	}
+	if got, err := b.Get(ctx, 0, BootstrapAddressKey); err != nil || string(got) != "10.0.0.1:5000" {
+		t.Errorf("address of rank 0: %q, %v", got, err)
+	}
+	standIn.put("job-1/fence/0/0", "")
+	if err := b.Fence(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3ReadyNamesMissingRanks(t *testing.T) {
+	standIn, srv := newS3StandIn(t, "jobs")
+	t.Setenv("MPI_S3_ENDPOINT", srv.URL)
+	t.Setenv("MPI_S3_MANIFEST", "s3://jobs/job-1/manifest.json")
+	t.Setenv("MPI_RANK", "0")
+	standIn.put("job-1/manifest.json", `{"size":4,"addresses":["a:1","b:1","c:1","d:1"]}`)
+	standIn.put("job-1/ready/2", "")
+	// A marker of another job under a similar prefix does not count
+	standIn.put("job-10/ready/1", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := newS3Bootstrap(ctx, BootstrapConfig{PollInterval: time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "ranks 1, 3 to become ready") {
+		t.Fatalf("got %v, want a timeout naming ranks 1 and 3", err)
+	}
+	want := []string{"job-1/manifest.json", "job-1/ready/0", "job-1/ready/2", "job-10/ready/1"}
+	if keys := standIn.keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("objects %v, want %v", keys, want)
+	}
+}
@@ -2,86 +2,388 @@ package mpi
 
 import (
 	"context"
-	"errors"
 	"sync"
 	"time"
-)
 
-const (
-	TagBroadcast = 0
-	TagReduce    = 1
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// server is the inbox of a communicator: it queues incoming messages until a
+// receive matches them
 type server struct {
-	UnimplementedMPIServerServer
+	comm     *Comm
 	mu       sync.Mutex
-	messages map[int32][]*Message // Keyed by tag
+	messages map[int32][]*Message         // Keyed by tag
+	matched  map[*Message]chan struct{}   // Synchronous sends waiting to be matched
+	streams  map[*Message]*incomingStream // Streamed messages, possibly still arriving
+	posted   []*RecvRequest               // Receives currently waiting for a message
+	arrived  chan struct{}                // Closed and replaced whenever a message is queued
+	ready    map[int]bool                 // Ranks that have reported ready, see WaitReady
+	seqs     map[int32]*seqTracker        // Sequence numbers seen, by source
+}
+
+// Deliver implements Inbox
+func (s *server) Deliver(ctx context.Context, peer int, msg *Message) error {
+	return s.deliverStream(ctx, peer, msg, nil, nil)
 }
 
-func (s *server) Send(ctx context.Context, msg *Message) (*Empty, error) {
+// deliverStream queues a message whose payload may still be arriving in
+// stream; receive is called after queueing to take in the rest of it. Both
+// are nil for complete messages.
+func (s *server) deliverStream(ctx context.Context, peer int, msg *Message, stream *incomingStream, receive func() error) error {
+	if err := checkSource(msg, peer); err != nil {
+		return err
+	}
+	if msg.Tag == tagReady {
+		if msg.Source < 0 || int(msg.Source) >= s.comm.size {
+			return status.Error(codes.InvalidArgument, "malformed ready message")
+		}
+		s.markReady(int(msg.Source))
+		return nil
+	}
+	if dup, err := s.checkDuplicate(msg); dup {
+		// A retry of a message that arrived, whose acknowledgement was lost
+		return err
+	}
+	matched, err := s.enqueue(msg, stream)
+	if err != nil {
+		s.forgetSeq(msg)
+		return err
+	}
+	if receive != nil {
+		if err := receive(); err != nil {
+			// Let the sender retry unless a receive already has the message
+			if s.withdraw(msg) {
+				s.forgetSeq(msg)
+			} else {
+				s.markBroken(msg)
+			}
+			return err
+		}
+	}
+	return s.waitMatched(ctx, msg, matched)
+}
+
+// enqueue makes msg available to receives. For synchronous sends it returns a
+// channel that is closed once a receive has matched the message.
+func (s *server) enqueue(msg *Message, stream *incomingStream) (chan struct{}, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	// A ready send is only valid if the matching receive is already posted
+	if msg.Mode == SendMode_READY && !s.isPosted(msg) {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"ready send from rank %d with tag %d has no matching receive posted", msg.Source, msg.Tag)
+	}
 	s.messages[msg.Tag] = append(s.messages[msg.Tag], msg)
-	return &Empty{}, nil
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+	s.comm.stats.messagesReceived.Add(1)
+	if stream != nil {
+		s.streams[msg] = stream
+		s.comm.stats.bytesReceived.Add(stream.total)
+	} else {
+		s.comm.stats.bytesReceived.Add(int64(len(msg.Data)))
+	}
+	if msg.Mode != SendMode_SYNCHRONOUS {
+		return nil, nil
+	}
+	matched := make(chan struct{})
+	s.matched[msg] = matched
+	return matched, nil
+}
+
+// waitMatched blocks until a synchronous send has been matched, withdrawing
+// the message if ctx ends first. It returns immediately when matched is nil.
+func (s *server) waitMatched(ctx context.Context, msg *Message, matched chan struct{}) error {
+	if matched == nil {
+		return nil
+	}
+	select {
+	case <-matched:
+		return nil
+	case <-ctx.Done():
+		if !s.withdraw(msg) {
+			// Matched while we were giving up
+			return nil
+		}
+		s.forgetSeq(msg)
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// withdraw removes msg from the queue. It reports false if a receive has
+// already taken it.
+func (s *server) withdraw(msg *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := s.messages[msg.Tag]
+	for i, m := range msgs {
+		if m == msg {
+			s.messages[msg.Tag] = append(msgs[:i], msgs[i+1:]...)
+			delete(s.matched, msg)
+			delete(s.streams, msg)
+			return true
+		}
+	}
+	return false
 }
 
-func (s *server) Recv(ctx context.Context, req *RecvRequest) (*Message, error) {
-	timeout := time.After(30 * time.Second)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+// Recv waits for a message that satisfies req on behalf of op, which names the
+// call in errors
+func (s *server) Recv(ctx context.Context, op string, req *RecvRequest) (*Message, error) {
+	msg, stream, err := s.match(ctx, op, req)
+	if err != nil {
+		return nil, err
+	}
+	if stream != nil {
+		// Hand out streamed messages only once they have fully arrived
+		data, err := stream.wait()
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = data
+	}
+	if err := s.comm.decompressMessage(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// match waits for a message that satisfies req and removes it from the queue.
+// If the message is being streamed, its stream is returned and msg.Data is
+// empty; chunks can be consumed from the stream as they arrive. A deadline on
+// ctx replaces the receive timeout. Giving up returns a *TimeoutError for op.
+func (s *server) match(ctx context.Context, op string, req *RecvRequest) (*Message, *incomingStream, error) {
+	start := time.Now()
+	s.mu.Lock()
+	s.posted = append(s.posted, req)
+	s.mu.Unlock()
+	defer s.unpost(req)
+
+	var timeout <-chan time.Time // Never fires if the timeout is disabled
+	_, hasDeadline := ctx.Deadline()
+	if !hasDeadline && s.comm.recvTimeout > 0 && ctx.Value(noRecvTimeoutKey{}) == nil {
+		timer := time.NewTimer(s.comm.recvTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 
 	for {
+		s.mu.Lock()
+		if msg, stream, ok := s.take(req); ok {
+			s.mu.Unlock()
+			return msg, stream, nil
+		}
+		arrived := s.arrived
+		s.mu.Unlock()
+
 		select {
 		case <-timeout:
-			return nil, errors.New("receive timed out")
-		case <-ticker.C:
-			s.mu.Lock()
-			for tag, msgs := range s.messages {
-				// Check if request allows this tag
-				if req.Tag != -1 && req.Tag != tag {
-					continue
-				}
-
-				for i, msg := range msgs {
-					// Check if request allows this source
-					if req.Source != -1 && req.Source != msg.Source {
-						continue
-					}
-
-					// Found matching message
-					s.messages[tag] = append(msgs[:i], msgs[i+1:]...)
-					s.mu.Unlock()
-					return msg, nil
-				}
+			return nil, nil, s.comm.waitError(op, int(req.Source), int(req.Tag), start, context.DeadlineExceeded)
+		case <-ctx.Done():
+			return nil, nil, s.comm.waitError(op, int(req.Source), int(req.Tag), start, ctx.Err())
+		case <-arrived:
+		}
+	}
+}
+
+// take removes the first queued message that satisfies req. Callers must hold s.mu.
+func (s *server) take(req *RecvRequest) (*Message, *incomingStream, bool) {
+	for tag, msgs := range s.messages {
+		// Check if request allows this tag; only internal receives use
+		// negative tags, and any tag never matches them
+		if req.Tag != tag && (req.Tag != -1 || tag < 0) {
+			continue
+		}
+
+		for i, msg := range msgs {
+			// Check if request allows this source
+			if req.Source != -1 && req.Source != msg.Source {
+				continue
 			}
-			s.mu.Unlock()
+
+			// Found matching message
+			s.messages[tag] = append(msgs[:i], msgs[i+1:]...)
+			if matched, ok := s.matched[msg]; ok {
+				delete(s.matched, msg)
+				close(matched)
+			}
+			stream := s.streams[msg]
+			delete(s.streams, msg)
+			return msg, stream, true
+		}
+	}
+	return nil, nil, false
+}
+
+// isPosted reports whether a waiting receive would match msg. Callers must hold s.mu.
+func (s *server) isPosted(msg *Message) bool {
+	for _, req := range s.posted {
+		if (req.Source == -1 || req.Source == msg.Source) && (req.Tag == msg.Tag || req.Tag == -1 && msg.Tag >= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) unpost(req *RecvRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.posted {
+		if r == req {
+			s.posted = append(s.posted[:i], s.posted[i+1:]...)
+			return
 		}
 	}
 }
 
 // MPI_Send sends data to a specified destination with a tag
 func MPI_Send(data []byte, dest int, tag int) error {
-	client, err := getClient(dest)
-	if err != nil {
-		return err
+	return world.Send(data, dest, tag)
+}
+
+// MPI_SendCtx is MPI_Send that gives up when ctx ends, with a *TimeoutError
+func MPI_SendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	return world.SendCtx(ctx, data, dest, tag)
+}
+
+// MPI_Ssend sends data to a specified destination with a tag and does not
+// return until the destination has matched it with a receive
+func MPI_Ssend(data []byte, dest int, tag int) error {
+	return world.Ssend(data, dest, tag)
+}
+
+// MPI_SsendCtx is MPI_Ssend that gives up when ctx ends, with a
+// *TimeoutError. The message is withdrawn if it has not been matched by then.
+func MPI_SsendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	return world.SsendCtx(ctx, data, dest, tag)
+}
+
+// MPI_Rsend sends data to a specified destination with a tag. The matching
+// receive must already be posted at the destination, otherwise the send fails.
+func MPI_Rsend(data []byte, dest int, tag int) error {
+	return world.Rsend(data, dest, tag)
+}
+
+// MPI_RsendCtx is MPI_Rsend that gives up when ctx ends, with a *TimeoutError
+func MPI_RsendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	return world.RsendCtx(ctx, data, dest, tag)
+}
+
+// MPI_Recv receives data from a specified source with a tag
+func MPI_Recv(source int, tag int) ([]byte, error) {
+	return world.Recv(source, tag)
+}
+
+// MPI_RecvCtx is MPI_Recv that gives up when ctx ends, with a *TimeoutError.
+// If ctx has a deadline, it replaces the receive timeout.
+func MPI_RecvCtx(ctx context.Context, source int, tag int) ([]byte, error) {
+	return world.RecvCtx(ctx, source, tag)
+}
+
+// Send is MPI_Send on c
+func (c *Comm) Send(data []byte, dest int, tag int) error {
+	return c.SendCtx(context.Background(), data, dest, tag)
+}
+
+// SendCtx is MPI_SendCtx on c
+func (c *Comm) SendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	if err := c.checkDest(dest, tag); err != nil {
+		return c.handleError(err)
 	}
+	return c.handleError(c.send(ctx, "MPI_Send", data, dest, tag, SendMode_STANDARD))
+}
+
+// Ssend is MPI_Ssend on c
+func (c *Comm) Ssend(data []byte, dest int, tag int) error {
+	return c.SsendCtx(context.Background(), data, dest, tag)
+}
+
+// SsendCtx is MPI_SsendCtx on c
+func (c *Comm) SsendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	if err := c.checkDest(dest, tag); err != nil {
+		return c.handleError(err)
+	}
+	return c.handleError(c.send(ctx, "MPI_Ssend", data, dest, tag, SendMode_SYNCHRONOUS))
+}
+
+// Rsend is MPI_Rsend on c
+func (c *Comm) Rsend(data []byte, dest int, tag int) error {
+	return c.RsendCtx(context.Background(), data, dest, tag)
+}
+
+// RsendCtx is MPI_RsendCtx on c
+func (c *Comm) RsendCtx(ctx context.Context, data []byte, dest int, tag int) error {
+	if err := c.checkDest(dest, tag); err != nil {
+		return c.handleError(err)
+	}
+	return c.handleError(c.send(ctx, "MPI_Rsend", data, dest, tag, SendMode_READY))
+}
+
+// send sends data to dest on behalf of op, which names the call in errors
+func (c *Comm) send(ctx context.Context, op string, data []byte, dest int, tag int, mode SendMode) error {
 	msg := &Message{
-		Source: int32(rank),
+		Source: int32(c.rank),
 		Dest:   int32(dest),
 		Tag:    int32(tag),
 		Data:   data,
+		Mode:   mode,
 	}
-	_, err = client.Send(context.Background(), msg)
-	return err
+	return c.deliver(ctx, op, dest, msg)
 }
 
-// MPI_Recv receives data from a specified source with a tag
-func MPI_Recv(source int, tag int) ([]byte, error) {
+// deliver sends msg to dest, compressing the payload first if compression is
+// enabled, and numbers it so that retries after transient failures are not
+// delivered twice. Buffered sends still queued for dest go first. If ctx
+// ends first, it returns a *TimeoutError for op; if dest stays unreachable,
+// an MPI_ERR_PROC_FAILED error.
+func (c *Comm) deliver(ctx context.Context, op string, dest int, msg *Message) error {
+	start := time.Now()
+	if err := c.drainBuffered(ctx, dest); err != nil {
+		return c.waitError(op, dest, int(msg.Tag), start, err)
+	}
+	return c.deliverNow(ctx, op, dest, msg)
+}
+
+// deliverNow is deliver without waiting for buffered sends
+func (c *Comm) deliverNow(ctx context.Context, op string, dest int, msg *Message) error {
+	start := time.Now()
+	msg, err := c.compressMessage(msg)
+	if err != nil {
+		return err
+	}
+	msg.Seq = c.nextSeq(dest)
+	c.stats.messagesSent.Add(1)
+	c.stats.bytesSent.Add(int64(len(msg.Data)))
+	err = c.sendWithRetry(ctx, dest, msg)
+	if err != nil && ctx.Err() != nil {
+		return c.waitError(op, dest, int(msg.Tag), start, ctx.Err())
+	}
+	return transportError(err)
+}
+
+// Recv is MPI_Recv on c
+func (c *Comm) Recv(source int, tag int) ([]byte, error) {
+	return c.RecvCtx(context.Background(), source, tag)
+}
+
+// RecvCtx is MPI_RecvCtx on c
+func (c *Comm) RecvCtx(ctx context.Context, source int, tag int) ([]byte, error) {
+	if err := c.checkSource(source, tag); err != nil {
+		return nil, c.handleError(err)
+	}
+	data, err := c.recv(ctx, "MPI_Recv", source, tag)
+	return data, c.handleError(err)
+}
+
+// recv receives from source on behalf of op, which names the call in errors
+func (c *Comm) recv(ctx context.Context, op string, source int, tag int) ([]byte, error) {
 	req := &RecvRequest{
 		Source: int32(source),
 		Tag:    int32(tag),
 	}
-	msg, err := mpiServerInstance.Recv(context.Background(), req)
+	msg, err := c.server.Recv(ctx, op, req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,82 @@
+package mpi_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi/mpitest"
+)
+
+func TestSsendWaitsForReceive(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	runOnEachTransport(t, 2, mpi.Config{}, func(comm *mpi.Comm) error {
+		if comm.Rank() == 1 {
+			time.Sleep(delay)
+			_, err := comm.Recv(0, 0)
+			return err
+		}
+		start := time.Now()
+		if err := comm.Ssend([]byte("sync"), 1, 0); err != nil {
+			return err
+		}
+		if waited := time.Since(start); waited < delay {
+			return fmt.Errorf("Ssend returned after %v, before the receive was posted", waited)
+		}
+		return nil
+	})
+}
+
+func TestRsendNeedsPostedReceive(t *testing.T) {
+	runOnEachTransport(t, 2, mpi.Config{}, func(comm *mpi.Comm) error {
+		if comm.Rank() == 0 {
+			if err := comm.Rsend([]byte("early"), 1, 1); err == nil {
+				return fmt.Errorf("Rsend without a posted receive succeeded")
+			}
+		}
+		if err := comm.Barrier(); err != nil {
+			return err
+		}
+		if comm.Rank() == 1 {
+			data, err := comm.Recv(0, 2)
+			if err == nil && string(data) != "ready" {
+				err = fmt.Errorf("received %q", data)
+			}
+			return err
+		}
+		// The receive is posted at some point after the barrier
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			err := comm.Rsend([]byte("ready"), 1, 2)
+			if err == nil {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("Rsend never matched the posted receive: %w", err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+func TestHarnessTransports(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		run  func(fn func(comm *mpi.Comm) error) error
+		want string
+	}{
+		{"Run", func(fn func(comm *mpi.Comm) error) error { return mpitest.Run(2, fn) }, mpi.TransportGRPC},
+		{"RunConfig", func(fn func(comm *mpi.Comm) error) error { return mpitest.RunConfig(2, mpi.Config{}, fn) }, mpi.TransportGRPC + "+shm"},
+	} {
+		err := tc.run(func(comm *mpi.Comm) error {
+			if got := comm.Transport(); got != tc.want {
+				return fmt.Errorf("transport %q, want %q", got, tc.want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
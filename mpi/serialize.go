@@ -1,10 +1,8 @@
 package mpi
 
 import (
-	"bytes"
 	"encoding/gob"
-	"fmt"
-	"reflect"
+	"sync/atomic"
 )
 
 func init() {
@@ -15,46 +13,82 @@ func init() {
 	gob.Register(float64(0))
 }
 
-// Serialize serializes data into bytes with improved error handling
-func Serialize(data interface{}) []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+// defaultCodec is used by Serialize, Deserialize and the collectives. It is
+// swapped atomically, since SetCodec may run while other goroutines encode.
+var defaultCodec atomic.Pointer[Codec] // nil means GobCodec
 
-	// Handle different types for registration
-	switch reflect.TypeOf(data).Kind() {
-	case reflect.Slice, reflect.Array:
-		v := reflect.ValueOf(data)
-		if v.Len() > 0 {
-			// Register the element type
-			gob.Register(v.Index(0).Interface())
-		}
+// SetCodec selects the codec used by Serialize, Deserialize and the
+// collective operations. Every process must select the same codec, before
+// communicating. It is safe to call while other goroutines communicate, but
+// a message is only decoded correctly if it was encoded with the same codec.
+func SetCodec(c Codec) {
+	if c == nil {
+		defaultCodec.Store(nil)
+		return
 	}
+	defaultCodec.Store(&c)
+}
 
-	if err := enc.Encode(data); err != nil {
-		// Instead of fatal log, return an error or panic with more context
-		panic(fmt.Sprintf("Serialization error for type %T: %v", data, err))
+// GetCodec returns the codec selected with SetCodec
+func GetCodec() Codec {
+	if c := defaultCodec.Load(); c != nil {
+		return *c
 	}
-	return buf.Bytes()
+	return GobCodec
+}
+
+// Serialize serializes data into bytes with the selected codec
+func Serialize(data interface{}) ([]byte, error) {
+	return SerializeWith(GetCodec(), data)
 }
 
-// Deserialize deserializes bytes into the provided interface with improved error handling
+// Deserialize deserializes bytes into the provided interface with the selected codec
 func Deserialize(data []byte, v interface{}) error {
-	if len(data) == 0 {
-		return fmt.Errorf("cannot deserialize empty byte slice")
+	return DeserializeWith(GetCodec(), data, v)
+}
+
+// SetCodec selects the codec c uses for the collective operations instead of
+// the package default. nil reverts to the package default. Like the package
+// SetCodec, it is safe to call while c is in use.
+func (c *Comm) SetCodec(codec Codec) {
+	if codec == nil {
+		c.codec.Store(nil)
+		return
 	}
+	c.codec.Store(&codec)
+}
 
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
+// Codec returns the codec c uses for the collective operations
+func (c *Comm) Codec() Codec {
+	if codec := c.codec.Load(); codec != nil {
+		return *codec
+	}
+	return GetCodec()
+}
+
+func (c *Comm) serialize(data interface{}) ([]byte, error) {
+	return SerializeWith(c.Codec(), data)
+}
 
-	// Check if the target is a pointer
-	rv := reflect.ValueOf(v)
-	if rv.Kind() != reflect.Ptr {
-		return fmt.Errorf("destination must be a pointer, got %T", v)
+func (c *Comm) deserialize(data []byte, v interface{}) error {
+	return DeserializeWith(c.Codec(), data, v)
+}
+
+// SerializeWith serializes data into bytes with a specific codec. Its errors
+// have class MPI_ERR_TYPE.
+func SerializeWith(c Codec, data interface{}) ([]byte, error) {
+	buf, err := c.Marshal(data)
+	if err != nil {
+		return nil, errorf(MPI_ERR_TYPE, "%s codec: %w", c.Name(), err)
 	}
+	return buf, nil
+}
 
-	// Actual decoding with error return
-	if err := dec.Decode(v); err != nil {
-		return fmt.Errorf("deserialization error for type %T: %v", v, err)
+// DeserializeWith deserializes bytes into the provided interface with a
+// specific codec. Its errors have class MPI_ERR_TYPE.
+func DeserializeWith(c Codec, data []byte, v interface{}) error {
+	if err := c.Unmarshal(data, v); err != nil {
+		return errorf(MPI_ERR_TYPE, "%s codec: %w", c.Name(), err)
 	}
 	return nil
 }
@@ -0,0 +1,55 @@
+package mpi
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSetCodecWhileCommunicating(t *testing.T) {
+	defer SetCodec(nil)
+	comms := newTestComms(t, 2, Config{})
+	const rounds = 200
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if i%2 == 0 {
+				SetCodec(RawCodec)
+				comms[0].SetCodec(GobCodec)
+			} else {
+				SetCodec(nil)
+				comms[0].SetCodec(nil)
+			}
+		}
+	}()
+	for i := 0; i < rounds; i++ {
+		if _, err := Serialize([]float64{1}); err != nil {
+			t.Fatal(err)
+		}
+		if comms[0].Codec() == nil || GetCodec() == nil {
+			t.Fatal("no codec selected")
+		}
+	}
+	wg.Wait()
+}
+
+func TestCommCodecFallsBackToDefault(t *testing.T) {
+	defer SetCodec(nil)
+	comms := newTestComms(t, 1, Config{})
+	if got := comms[0].Codec(); got != GobCodec {
+		t.Errorf("default codec is %s, want gob", got.Name())
+	}
+	SetCodec(MsgpackCodec)
+	if got := comms[0].Codec(); got != MsgpackCodec {
+		t.Errorf("codec after SetCodec is %s, want msgpack", got.Name())
+	}
+	comms[0].SetCodec(RawCodec)
+	if got := comms[0].Codec(); got != RawCodec {
+		t.Errorf("codec after Comm.SetCodec is %s, want raw", got.Name())
+	}
+	comms[0].SetCodec(nil)
+	if got := comms[0].Codec(); got != MsgpackCodec {
+		t.Errorf("codec after Comm.SetCodec(nil) is %s, want msgpack", got.Name())
+	}
+}
@@ -0,0 +1,292 @@
+//go:build linux
+
+package mpi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// A shared-memory segment holds two single-producer single-consumer byte
+// rings: one carrying requests from the rank that created it to the rank
+// that attached, and one carrying replies back. The first page is a header
+// with the identity of the segment and the control block of each ring.
+const (
+	shmMagic      = "MPISHM01"
+	shmHeaderSize = 4096
+	shmDataSize   = 4 << 20  // Request ring
+	shmReplySize  = 64 << 10 // Reply ring
+	shmSize       = shmHeaderSize + shmDataSize + shmReplySize
+
+	// Header fields
+	shmOffMagic   = 0
+	shmOffSrc     = 8
+	shmOffDst     = 12
+	shmOffNonce   = 16
+	shmOffData    = 256 // Control block of the request ring
+	shmOffReplies = 512 // Control block of the reply ring
+
+	// Control block fields. The positions are on separate cache lines so
+	// the two sides do not contend for them.
+	ringHead          = 0   // Bytes consumed so far
+	ringTail          = 64  // Bytes produced so far
+	ringDataSeq       = 128 // Bumped whenever bytes are produced
+	ringSpaceSeq      = 132 // Bumped whenever bytes are consumed
+	ringReaderWaiting = 136
+	ringWriterWaiting = 140
+	ringClosed        = 144
+
+	// A waiting side spins this many times before sleeping in the kernel,
+	// and wakes up at least this often to check for a closed ring
+	shmSpins       = 20
+	shmWaitTimeout = 10 * time.Millisecond
+)
+
+// shmRing is one direction of a segment. Positions only ever grow; the
+// offset into data is the position modulo its length.
+type shmRing struct {
+	ctl  []byte
+	data []byte
+}
+
+func (r *shmRing) u64(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.ctl[off]))
+}
+
+func (r *shmRing) u32(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.ctl[off]))
+}
+
+func (r *shmRing) closed() bool {
+	return atomic.LoadUint32(r.u32(ringClosed)) != 0
+}
+
+// write copies all of p into the ring, waiting for the reader to make room
+func (r *shmRing) write(p []byte) (int, error) {
+	head, tail := r.u64(ringHead), r.u64(ringTail)
+	size := uint64(len(r.data))
+	written := 0
+	for written < len(p) {
+		if r.closed() {
+			return written, io.ErrClosedPipe
+		}
+		t := atomic.LoadUint64(tail)
+		free := size - (t - atomic.LoadUint64(head))
+		if free == 0 {
+			r.wait(ringSpaceSeq, ringWriterWaiting, func() bool {
+				return atomic.LoadUint64(head) != t-size || r.closed()
+			})
+			continue
+		}
+		n := int(min(free, uint64(len(p)-written)))
+		off := t % size
+		c := copy(r.data[off:], p[written:written+n])
+		copy(r.data, p[written+c:written+n])
+		atomic.StoreUint64(tail, t+uint64(n))
+		written += n
+		r.notify(ringDataSeq, ringReaderWaiting)
+	}
+	return written, nil
+}
+
+// read copies at least one byte out of the ring into p, waiting for the
+// writer if it is empty. It returns io.EOF once the ring is closed and drained.
+func (r *shmRing) read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	head, tail := r.u64(ringHead), r.u64(ringTail)
+	size := uint64(len(r.data))
+	for {
+		h := atomic.LoadUint64(head)
+		used := atomic.LoadUint64(tail) - h
+		if used == 0 {
+			if r.closed() {
+				return 0, io.EOF
+			}
+			r.wait(ringDataSeq, ringReaderWaiting, func() bool {
+				return atomic.LoadUint64(tail) != h || r.closed()
+			})
+			continue
+		}
+		n := int(min(used, uint64(len(p))))
+		off := h % size
+		c := copy(p[:n], r.data[off:])
+		copy(p[c:n], r.data)
+		atomic.StoreUint64(head, h+uint64(n))
+		r.notify(ringSpaceSeq, ringWriterWaiting)
+		return n, nil
+	}
+}
+
+// wait blocks until ready reports true, the other side bumps seq, or a
+// timeout passes. The other side only makes the futex call to wake us if
+// the waiting flag is set.
+func (r *shmRing) wait(seqOff, waitingOff int, ready func() bool) {
+	for i := 0; i < shmSpins; i++ {
+		if ready() {
+			return
+		}
+		runtime.Gosched()
+	}
+	seq, waiting := r.u32(seqOff), r.u32(waitingOff)
+	s := atomic.LoadUint32(seq)
+	atomic.StoreUint32(waiting, 1)
+	if !ready() {
+		futexWait(seq, s, shmWaitTimeout)
+	}
+	atomic.StoreUint32(waiting, 0)
+}
+
+func (r *shmRing) notify(seqOff, waitingOff int) {
+	seq := r.u32(seqOff)
+	atomic.AddUint32(seq, 1)
+	if atomic.LoadUint32(r.u32(waitingOff)) != 0 {
+		futexWake(seq)
+	}
+}
+
+// shutdown closes the ring and wakes both sides
+func (r *shmRing) shutdown() {
+	atomic.StoreUint32(r.u32(ringClosed), 1)
+	for _, off := range []int{ringDataSeq, ringSpaceSeq} {
+		atomic.AddUint32(r.u32(off), 1)
+		futexWake(r.u32(off))
+	}
+}
+
+// shmSegment is one side's mapping of a segment
+type shmSegment struct {
+	mem     []byte
+	in, out *shmRing
+	mu      sync.RWMutex // Held for reading during every Read and Write, so the mapping outlives them
+	once    sync.Once
+}
+
+func newShmSegment(mem []byte, creator bool) *shmSegment {
+	requests := &shmRing{
+		ctl:  mem[shmOffData:shmOffReplies],
+		data: mem[shmHeaderSize : shmHeaderSize+shmDataSize],
+	}
+	replies := &shmRing{
+		ctl:  mem[shmOffReplies:shmHeaderSize],
+		data: mem[shmHeaderSize+shmDataSize : shmSize],
+	}
+	if creator {
+		return &shmSegment{mem: mem, in: replies, out: requests}
+	}
+	return &shmSegment{mem: mem, in: requests, out: replies}
+}
+
+func (s *shmSegment) Read(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.mem == nil {
+		return 0, io.EOF
+	}
+	return s.in.read(p)
+}
+
+func (s *shmSegment) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.mem == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return s.out.write(p)
+}
+
+// Close closes both rings, so the other side sees the end of the stream,
+// and unmaps the segment once no Read or Write is using it
+func (s *shmSegment) Close() error {
+	s.once.Do(func() {
+		s.in.shutdown()
+		s.out.shutdown()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		syscall.Munmap(s.mem)
+		s.mem = nil
+	})
+	return nil
+}
+
+// createShmSegment creates and maps a new segment at path for traffic from
+// rank src to rank dst
+func createShmSegment(path string, src, dst int, nonce []byte) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Allocate up front: running out of space in tmpfs later would be a
+	// SIGBUS on first touch rather than an error here
+	if err := syscall.Fallocate(int(f.Fd()), 0, 0, shmSize); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("error allocating shared memory: %v", err)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, shmSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	copy(mem[shmOffMagic:], shmMagic)
+	binary.LittleEndian.PutUint32(mem[shmOffSrc:], uint32(src))
+	binary.LittleEndian.PutUint32(mem[shmOffDst:], uint32(dst))
+	copy(mem[shmOffNonce:shmOffNonce+shmNonceSize], nonce)
+	return newShmSegment(mem, true), nil
+}
+
+// openShmSegment maps the segment at path, which must have been created by
+// rank src for rank dst with the given nonce
+func openShmSegment(path string, src, dst int, nonce []byte) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != shmSize {
+		return nil, fmt.Errorf("segment %s has size %d, expected %d", path, info.Size(), shmSize)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, shmSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	if string(mem[shmOffMagic:shmOffMagic+len(shmMagic)]) != shmMagic ||
+		binary.LittleEndian.Uint32(mem[shmOffSrc:]) != uint32(src) ||
+		binary.LittleEndian.Uint32(mem[shmOffDst:]) != uint32(dst) ||
+		!bytes.Equal(mem[shmOffNonce:shmOffNonce+shmNonceSize], nonce) {
+		syscall.Munmap(mem)
+		return nil, errors.New("segment header does not match")
+	}
+	return newShmSegment(mem, false), nil
+}
+
+const (
+	futexWaitOp = 0 // FUTEX_WAIT; not FUTEX_PRIVATE, since the word is shared between processes
+	futexWakeOp = 1 // FUTEX_WAKE
+)
+
+func futexWait(addr *uint32, val uint32, timeout time.Duration) {
+	ts := syscall.NsecToTimespec(int64(timeout))
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWaitOp, uintptr(val),
+		uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+func futexWake(addr *uint32) {
+	syscall.Syscall6(syscall.SYS_FUTEX, uintptr(unsafe.Pointer(addr)), futexWakeOp, math.MaxInt32, 0, 0, 0)
+}
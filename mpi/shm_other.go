@@ -0,0 +1,18 @@
+//go:build !linux
+
+package mpi
+
+import (
+	"errors"
+	"io"
+)
+
+var errShmUnsupported = errors.New("shared memory transport is only supported on Linux")
+
+func createShmSegment(path string, src, dst int, nonce []byte) (io.ReadWriteCloser, error) {
+	return nil, errShmUnsupported
+}
+
+func openShmSegment(path string, src, dst int, nonce []byte) (io.ReadWriteCloser, error) {
+	return nil, errShmUnsupported
+}
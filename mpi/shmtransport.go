@@ -0,0 +1,208 @@
+package mpi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultSharedMemoryDir is where shared-memory segments are created
+const DefaultSharedMemoryDir = "/dev/shm"
+
+// tagShmAttach marks the control message that asks a peer to attach to a
+// shared-memory segment. User tags are never negative.
+const tagShmAttach = -2
+
+const shmNonceSize = 16
+
+// shmTransport carries traffic between ranks on the same host through
+// shared memory and everything else through a network transport. When a
+// connection to a peer that may be local is opened, a segment is created and
+// the peer is asked over the network to attach to it; only if that works is
+// the segment used. The network request is authenticated as usual, and the
+// segment is named by a random nonce, so only the intended peer finds it.
+type shmTransport struct {
+	comm    *Comm
+	network Transport
+	dir     string
+	job     string // Distinguishes segments of jobs running at the same time
+
+	mu       sync.Mutex
+	attached map[io.Closer]struct{}
+	wg       sync.WaitGroup
+}
+
+func newShmTransport(c *Comm, network Transport, dir string) *shmTransport {
+	if dir == "" {
+		dir = DefaultSharedMemoryDir
+	}
+	h := sha256.New()
+	for r := 0; r < c.size; r++ {
+		fmt.Fprintln(h, c.addresses[r])
+	}
+	return &shmTransport{
+		comm:     c,
+		network:  network,
+		dir:      dir,
+		job:      hex.EncodeToString(h.Sum(nil)[:8]),
+		attached: make(map[io.Closer]struct{}),
+	}
+}
+
+func (t *shmTransport) Name() string {
+	return t.network.Name() + "+shm"
+}
+
+func (t *shmTransport) Serve(inbox Inbox) error {
+	return t.network.Serve(&shmInbox{transport: t, inbox: inbox})
+}
+
+func (t *shmTransport) Connect(dest int) (Conn, error) {
+	conn, err := t.network.Connect(dest)
+	if err != nil || dest == t.comm.rank || !t.colocated(dest) {
+		return conn, err
+	}
+	seg, err := t.attach(conn, dest)
+	if err != nil {
+		if status.Code(err) == codes.Unavailable {
+			// The peer is not reachable yet; try again on the next send
+			conn.Close()
+			return nil, err
+		}
+		// Not on this host after all, or no shared memory available
+		t.comm.logger.Debug("using the network instead of shared memory", "peer", dest, "err", err)
+		return conn, nil
+	}
+	return &shmConn{frameConn: newFrameConn(seg, nil, dest, t.comm.frameLimit()), network: conn}, nil
+}
+
+func (t *shmTransport) Close() error {
+	t.mu.Lock()
+	for seg := range t.attached {
+		seg.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	return t.network.Close()
+}
+
+// colocated reports whether rank dest might be on this host, judging by its
+// address. Whether it really is only shows when it tries to attach.
+func (t *shmTransport) colocated(dest int) bool {
+	host, _, err := net.SplitHostPort(t.comm.addresses[dest])
+	if err != nil {
+		return false
+	}
+	if own, _, err := net.SplitHostPort(t.comm.addresses[t.comm.rank]); err == nil && host == own {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		hostname, _ := os.Hostname()
+		return host == "localhost" || strings.EqualFold(host, hostname)
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *shmTransport) segmentPath(src, dst int, nonce []byte) string {
+	return filepath.Join(t.dir, fmt.Sprintf("mpi-%s-%d-%d-%x", t.job, src, dst, nonce))
+}
+
+// attach creates a segment for traffic to dest and has dest map it
+func (t *shmTransport) attach(conn Conn, dest int) (io.ReadWriteCloser, error) {
+	nonce := make([]byte, shmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	path := t.segmentPath(t.comm.rank, dest, nonce)
+	seg, err := createShmSegment(path, t.comm.rank, dest, nonce)
+	if err != nil {
+		return nil, err
+	}
+	// Both sides keep their mappings; the name is no longer needed either
+	// way, and removing it now leaves nothing behind if a rank crashes
+	defer os.Remove(path)
+
+	err = conn.Send(context.Background(), &Message{
+		Source: int32(t.comm.rank),
+		Dest:   int32(dest),
+		Tag:    tagShmAttach,
+		Data:   nonce,
+	})
+	if err != nil {
+		seg.Close()
+		return nil, err
+	}
+	return seg, nil
+}
+
+// shmInbox handles attach requests arriving over the network transport and
+// passes everything else on
+type shmInbox struct {
+	transport *shmTransport
+	inbox     Inbox
+}
+
+func (in *shmInbox) Deliver(ctx context.Context, peer int, msg *Message) error {
+	if msg.Tag != tagShmAttach {
+		return in.inbox.Deliver(ctx, peer, msg)
+	}
+	t := in.transport
+	if err := checkSource(msg, peer); err != nil {
+		return err
+	}
+	src := int(msg.Source)
+	if src < 0 || src >= t.comm.size || len(msg.Data) != shmNonceSize {
+		return status.Error(codes.InvalidArgument, "malformed shared memory attach request")
+	}
+	seg, err := openShmSegment(t.segmentPath(src, t.comm.rank, msg.Data), src, t.comm.rank, msg.Data)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "cannot attach to shared memory of rank %d: %v", src, err)
+	}
+
+	t.mu.Lock()
+	t.attached[seg] = struct{}{}
+	t.wg.Add(1)
+	t.mu.Unlock()
+	go func() {
+		defer t.wg.Done()
+		serveFrames(seg, seg, src, in.inbox, t.comm.frameLimit())
+		seg.Close()
+		t.mu.Lock()
+		delete(t.attached, seg)
+		t.mu.Unlock()
+	}()
+	return nil
+}
+
+// shmConn sends over shared memory while holding on to the network
+// connection that set it up
+type shmConn struct {
+	*frameConn
+	network Conn
+}
+
+func (sc *shmConn) Close() error {
+	sc.frameConn.Close()
+	return sc.network.Close()
+}
@@ -0,0 +1,69 @@
+package mpi
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShmRoundTrip(t *testing.T) {
+	forEachNetworkTransport(t, func(t *testing.T, transport string) {
+		dir := t.TempDir()
+		comms := startComms(t, []Config{
+			{Transport: transport, SharedMemoryDir: dir},
+			{Transport: transport, SharedMemoryDir: dir},
+		})
+		for r, c := range comms {
+			conn, err := c.getConn(1 - r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := conn.(*shmConn); !ok {
+				t.Fatalf("rank %d reaches rank %d through %T, want shared memory", r, 1-r, conn)
+			}
+		}
+
+		// Larger than the reply ring and enough to wrap the request ring
+		data := bytes.Repeat([]byte("shm"), 1<<20)
+		for i := 0; i < 3; i++ {
+			if err := comms[0].Send(data, 1, i); err != nil {
+				t.Fatal(err)
+			}
+			got, err := comms[1].Recv(0, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("message %d: received %d bytes, want %d", i, len(got), len(data))
+			}
+		}
+		if err := comms[1].Send([]byte("reply"), 0, 9); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := comms[0].Recv(1, 9); err != nil || string(got) != "reply" {
+			t.Fatalf("reply: %q, %v", got, err)
+		}
+	})
+}
+
+func TestShmRefusesFramesOverLimit(t *testing.T) {
+	dir := t.TempDir()
+	comms := startComms(t, []Config{
+		{Transport: TransportTCP, SharedMemoryDir: dir, MaxMessageSize: 1 << 10},
+		{Transport: TransportTCP, SharedMemoryDir: dir, MaxMessageSize: 1 << 10},
+	})
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*shmConn); !ok {
+		t.Fatalf("rank 0 reaches rank 1 through %T, want shared memory", conn)
+	}
+	msg := &Message{Source: 0, Dest: 1, Data: make([]byte, 1<<17)}
+	if err := conn.Send(context.Background(), msg); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("oversized frame: got %v, want ResourceExhausted", err)
+	}
+}
@@ -0,0 +1,34 @@
+package mpi
+
+import (
+	"testing"
+)
+
+func TestShmFallsBackToNetworkWhenAttachFails(t *testing.T) {
+	// Rank 1 looks for the segment in a different directory, so it cannot
+	// attach and rank 0 has to keep using the network connection
+	comms := startComms(t, []Config{
+		{Transport: TransportTCP, SharedMemoryDir: t.TempDir()},
+		{Transport: TransportTCP, SharedMemoryDir: t.TempDir()},
+	})
+	if name := comms[0].transport.Name(); name != "tcp+shm" {
+		t.Fatalf("transport %q, want tcp+shm", name)
+	}
+	conn, err := comms[0].getConn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*shmConn); ok {
+		t.Fatal("rank 0 uses shared memory that rank 1 never attached")
+	}
+	if err := comms[0].Send([]byte("over the network"), 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	got, err := comms[1].Recv(0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "over the network" {
+		t.Errorf("received %q", got)
+	}
+}
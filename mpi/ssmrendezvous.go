@@ -0,0 +1,274 @@
+package mpi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// SSMAPI is the part of the SSM client that SSMRendezvous uses, so tests can
+// substitute a fake
+type SSMAPI interface {
+	ssm.GetParametersByPathAPIClient
+	GetParameter(context.Context, *ssm.GetParameterInput, ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	PutParameter(context.Context, *ssm.PutParameterInput, ...func(*ssm.Options)) (*ssm.PutParameterOutput, error)
+}
+
+// SSMRendezvous lets identical instances assign ranks among themselves
+// through SSM Parameter Store. Rank r is claimed by creating the parameter
+// <Path>/rank/<r> with the claimant's address as its value; creation fails if
+// the parameter exists, so every rank has exactly one owner. Path should be
+// unique to the job, as parameters left by an earlier job count as claims.
+//
+// MPI_DISCOVERY=ssm claims a rank under MPI_SSM_PATH for MPI_HOST (default:
+// the host's outbound IP) at MPI_PORT and waits until all MPI_SIZE ranks are
+// claimed. Bootstrap values are kept under <Path>/kv/. The role needs
+// ssm:PutParameter, ssm:GetParametersByPath and ssm:GetParameter, and
+// MPI_SSM_ENDPOINT points at a local stand-in.
+type SSMRendezvous struct {
+	Client  SSMAPI
+	Path    string // Such as /mpi/<job>
+	Size    int
+	Address string // host:port published for the rank claimed
+	// PollInterval is the time between checks while waiting for the other
+	// ranks, DefaultPollInterval if zero
+	PollInterval time.Duration
+}
+
+// Join claims a rank, then waits until all Size ranks are claimed. It
+// returns the rank claimed and the address of every rank. An instance that
+// joins again with the same Address gets back the rank it claimed before.
+func (s *SSMRendezvous) Join(ctx context.Context) (int, map[int]string, error) {
+	if s.Size < 1 {
+		return 0, nil, fmt.Errorf("SSM rendezvous needs the job size")
+	}
+	claimed, err := s.list(ctx, "rank")
+	if err != nil {
+		return 0, nil, err
+	}
+	rank := -1
+	for r, addr := range claimed {
+		if addr == s.Address {
+			rank = r
+		}
+	}
+	for r := 0; r < s.Size && rank < 0; r++ {
+		if _, ok := claimed[r]; ok {
+			continue
+		}
+		_, err := s.Client.PutParameter(ctx, &ssm.PutParameterInput{
+			Name:      aws.String(s.parameter("rank", r)),
+			Value:     aws.String(s.Address),
+			Type:      types.ParameterTypeString,
+			Overwrite: aws.Bool(false),
+		})
+		var exists *types.ParameterAlreadyExists
+		switch {
+		case err == nil:
+			rank = r
+		case !errors.As(err, &exists):
+			return 0, nil, fmt.Errorf("error claiming rank %d in SSM: %v", r, err)
+		}
+	}
+	if rank < 0 {
+		return 0, nil, fmt.Errorf("all %d ranks under %s are already claimed", s.Size, s.Path)
+	}
+
+	addresses, err := s.wait(ctx, "rank", "claimed")
+	if err != nil {
+		return 0, nil, err
+	}
+	return rank, addresses, nil
+}
+
+// wait polls until dir holds a parameter for each of the Size ranks and
+// returns their values. what describes those ranks in the timeout error.
+func (s *SSMRendezvous) wait(ctx context.Context, dir, what string) (map[int]string, error) {
+	interval := s.PollInterval
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+	for {
+		values, err := s.list(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == s.Size {
+			return values, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("SSM rendezvous under %s timed out with %d of %d ranks %s", s.Path, len(values), s.Size, what)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// parameter returns the name of the parameter of rank in dir
+func (s *SSMRendezvous) parameter(dir string, rank int) string {
+	return strings.TrimSuffix(s.Path, "/") + "/" + dir + "/" + strconv.Itoa(rank)
+}
+
+// list returns the values of the rank parameters in dir, such as the ranks
+// claimed so far and their addresses
+func (s *SSMRendezvous) list(ctx context.Context, dir string) (map[int]string, error) {
+	prefix := strings.TrimSuffix(s.Path, "/") + "/" + dir + "/"
+	values := make(map[int]string)
+	pages := ssm.NewGetParametersByPathPaginator(s.Client, &ssm.GetParametersByPathInput{
+		Path: aws.String(strings.TrimSuffix(prefix, "/")),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error reading SSM parameters under %s: %v", s.Path, err)
+		}
+		for _, p := range page.Parameters {
+			r, err := strconv.Atoi(strings.TrimPrefix(aws.ToString(p.Name), prefix))
+			if err != nil || r < 0 || r >= s.Size {
+				continue
+			}
+			values[r] = aws.ToString(p.Value)
+		}
+	}
+	return values, nil
+}
+
+// newSSMBootstrap joins an SSMRendezvous configured by MPI_SSM_PATH,
+// MPI_SIZE, MPI_HOST and MPI_PORT. The client uses the usual AWS
+// configuration, and MPI_SSM_ENDPOINT if set.
+func newSSMBootstrap(ctx context.Context, cfg BootstrapConfig) (Bootstrap, error) {
+	path := os.Getenv("MPI_SSM_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("MPI_SSM_PATH not set")
+	}
+	size, err := sizeFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("MPI_SIZE not set")
+	}
+	host, err := advertisedHost()
+	if err != nil {
+		return nil, err
+	}
+	port, err := portFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading AWS configuration: %v", err)
+	}
+	client := ssm.NewFromConfig(awsCfg, func(o *ssm.Options) {
+		if endpoint := os.Getenv("MPI_SSM_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	s := &SSMRendezvous{
+		Client:  client,
+		Path:    path,
+		Size:    size,
+		Address: net.JoinHostPort(host, strconv.Itoa(port)),
+
+		PollInterval: cfg.PollInterval,
+	}
+	rank, addresses, err := s.Join(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ssmBootstrap{s: s, rank: rank, addresses: addresses}, nil
+}
+
+// ssmBootstrap exchanges values through parameters next to the rank claims:
+// <Path>/kv/<rank>/<key> holds a value, base64-encoded since parameters are
+// text, and <Path>/fence/<n>/<rank> marks that a rank reached its n-th fence.
+// SSM does not store empty parameters, so Put rejects empty values.
+type ssmBootstrap struct {
+	s         *SSMRendezvous
+	rank      int
+	addresses map[int]string
+	fences    int
+}
+
+func (b *ssmBootstrap) Rank() int { return b.rank }
+func (b *ssmBootstrap) Size() int { return b.s.Size }
+
+func (b *ssmBootstrap) Put(ctx context.Context, key string, value []byte) error {
+	if err := checkBootstrapKey(key); err != nil {
+		return err
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("SSM bootstrap cannot store an empty value for %s", key)
+	}
+	_, err := b.s.Client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(b.key(b.rank, key)),
+		Value:     aws.String(base64.StdEncoding.EncodeToString(value)),
+		Type:      types.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("error putting %s in SSM: %v", key, err)
+	}
+	return nil
+}
+
+func (b *ssmBootstrap) Get(ctx context.Context, rank int, key string) ([]byte, error) {
+	if rank < 0 || rank >= b.s.Size {
+		return nil, fmt.Errorf("rank %d out of range for a job of size %d", rank, b.s.Size)
+	}
+	if key == BootstrapAddressKey {
+		return []byte(b.addresses[rank]), nil
+	}
+	if err := checkBootstrapKey(key); err != nil {
+		return nil, err
+	}
+	out, err := b.s.Client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(b.key(rank, key))})
+	var notFound *types.ParameterNotFound
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("%w: %s of rank %d", ErrBootstrapKeyNotFound, key, rank)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s of rank %d from SSM: %v", key, rank, err)
+	}
+	value, err := base64.StdEncoding.DecodeString(aws.ToString(out.Parameter.Value))
+	if err != nil {
+		return nil, fmt.Errorf("SSM parameter %s is not base64: %v", b.key(rank, key), err)
+	}
+	return value, nil
+}
+
+func (b *ssmBootstrap) Fence(ctx context.Context) error {
+	dir := fenceKey(b.fences)
+	_, err := b.s.Client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      aws.String(b.s.parameter(dir, b.rank)),
+		Value:     aws.String(time.Now().UTC().Format(time.RFC3339)),
+		Type:      types.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("error entering fence %d in SSM: %v", b.fences, err)
+	}
+	if _, err := b.s.wait(ctx, dir, "at fence "+strconv.Itoa(b.fences)); err != nil {
+		return err
+	}
+	b.fences++
+	return nil
+}
+
+func (b *ssmBootstrap) Close() error { return nil }
+
+func (b *ssmBootstrap) key(rank int, key string) string {
+	return strings.TrimSuffix(b.s.Path, "/") + "/kv/" + strconv.Itoa(rank) + "/" + key
+}
@@ -0,0 +1,174 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// fakeSSM is an in-memory parameter store. If gate is set, the first
+// gateCalls listings return only once all of them have been taken, so that
+// their callers act on the same stale view of the store.
+type fakeSSM struct {
+	mu        sync.Mutex
+	params    map[string]string
+	conflicts int // Creations refused because the parameter existed
+
+	gate      *sync.WaitGroup
+	gateCalls int
+}
+
+func newFakeSSM() *fakeSSM {
+	return &fakeSSM{params: make(map[string]string)}
+}
+
+func (f *fakeSSM) GetParametersByPath(ctx context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	f.mu.Lock()
+	prefix := aws.ToString(in.Path) + "/"
+	out := &ssm.GetParametersByPathOutput{}
+	for name, value := range f.params {
+		if rest, ok := strings.CutPrefix(name, prefix); ok && !strings.Contains(rest, "/") {
+			out.Parameters = append(out.Parameters, types.Parameter{Name: aws.String(name), Value: aws.String(value)})
+		}
+	}
+	gate := f.gate
+	if gate != nil && f.gateCalls > 0 {
+		f.gateCalls--
+	} else {
+		gate = nil
+	}
+	f.mu.Unlock()
+	if gate != nil {
+		gate.Done()
+		gate.Wait()
+	}
+	return out, nil
+}
+
+func (f *fakeSSM) GetParameter(ctx context.Context, in *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.params[aws.ToString(in.Name)]
+	if !ok {
+		return nil, &types.ParameterNotFound{}
+	}
+	return &ssm.GetParameterOutput{Parameter: &types.Parameter{Name: in.Name, Value: aws.String(value)}}, nil
+}
+
+func (f *fakeSSM) PutParameter(ctx context.Context, in *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := aws.ToString(in.Name)
+	if _, ok := f.params[name]; ok && !aws.ToBool(in.Overwrite) {
+		f.conflicts++
+		return nil, &types.ParameterAlreadyExists{}
+	}
+	f.params[name] = aws.ToString(in.Value)
+	return &ssm.PutParameterOutput{}, nil
+}
+
+func TestSSMRendezvousConcurrentJoins(t *testing.T) {
+	const size = 4
+	client := newFakeSSM()
+	// Every joiner sees no claims, so all of them try rank 0 first
+	client.gate = &sync.WaitGroup{}
+	client.gate.Add(size)
+	client.gateCalls = size
+
+	ranks := make([]int, size)
+	results := make([]map[int]string, size)
+	errs := make([]error, size)
+	var wg sync.WaitGroup
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := &SSMRendezvous{
+				Client:       client,
+				Path:         "/mpi/job-1/",
+				Size:         size,
+				Address:      fmt.Sprintf("10.0.0.%d:5000", i),
+				PollInterval: time.Millisecond,
+			}
+			ranks[i], results[i], errs[i] = s.Join(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("joiner %d: %v", i, err)
+		}
+		if seen[ranks[i]] {
+			t.Fatalf("rank %d claimed twice", ranks[i])
+		}
+		seen[ranks[i]] = true
+		if addr := results[i][ranks[i]]; addr != fmt.Sprintf("10.0.0.%d:5000", i) {
+			t.Errorf("joiner %d has rank %d at %s", i, ranks[i], addr)
+		}
+		if !reflect.DeepEqual(results[i], results[0]) {
+			t.Errorf("joiners 0 and %d disagree: %v and %v", i, results[0], results[i])
+		}
+	}
+	if client.conflicts < size-1 {
+		t.Errorf("%d conflicting claims, want at least %d", client.conflicts, size-1)
+	}
+}
+
+func TestSSMRendezvousRejoin(t *testing.T) {
+	client := newFakeSSM()
+	client.params["/mpi/job-1/rank/0"] = "10.0.0.9:5000"
+	client.params["/mpi/job-1/rank/1"] = "10.0.0.1:5000"
+	s := &SSMRendezvous{Client: client, Path: "/mpi/job-1", Size: 2, Address: "10.0.0.1:5000"}
+	rank, addresses, err := s.Join(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rank != 1 {
+		t.Errorf("rejoined as rank %d, want 1", rank)
+	}
+	if want := map[int]string{0: "10.0.0.9:5000", 1: "10.0.0.1:5000"}; !reflect.DeepEqual(addresses, want) {
+		t.Errorf("got %v, want %v", addresses, want)
+	}
+	if client.conflicts != 0 || len(client.params) != 2 {
+		t.Errorf("rejoining made new claims: %v", client.params)
+	}
+}
+
+func TestSSMRendezvousAllClaimed(t *testing.T) {
+	client := newFakeSSM()
+	client.params["/mpi/job-1/rank/0"] = "10.0.0.1:5000"
+	client.params["/mpi/job-1/rank/1"] = "10.0.0.2:5000"
+	s := &SSMRendezvous{Client: client, Path: "/mpi/job-1", Size: 2, Address: "10.0.0.3:5000"}
+	if _, _, err := s.Join(context.Background()); err == nil || !strings.Contains(err.Error(), "already claimed") {
+		t.Errorf("got %v, want an error for a full job", err)
+	}
+}
+
+func TestSSMBootstrapValues(t *testing.T) {
+	client := newFakeSSM()
+	s := &SSMRendezvous{Client: client, Path: "/mpi/job-1", Size: 1, Address: "10.0.0.1:5000"}
+	b := &ssmBootstrap{s: s, addresses: map[int]string{0: s.Address}}
+	ctx := context.Background()
+	if err := b.Put(ctx, "key", []byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.Get(ctx, 0, "key"); err != nil || !reflect.DeepEqual(got, []byte{0, 1, 2}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := b.Get(ctx, 0, "other"); err == nil || !strings.Contains(err.Error(), ErrBootstrapKeyNotFound.Error()) {
+		t.Errorf("missing key: got %v", err)
+	}
+	if err := b.Fence(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
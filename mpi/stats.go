@@ -0,0 +1,97 @@
+package mpi
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds communication counters for the calling process
+type Stats struct {
+	MessagesSent     int64
+	BytesSent        int64 // Payload bytes put on the wire, after compression
+	MessagesReceived int64
+	BytesReceived    int64 // Payload bytes taken off the wire, before decompression
+
+	CompressedMessages int64
+	CompressionIn      int64         // Bytes given to the compressor
+	CompressionOut     int64         // Bytes it produced
+	CompressionTime    time.Duration // Time spent compressing
+	DecompressionTime  time.Duration // Time spent decompressing
+
+	SendRetries       int64 // Send attempts repeated after transient network errors
+	DuplicatesDropped int64 // Retried messages that had already arrived
+}
+
+// CompressionRatio returns uncompressed bytes per compressed byte over all
+// compressed messages, or 0 if nothing has been compressed
+func (s Stats) CompressionRatio() float64 {
+	if s.CompressionOut == 0 {
+		return 0
+	}
+	return float64(s.CompressionIn) / float64(s.CompressionOut)
+}
+
+// commStats holds the live counters of a communicator
+type commStats struct {
+	messagesSent       atomic.Int64
+	bytesSent          atomic.Int64
+	messagesReceived   atomic.Int64
+	bytesReceived      atomic.Int64
+	compressedMessages atomic.Int64
+	compressionIn      atomic.Int64
+	compressionOut     atomic.Int64
+	compressionTime    atomic.Int64
+	decompressionTime  atomic.Int64
+	sendRetries        atomic.Int64
+	duplicatesDropped  atomic.Int64
+}
+
+// GetStats returns a snapshot of the world communicator's counters since
+// start-up or the last ResetStats
+func GetStats() Stats {
+	if world == nil {
+		return Stats{}
+	}
+	return world.Stats()
+}
+
+// ResetStats sets every counter of the world communicator back to zero
+func ResetStats() {
+	if world != nil {
+		world.ResetStats()
+	}
+}
+
+// Stats returns a snapshot of the counters since start-up or the last ResetStats
+func (c *Comm) Stats() Stats {
+	s := &c.stats
+	return Stats{
+		MessagesSent:       s.messagesSent.Load(),
+		BytesSent:          s.bytesSent.Load(),
+		MessagesReceived:   s.messagesReceived.Load(),
+		BytesReceived:      s.bytesReceived.Load(),
+		CompressedMessages: s.compressedMessages.Load(),
+		CompressionIn:      s.compressionIn.Load(),
+		CompressionOut:     s.compressionOut.Load(),
+		CompressionTime:    time.Duration(s.compressionTime.Load()),
+		DecompressionTime:  time.Duration(s.decompressionTime.Load()),
+		SendRetries:        s.sendRetries.Load(),
+		DuplicatesDropped:  s.duplicatesDropped.Load(),
+	}
+}
+
+// ResetStats sets every counter back to zero
+func (c *Comm) ResetStats() {
+	s := &c.stats
+	s.messagesSent.Store(0)
+	s.bytesSent.Store(0)
+	s.messagesReceived.Store(0)
+	s.bytesReceived.Store(0)
+	s.compressedMessages.Store(0)
+	s.compressionIn.Store(0)
+	s.compressionOut.Store(0)
+	s.compressionTime.Store(0)
+	s.decompressionTime.Store(0)
+	s.sendRetries.Store(0)
+	s.duplicatesDropped.Store(0)
+}
@@ -0,0 +1,319 @@
+package mpi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Messages larger than streamChunkSize are sent with SendStream in chunks of
+// this size, which keeps every RPC well below the gRPC message size limit
+const streamChunkSize = 4 * 1024 * 1024 // 4 MiB
+
+// incomingStream collects the chunks of a streamed message as they arrive
+type incomingStream struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	total  int64
+	chunks [][]byte
+	done   bool
+	err    error
+}
+
+func newIncomingStream(total int64) *incomingStream {
+	st := &incomingStream{total: total}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+func (st *incomingStream) add(data []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.chunks = append(st.chunks, data)
+	st.cond.Broadcast()
+}
+
+// finish marks the stream complete, or failed if err is not nil
+func (st *incomingStream) finish(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err == nil {
+		var received int64
+		for _, c := range st.chunks {
+			received += int64(len(c))
+		}
+		if received != st.total {
+			err = fmt.Errorf("stream ended after %d of %d bytes", received, st.total)
+		}
+	}
+	st.done = true
+	st.err = err
+	st.cond.Broadcast()
+}
+
+// chunk blocks until chunk i has arrived. It returns io.EOF once i is past
+// the last chunk of a complete stream.
+func (st *incomingStream) chunk(i int) ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for i >= len(st.chunks) && !st.done {
+		st.cond.Wait()
+	}
+	if i < len(st.chunks) {
+		return st.chunks[i], nil
+	}
+	if st.err != nil {
+		return nil, st.err
+	}
+	return nil, io.EOF
+}
+
+// wait blocks until the stream is complete and returns the whole payload
+func (st *incomingStream) wait() ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for !st.done {
+		st.cond.Wait()
+	}
+	if st.err != nil {
+		return nil, st.err
+	}
+	data := make([]byte, 0, st.total)
+	for _, c := range st.chunks {
+		data = append(data, c...)
+	}
+	return data, nil
+}
+
+func (s *grpcService) SendStream(stream MPIServer_SendStreamServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	msg := first.GetHeader()
+	if msg == nil {
+		return status.Error(codes.InvalidArgument, "first chunk of a stream must carry the message header")
+	}
+
+	// Queue the message straight away so that it keeps its place in order
+	// and receivers can start consuming chunks before the rest arrive
+	st := newIncomingStream(first.TotalSize)
+	receive := func() error {
+		if len(first.Data) > 0 {
+			st.add(first.Data)
+		}
+		for {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				st.finish(fmt.Errorf("stream from rank %d broke: %v", msg.Source, err))
+				return err
+			}
+			st.add(chunk.Data)
+		}
+		st.finish(nil)
+		return nil
+	}
+	ctx := stream.Context()
+	if inbox, ok := s.inbox.(*server); ok {
+		err = inbox.deliverStream(ctx, peerRank(ctx), msg, st, receive)
+	} else {
+		// Inboxes that cannot take a partial message get the whole of it
+		if err = receive(); err == nil {
+			msg.Data, err = st.wait()
+		}
+		if err == nil {
+			err = s.inbox.Deliver(ctx, peerRank(ctx), msg)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&Empty{})
+}
+
+// sendStream sends msg to client as a sequence of chunks
+func sendStream(ctx context.Context, client MPIServerClient, msg *Message) error {
+	stream, err := openStream(ctx, client, msg, int64(len(msg.Data)))
+	if err != nil {
+		return err
+	}
+	for off := 0; off < len(msg.Data); off += streamChunkSize {
+		end := min(off+streamChunkSize, len(msg.Data))
+		if err := stream.Send(&Chunk{Data: msg.Data[off:end]}); err != nil {
+			return closeStream(stream, err)
+		}
+	}
+	return closeStream(stream, nil)
+}
+
+// openStream starts a SendStream RPC and sends the envelope of msg
+func openStream(ctx context.Context, client MPIServerClient, msg *Message, total int64) (MPIServer_SendStreamClient, error) {
+	stream, err := client.SendStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	header := &Message{
+		Source:           msg.Source,
+		Dest:             msg.Dest,
+		Tag:              msg.Tag,
+		Mode:             msg.Mode,
+		Compression:      msg.Compression,
+		UncompressedSize: msg.UncompressedSize,
+		Seq:              msg.Seq,
+	}
+	if err := stream.Send(&Chunk{Header: header, TotalSize: total}); err != nil {
+		return nil, closeStream(stream, err)
+	}
+	return stream, nil
+}
+
+// closeStream finishes a stream and returns the server's verdict. A send
+// error is usually io.EOF, in which case the real error comes from the server.
+func closeStream(stream MPIServer_SendStreamClient, sendErr error) error {
+	_, err := stream.CloseAndRecv()
+	if err == nil && sendErr != nil && sendErr != io.EOF {
+		err = sendErr
+	}
+	return err
+}
+
+// recvMessage waits for a matching message without waiting for a streamed
+// payload to arrive in full, so that its chunks can be forwarded early
+func (c *Comm) recvMessage(ctx context.Context, op string, source int, tag int) (*Message, *incomingStream, error) {
+	req := &RecvRequest{
+		Source: int32(source),
+		Tag:    int32(tag),
+	}
+	return c.server.match(ctx, op, req)
+}
+
+// streamConn is implemented by connections that can pass a payload on chunk
+// by chunk while it is still arriving
+type streamConn interface {
+	openStream(ctx context.Context, msg *Message, total int64) (MPIServer_SendStreamClient, error)
+}
+
+// forwardStream relays the chunks of st to each destination as they arrive.
+// orig is the envelope st arrived with; its compression carries over. Each
+// forwarded message is numbered like any other send, and a destination whose
+// stream fails with a transient error is sent the complete payload again
+// under the same sequence number once st has arrived. forwardStream returns
+// the destinations whose connections cannot stream, which the caller must
+// send the complete payload to instead.
+func (c *Comm) forwardStream(ctx context.Context, orig *Message, st *incomingStream, dests []int, tag int) ([]int, error) {
+	type forward struct {
+		dest   int
+		conn   Conn
+		msg    *Message
+		stream MPIServer_SendStreamClient
+	}
+	var rest []int
+	var forwards, retries []*forward
+	closeAll := func() {
+		for _, f := range forwards {
+			if f.stream != nil {
+				f.stream.CloseAndRecv()
+			}
+		}
+	}
+	// failed records that f's stream failed with err, and returns err unless
+	// a retry may get through
+	failed := func(f *forward, err error) error {
+		f.stream = nil
+		if !isTransient(err) || c.retry.MaxAttempts <= 1 {
+			return fmt.Errorf("error forwarding to rank %d: %w", f.dest, transportError(err))
+		}
+		c.logger.Debug("retrying forwarded stream", "rank", c.rank, "dest", f.dest, "tag", tag, "err", err)
+		if f.conn != nil {
+			c.dropConn(f.dest, f.conn)
+		}
+		retries = append(retries, f)
+		return nil
+	}
+
+	for _, dest := range dests {
+		conn, err := c.getConn(dest)
+		sc, ok := conn.(streamConn)
+		if err == nil && !ok {
+			rest = append(rest, dest)
+			continue
+		}
+		f := &forward{dest: dest, conn: conn}
+		f.msg = &Message{
+			Source:           int32(c.rank),
+			Dest:             int32(dest),
+			Tag:              int32(tag),
+			Compression:      orig.Compression,
+			UncompressedSize: orig.UncompressedSize,
+			Seq:              c.nextSeq(dest),
+		}
+		forwards = append(forwards, f)
+		if err == nil {
+			f.stream, err = sc.openStream(ctx, f.msg, st.total)
+		}
+		if err != nil {
+			if err := failed(f, err); err != nil {
+				closeAll()
+				return nil, err
+			}
+		}
+	}
+
+	for i := 0; ; i++ {
+		data, err := st.chunk(i)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		for _, f := range forwards {
+			if f.stream == nil {
+				continue
+			}
+			if err := f.stream.Send(&Chunk{Data: data}); err != nil {
+				if err := failed(f, closeStream(f.stream, err)); err != nil {
+					closeAll()
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var firstErr error
+	for _, f := range forwards {
+		if f.stream == nil {
+			continue
+		}
+		if err := closeStream(f.stream, nil); err != nil {
+			if err := failed(f, err); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		f.stream = nil
+	}
+	if firstErr != nil || len(retries) == 0 {
+		return rest, firstErr
+	}
+
+	data, err := st.wait()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range retries {
+		f.msg.Data = data
+		c.stats.sendRetries.Add(1)
+		if err := c.sendWithRetry(ctx, f.dest, f.msg); err != nil {
+			return nil, fmt.Errorf("error forwarding to rank %d: %w", f.dest, transportError(err))
+		}
+	}
+	return rest, nil
+}
@@ -0,0 +1,54 @@
+package mpi_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+)
+
+func TestStreamedSendReassembles(t *testing.T) {
+	// Several whole chunks and a partial one
+	data := make([]byte, 10<<20+12345)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	// Over gRPC, payloads larger than the 4 MiB chunk size are streamed; the
+	// other transports carry them as one message
+	runOnEachTransport(t, 2, mpi.Config{}, func(comm *mpi.Comm) error {
+		if comm.Rank() == 0 {
+			return comm.Send(data, 1, 0)
+		}
+		got, err := comm.Recv(0, 0)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			return fmt.Errorf("received %d bytes that differ from the %d sent", len(got), len(data))
+		}
+		return nil
+	})
+}
+
+func TestStreamedBcastForwards(t *testing.T) {
+	// With 4 ranks and root 0, rank 2 forwards what it receives to rank 3
+	const n = 1<<20 + 1234 // Over 8 MiB of float64
+	runOnEachTransport(t, 4, mpi.Config{}, func(comm *mpi.Comm) error {
+		data := make([]float64, n)
+		if comm.Rank() == 0 {
+			for i := range data {
+				data[i] = float64(i)
+			}
+		}
+		if err := comm.Bcast(data, n, 0); err != nil {
+			return err
+		}
+		for i, v := range data {
+			if v != float64(i) {
+				return fmt.Errorf("element %d is %v", i, v)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,395 @@
+package mpi
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// The TCP transport exchanges frames of a 4-byte payload length, an 8-byte
+// request ID and the payload, all big-endian. A connection opens with a hello
+// frame (ID 0) carrying the sender's rank and job token proof. After that
+// the dialing side sends protobuf-encoded Messages and the accepting side
+// answers each with a status frame of the same ID: a gRPC status code byte
+// followed by the error text. Requests are answered as they complete, so a
+// synchronous send waiting to be matched does not hold up other messages.
+// A sender that gives up on a request sends an empty frame with the request
+// ID and tcpCancelBit set, so that a waiting synchronous send is withdrawn.
+const (
+	tcpCancelBit     = 1 << 63
+	tcpHeaderSize    = 12
+	tcpMaxHelloSize  = 4096
+	tcpMaxStatusSize = 1 << 20
+	tcpEnvelopeSize  = 1 << 16 // Room for the envelope of a message in a frame
+	tcpHelloTimeout  = 10 * time.Second
+)
+
+// frameLimit is the largest message frame c sends or accepts: a payload of
+// up to its message size limit, and the envelope. Longer frames are refused
+// before anything is allocated for them.
+func (c *Comm) frameLimit() uint32 {
+	if int64(c.maxMessageSize) > math.MaxUint32-tcpEnvelopeSize {
+		return math.MaxUint32
+	}
+	return uint32(int64(c.maxMessageSize) + tcpEnvelopeSize)
+}
+
+type tcpTransport struct {
+	comm *Comm
+	lis  net.Listener
+
+	mu       sync.Mutex
+	accepted map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func newTCPTransport(c *Comm, cfg Config) (Transport, error) {
+	lis, err := listen(c, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpTransport{comm: c, lis: lis, accepted: make(map[net.Conn]struct{})}, nil
+}
+
+func (t *tcpTransport) Name() string {
+	return TransportTCP
+}
+
+func (t *tcpTransport) Serve(inbox Inbox) error {
+	go func() {
+		for {
+			nc, err := t.lis.Accept()
+			if err != nil {
+				return
+			}
+			t.mu.Lock()
+			if t.closed {
+				t.mu.Unlock()
+				nc.Close()
+				return
+			}
+			t.accepted[nc] = struct{}{}
+			t.wg.Add(1)
+			t.mu.Unlock()
+			go func() {
+				defer t.wg.Done()
+				t.serveConn(nc, inbox)
+				t.mu.Lock()
+				delete(t.accepted, nc)
+				t.mu.Unlock()
+			}()
+		}
+	}()
+	return nil
+}
+
+// serveConn authenticates a dialing peer and then passes its messages to inbox
+func (t *tcpTransport) serveConn(nc net.Conn, inbox Inbox) {
+	c := t.comm
+	defer nc.Close()
+	nc.SetDeadline(time.Now().Add(tcpHelloTimeout))
+	certRank := -1
+	if c.serverTLS != nil {
+		tc := tls.Server(nc, c.serverTLS)
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		if certs := tc.ConnectionState().PeerCertificates; len(certs) > 0 {
+			// The chain was verified during the handshake
+			r, err := certificateRank(certs[0])
+			if err != nil {
+				return
+			}
+			certRank = r
+		}
+		nc = tc
+	}
+	r := bufio.NewReader(nc)
+	_, hello, err := readFrame(r, tcpMaxHelloSize)
+	if err != nil {
+		return
+	}
+	var ranks, proofs []string
+	if claimed, proof, ok := strings.Cut(string(hello), " "); ok && proof != "" {
+		ranks, proofs = []string{claimed}, []string{proof}
+	}
+	peer, err := c.identifyPeer(certRank, ranks, proofs)
+	if werr := writeFrame(nc, 0, statusPayload(err)); err != nil || werr != nil {
+		return
+	}
+	nc.SetDeadline(time.Time{})
+	serveFrames(r, nc, peer, inbox, c.frameLimit())
+}
+
+// serveFrames answers the requests read from r on w until r fails or sends
+// a frame longer than max
+func serveFrames(r io.Reader, w io.Writer, peer int, inbox Inbox, max uint32) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+	defer cancel()
+	var writeMu sync.Mutex
+	var mu sync.Mutex
+	cancels := make(map[uint64]context.CancelFunc) // Requests being handled
+	for {
+		id, payload, err := readFrame(r, max)
+		if err != nil {
+			return
+		}
+		if id&tcpCancelBit != 0 {
+			mu.Lock()
+			if stop, ok := cancels[id&^tcpCancelBit]; ok {
+				stop()
+			}
+			mu.Unlock()
+			continue
+		}
+		// Registered before the next frame is read, so a cancel frame for
+		// this request always finds it
+		reqCtx, reqCancel := context.WithCancel(ctx)
+		mu.Lock()
+		cancels[id] = reqCancel
+		mu.Unlock()
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() {
+				mu.Lock()
+				delete(cancels, id)
+				mu.Unlock()
+				reqCancel()
+			}()
+			msg := &Message{}
+			err := proto.Unmarshal(payload, msg)
+			if err != nil {
+				err = status.Errorf(codes.InvalidArgument, "malformed message: %v", err)
+			} else {
+				err = inbox.Deliver(reqCtx, peer, msg)
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			writeFrame(w, id, statusPayload(err))
+		}()
+	}
+}
+
+func (t *tcpTransport) Connect(dest int) (Conn, error) {
+	c := t.comm
+	nc, err := net.Dial("tcp", c.addresses[dest])
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	nc.SetDeadline(time.Now().Add(tcpHelloTimeout))
+	if c.tlsBase != nil {
+		tc := tls.Client(nc, c.clientTLS(dest))
+		if err := tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, status.Errorf(codes.Unavailable, "TLS handshake with rank %d failed: %v", dest, err)
+		}
+		nc = tc
+	}
+
+	// Introduce ourselves and wait for the peer to accept
+	hello := strconv.Itoa(c.rank) + " "
+	if c.jobToken != nil {
+		hello += rankProof(c.jobToken, c.rank)
+	}
+	r := bufio.NewReader(nc)
+	if err := writeFrame(nc, 0, []byte(hello)); err != nil {
+		nc.Close()
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	_, reply, err := readFrame(r, tcpMaxStatusSize)
+	if err == nil {
+		err = parseStatus(reply)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("rank %d refused connection: %v", dest, err)
+	}
+	nc.SetDeadline(time.Time{})
+
+	return newFrameConn(nc, r, dest, c.frameLimit()), nil
+}
+
+func (t *tcpTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	err := t.lis.Close()
+	for nc := range t.accepted {
+		nc.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+	return err
+}
+
+// frameConn sends requests and matches up the replies over a byte stream
+// speaking the TCP transport's framing
+type frameConn struct {
+	rw      io.ReadWriteCloser
+	peer    int
+	max     uint32 // Longest frame sent
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan error // Replies awaited, by request ID
+	err     error                 // Set once the connection has failed
+}
+
+// newFrameConn starts reading replies from r, which buffers rw if not nil.
+// Messages longer than max bytes are refused.
+func newFrameConn(rw io.ReadWriteCloser, r io.Reader, peer int, max uint32) *frameConn {
+	if r == nil {
+		r = rw
+	}
+	fc := &frameConn{rw: rw, peer: peer, max: max, pending: make(map[uint64]chan error)}
+	go fc.readReplies(r)
+	return fc
+}
+
+func (fc *frameConn) Send(ctx context.Context, msg *Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if int64(len(payload)) > int64(fc.max) {
+		return status.Errorf(codes.ResourceExhausted, "message of %d bytes exceeds the frame size limit of %d", len(payload), fc.max)
+	}
+
+	reply := make(chan error, 1)
+	fc.mu.Lock()
+	if fc.err != nil {
+		fc.mu.Unlock()
+		return fc.err
+	}
+	fc.nextID++
+	id := fc.nextID
+	fc.pending[id] = reply
+	fc.mu.Unlock()
+
+	fc.writeMu.Lock()
+	err = writeFrame(fc.rw, id, payload)
+	fc.writeMu.Unlock()
+	if err != nil {
+		fc.fail(err)
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		fc.mu.Lock()
+		_, waiting := fc.pending[id]
+		delete(fc.pending, id)
+		fc.mu.Unlock()
+		if waiting {
+			fc.writeMu.Lock()
+			err := writeFrame(fc.rw, id|tcpCancelBit, nil)
+			fc.writeMu.Unlock()
+			if err != nil {
+				fc.fail(err)
+			}
+		}
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// readReplies hands each status frame to the Send waiting for it
+func (fc *frameConn) readReplies(r io.Reader) {
+	for {
+		id, payload, err := readFrame(r, tcpMaxStatusSize)
+		if err != nil {
+			fc.fail(err)
+			return
+		}
+		fc.mu.Lock()
+		reply, ok := fc.pending[id]
+		delete(fc.pending, id)
+		fc.mu.Unlock()
+		if ok {
+			reply <- parseStatus(payload)
+		}
+	}
+}
+
+// fail fails every waiting Send and all later ones
+func (fc *frameConn) fail(err error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.err == nil {
+		fc.err = status.Errorf(codes.Unavailable, "connection to rank %d lost: %v", fc.peer, err)
+		fc.rw.Close()
+	}
+	for id, reply := range fc.pending {
+		reply <- fc.err
+		delete(fc.pending, id)
+	}
+}
+
+func (fc *frameConn) Peer() int {
+	return fc.peer
+}
+
+func (fc *frameConn) Close() error {
+	fc.fail(net.ErrClosed)
+	return nil
+}
+
+func writeFrame(w io.Writer, id uint64, payload []byte) error {
+	var header [tcpHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(header[4:12], id)
+	bufs := net.Buffers{header[:], payload}
+	_, err := bufs.WriteTo(w)
+	return err
+}
+
+func readFrame(r io.Reader, max uint32) (uint64, []byte, error) {
+	var header [tcpHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[0:4])
+	if n > max {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d", n, max)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint64(header[4:12]), payload, nil
+}
+
+// statusPayload encodes err, which may be nil, for a status frame
+func statusPayload(err error) []byte {
+	st := status.Convert(err)
+	return append([]byte{byte(st.Code())}, st.Message()...)
+}
+
+func parseStatus(payload []byte) error {
+	if len(payload) == 0 {
+		return errors.New("empty status frame")
+	}
+	code := codes.Code(payload[0])
+	if code == codes.OK {
+		return nil
+	}
+	return status.Error(code, string(payload[1:]))
+}
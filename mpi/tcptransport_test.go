@@ -0,0 +1,98 @@
+package mpi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadFrameRejectsOversizedFrame(t *testing.T) {
+	// Only the header arrives; a reader that allocated first would wait for
+	// a 2 GiB payload instead of failing
+	var header [tcpHeaderSize]byte
+	binary.BigEndian.PutUint32(header[0:4], 1<<31)
+	_, _, err := readFrame(bytes.NewReader(header[:]), 1024)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Fatalf("got %v, want the frame refused for its length", err)
+	}
+}
+
+func TestFrameLimitFollowsMaxMessageSize(t *testing.T) {
+	c := &Comm{maxMessageSize: 1 << 20}
+	if got, want := c.frameLimit(), uint32(1<<20+tcpEnvelopeSize); got != want {
+		t.Errorf("frame limit %d, want %d", got, want)
+	}
+	c.maxMessageSize = math.MaxInt
+	want := uint32(math.MaxUint32)
+	if strconv.IntSize == 32 {
+		want = math.MaxInt32 + tcpEnvelopeSize
+	}
+	if got := c.frameLimit(); got != want {
+		t.Errorf("frame limit %d, want %d", got, want)
+	}
+}
+
+func TestTCPRefusesMessagesOverLimit(t *testing.T) {
+	comms := newNetworkComms(t, TransportTCP, []Config{
+		{MaxMessageSize: 1 << 20},
+		{MaxMessageSize: 1 << 10},
+	})
+	if err := comms[1].Send(make([]byte, 1<<17), 0, 0); err == nil {
+		t.Error("rank 1 sent a message over its own limit")
+	}
+	// Rank 1 refuses what rank 0 is willing to send
+	if err := comms[0].Send(make([]byte, 1<<17), 1, 0); err == nil {
+		t.Error("rank 1 accepted a message over its limit")
+	}
+	if err := comms[0].Send([]byte("small"), 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := comms[1].Recv(0, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queued returns the number of messages waiting at s for a receive
+func (s *server) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, msgs := range s.messages {
+		n += len(msgs)
+	}
+	return n
+}
+
+func TestSsendCtxWithdrawsMessage(t *testing.T) {
+	forEachNetworkTransport(t, func(t *testing.T, transport string) {
+		comms := newNetworkComms(t, transport, []Config{{}, {}})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var te *TimeoutError
+		if err := comms[0].SsendCtx(ctx, []byte("withdrawn"), 1, 0); !errors.As(err, &te) {
+			t.Fatalf("got %v, want a *TimeoutError", err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); comms[1].server.queued() != 0; {
+			if time.Now().After(deadline) {
+				t.Fatal("the message is still queued at rank 1")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := comms[0].Send([]byte("kept"), 1, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, err := comms[1].Recv(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "kept" {
+			t.Errorf("received %q, want the message sent after the withdrawn one", got)
+		}
+	})
+}
@@ -0,0 +1,335 @@
+package mpi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TLS modes for MPI_TLS
+const (
+	TLSModeOff    = "off"  // Plaintext (default)
+	TLSModeTLS    = "tls"  // Servers present certificates; clients verify them
+	TLSModeMutual = "mtls" // Both sides present and verify certificates
+)
+
+// TLSConfig locates the certificate material for the calling rank. Every
+// certificate must be signed by the job CA and name its rank with a URI SAN
+// of the form mpi://rank/<n>, as GenerateJobCertificates produces.
+//
+// MPI_Init reads it from the JSON file named by MPI_TLS_CONFIG, overridden by
+// MPI_TLS (the mode), MPI_TLS_CERT, MPI_TLS_KEY and MPI_TLS_CA. MPI_TLS_DIR
+// names a directory written by GenerateJobCertificates instead. TLS applies
+// to both network transports.
+type TLSConfig struct {
+	Mode string `json:"mode"`
+	Cert string `json:"cert"` // PEM certificate of this rank
+	Key  string `json:"key"`  // PEM private key of this rank
+	CA   string `json:"ca"`   // PEM certificate of the job CA
+
+	// CertPEM, KeyPEM and CAPEM, if set, are used instead of reading Cert,
+	// Key and CA
+	CertPEM, KeyPEM, CAPEM []byte `json:"-"`
+}
+
+// loadTLSConfig applies to base the settings in MPI_TLS_CONFIG (a JSON file
+// with the TLSConfig fields) and then the MPI_TLS, MPI_TLS_CERT, MPI_TLS_KEY
+// and MPI_TLS_CA overrides. MPI_TLS_DIR is a shortcut for a directory written
+// by GenerateJobCertificates. A file named here replaces PEM data in base.
+func loadTLSConfig(base TLSConfig, rank int) (TLSConfig, error) {
+	cfg := base
+	if path := os.Getenv("MPI_TLS_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading MPI_TLS_CONFIG: %v", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	}
+	if dir := os.Getenv("MPI_TLS_DIR"); dir != "" {
+		cfg.Cert, cfg.Key, cfg.CA = jobCertificatePaths(dir, rank)
+		if cfg.Mode == "" {
+			cfg.Mode = TLSModeMutual
+		}
+	}
+	override := func(dst *string, name string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	override(&cfg.Mode, "MPI_TLS")
+	override(&cfg.Cert, "MPI_TLS_CERT")
+	override(&cfg.Key, "MPI_TLS_KEY")
+	override(&cfg.CA, "MPI_TLS_CA")
+	if cfg.Cert != base.Cert {
+		cfg.CertPEM = nil
+	}
+	if cfg.Key != base.Key {
+		cfg.KeyPEM = nil
+	}
+	if cfg.CA != base.CA {
+		cfg.CAPEM = nil
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = TLSModeOff
+	}
+	return cfg, nil
+}
+
+// setupTLS prepares the server and client credentials for cfg
+func (c *Comm) setupTLS(cfg TLSConfig) error {
+	c.serverTLS, c.tlsBase = nil, nil
+	switch cfg.Mode {
+	case TLSModeOff, "":
+		return nil
+	case TLSModeTLS, TLSModeMutual:
+	default:
+		return fmt.Errorf("unknown TLS mode %q", cfg.Mode)
+	}
+	if (cfg.Cert == "" && cfg.CertPEM == nil) || (cfg.Key == "" && cfg.KeyPEM == nil) || (cfg.CA == "" && cfg.CAPEM == nil) {
+		return errors.New("TLS needs a certificate, a key and a CA certificate")
+	}
+
+	certPEM, keyPEM, caPEM := cfg.CertPEM, cfg.KeyPEM, cfg.CAPEM
+	var err error
+	if certPEM == nil {
+		if certPEM, err = os.ReadFile(cfg.Cert); err != nil {
+			return fmt.Errorf("error reading TLS certificate: %v", err)
+		}
+	}
+	if keyPEM == nil {
+		if keyPEM, err = os.ReadFile(cfg.Key); err != nil {
+			return fmt.Errorf("error reading TLS key: %v", err)
+		}
+	}
+	if caPEM == nil {
+		if caPEM, err = os.ReadFile(cfg.CA); err != nil {
+			return fmt.Errorf("error reading CA certificate: %v", err)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("error loading TLS key pair: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New("no certificates found in the CA certificate")
+	}
+
+	// Our own certificate must carry our rank, or peers will reject us
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("error parsing TLS certificate: %v", err)
+	}
+	if r, err := certificateRank(leaf); err != nil || r != c.rank {
+		return fmt.Errorf("TLS certificate does not identify rank %d", c.rank)
+	}
+
+	mutual := cfg.Mode == TLSModeMutual
+	c.serverTLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if mutual {
+		c.serverTLS.ClientAuth = tls.RequireAnyClientCert
+		c.serverTLS.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, err := c.verifyPeer(cs, pool, x509.ExtKeyUsageClientAuth, -1)
+			return err
+		}
+	}
+	c.tlsBase = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if mutual {
+		c.tlsBase.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// serverCredentials returns gRPC credentials for serving TLS
+func (c *Comm) serverCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(c.serverTLS)
+}
+
+// clientCredentials returns gRPC credentials that only accept the server of rank dest
+func (c *Comm) clientCredentials(dest int) credentials.TransportCredentials {
+	return credentials.NewTLS(c.clientTLS(dest))
+}
+
+// clientTLS returns a client configuration that only accepts the server of rank dest
+func (c *Comm) clientTLS(dest int) *tls.Config {
+	cfg := c.tlsBase.Clone()
+	// The chain and the rank are checked in VerifyConnection instead of by
+	// host name, since ranks are usually addressed by IP
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		_, err := c.verifyPeer(cs, cfg.RootCAs, x509.ExtKeyUsageServerAuth, dest)
+		return err
+	}
+	return cfg
+}
+
+// verifyPeer checks the peer's chain against the job CA and returns the rank
+// it identifies. If want is not -1 the rank must equal it; otherwise it must
+// be a rank of this job.
+func (c *Comm) verifyPeer(cs tls.ConnectionState, pool *x509.CertPool, usage x509.ExtKeyUsage, want int) (int, error) {
+	if len(cs.PeerCertificates) == 0 {
+		return -1, errors.New("peer presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return -1, fmt.Errorf("peer certificate not signed by the job CA: %v", err)
+	}
+	r, err := certificateRank(leaf)
+	if err != nil {
+		return -1, err
+	}
+	if want != -1 && r != want {
+		return -1, fmt.Errorf("peer certificate identifies rank %d, expected rank %d", r, want)
+	}
+	if r < 0 || r >= c.size {
+		return -1, fmt.Errorf("peer certificate identifies rank %d, outside a job of size %d", r, c.size)
+	}
+	return r, nil
+}
+
+// certificateRank returns the rank named by a certificate's mpi://rank/<n> URI
+func certificateRank(cert *x509.Certificate) (int, error) {
+	for _, u := range cert.URIs {
+		if u.Scheme == "mpi" && u.Host == "rank" {
+			r, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+			if err == nil {
+				return r, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("certificate %q has no mpi://rank/<n> identity", cert.Subject.CommonName)
+}
+
+func rankURI(r int) *url.URL {
+	return &url.URL{Scheme: "mpi", Host: "rank", Path: "/" + strconv.Itoa(r)}
+}
+
+func jobCertificatePaths(dir string, r int) (cert, key, ca string) {
+	return filepath.Join(dir, fmt.Sprintf("rank-%d.pem", r)),
+		filepath.Join(dir, fmt.Sprintf("rank-%d-key.pem", r)),
+		filepath.Join(dir, "ca.pem")
+}
+
+// GenerateJobCertificates creates a throwaway CA for one job and writes its
+// certificate to dir/ca.pem, together with a certificate and key for every
+// rank (dir/rank-<n>.pem and dir/rank-<n>-key.pem). hosts optionally maps
+// ranks to the host names or IPs they are reached at. The CA key is never
+// written, so no further certificates can be issued for the job. Point
+// MPI_TLS_DIR at dir to use the result.
+func GenerateJobCertificates(dir string, size int, hosts map[int]string, validFor time.Duration) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid job size %d", size)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "MPI job CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(filepath.Join(dir, "ca.pem"), "CERTIFICATE", caDER, 0644); err != nil {
+		return err
+	}
+
+	for r := 0; r < size; r++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		template := &x509.Certificate{
+			SerialNumber: randomSerial(),
+			Subject:      pkix.Name{CommonName: fmt.Sprintf("rank-%d", r)},
+			NotBefore:    now.Add(-time.Minute),
+			NotAfter:     now.Add(validFor),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			URIs:         []*url.URL{rankURI(r)},
+		}
+		if host := hosts[r]; host != "" {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = []net.IP{ip}
+			} else {
+				template.DNSNames = []string{host}
+			}
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+		certPath, keyPath, _ := jobCertificatePaths(dir, r)
+		if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+			return err
+		}
+		if err := writePEM(keyPath, "EC PRIVATE KEY", keyDER, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
@@ -0,0 +1,98 @@
+package mpi_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi"
+	"github.com/Otter2022/cloud-native-mpi-for-aws/mpi/mpitest"
+)
+
+// rankTLS returns the mutual TLS settings for rank r of a job whose
+// certificates are in dir
+func rankTLS(dir string, r int) mpi.TLSConfig {
+	return mpi.TLSConfig{
+		Mode: mpi.TLSModeMutual,
+		Cert: filepath.Join(dir, fmt.Sprintf("rank-%d.pem", r)),
+		Key:  filepath.Join(dir, fmt.Sprintf("rank-%d-key.pem", r)),
+		CA:   filepath.Join(dir, "ca.pem"),
+	}
+}
+
+func TestOwnCertificateForWrongRankIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	if err := mpi.GenerateJobCertificates(dir, 2, nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// Every rank gets rank 0's certificate, which rank 1 must refuse to use
+	err := mpitest.RunConfig(2, mpi.Config{TLS: rankTLS(dir, 0)}, func(comm *mpi.Comm) error {
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not identify rank 1") {
+		t.Fatalf("got %v, want rank 1 to reject the certificate of rank 0", err)
+	}
+}
+
+func TestPeerCertificateForWrongRankIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	if err := mpi.GenerateJobCertificates(dir, 3, map[int]string{0: "127.0.0.1", 1: "127.0.0.1", 2: "127.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rank 1's address is served by an impostor holding rank 2's
+	// certificate, which is signed by the job CA but names another rank
+	impostorTLS := rankTLS(dir, 2)
+	cert, err := tls.LoadX509KeyPair(impostorTLS.Cert, impostorTLS.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impostor, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer impostor.Close()
+	go func() {
+		for {
+			conn, err := impostor.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm, err := mpi.NewComm(mpi.Config{
+		Rank:                0,
+		Size:                3,
+		Addresses:           map[int]string{0: lis.Addr().String(), 1: impostor.Addr().String(), 2: "127.0.0.1:1"},
+		Listener:            lis,
+		TLS:                 rankTLS(dir, 0),
+		DisableSharedMemory: true,
+		Retry:               mpi.RetryConfig{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer comm.Finalize()
+
+	err = comm.Send([]byte("secret"), 1, 0)
+	if err == nil || !strings.Contains(err.Error(), "identifies rank 2, expected rank 1") {
+		t.Fatalf("got %v, want the certificate of rank 2 rejected for rank 1", err)
+	}
+}
@@ -0,0 +1,95 @@
+package mpi
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Names of the built-in transports, for MPI_TRANSPORT and Config.Transport
+const (
+	TransportGRPC   = "grpc"   // gRPC over TCP (default)
+	TransportTCP    = "tcp"    // Length-prefixed protobuf frames over TCP
+	TransportMemory = "memory" // Direct delivery between ranks in one process
+)
+
+// Transport carries messages between the ranks of a job. A communicator owns
+// one transport: it calls Serve once, Connect once per destination it sends
+// to, and Close when it is finalized.
+type Transport interface {
+	// Name identifies the transport, e.g. "grpc"
+	Name() string
+	// Serve starts accepting messages for the local rank and hands each to inbox
+	Serve(inbox Inbox) error
+	// Connect returns a connection to rank dest
+	Connect(dest int) (Conn, error)
+	// Close stops serving. Connections returned by Connect are closed separately.
+	Close() error
+}
+
+// Conn is a connection from the local rank to one peer rank
+type Conn interface {
+	// Send delivers msg to the peer's inbox and returns the inbox's verdict.
+	// Messages sent one after another arrive in the same order.
+	Send(ctx context.Context, msg *Message) error
+	// Peer returns the rank at the other end
+	Peer() int
+	Close() error
+}
+
+// Inbox is the receive side of a communicator. Transports pass it every
+// message they take off the wire.
+type Inbox interface {
+	// Deliver queues msg, which was sent by rank peer, or -1 if the transport
+	// does not authenticate its peers. For synchronous sends it blocks until
+	// the message has been matched by a receive. The message is rejected if
+	// its source is not peer.
+	Deliver(ctx context.Context, peer int, msg *Message) error
+}
+
+// TransportFactory creates the transport for communicator c from the
+// configuration it was created with
+type TransportFactory func(c *Comm, cfg Config) (Transport, error)
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]TransportFactory{
+		TransportGRPC:   newGRPCTransport,
+		TransportTCP:    newTCPTransport,
+		TransportMemory: newMemoryTransport,
+	}
+)
+
+// RegisterTransport makes a transport available under name, for selection
+// with MPI_TRANSPORT or Config.Transport
+func RegisterTransport(name string, factory TransportFactory) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = factory
+}
+
+// newTransport creates the transport named by cfg.Transport for c
+func newTransport(c *Comm, cfg Config) (Transport, error) {
+	name := cfg.Transport
+	if name == "" {
+		name = TransportGRPC
+	}
+	transportsMu.RLock()
+	factory, ok := transports[name]
+	transportsMu.RUnlock()
+	if !ok {
+		return nil, errorf(MPI_ERR_ARG, "unknown transport %q (available: %v)", name, transportNames())
+	}
+	return factory(c, cfg)
+}
+
+func transportNames() []string {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}